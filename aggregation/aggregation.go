@@ -0,0 +1,90 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+// Package aggregation implements Aggregation API
+package aggregation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"code.linksmart.eu/hds/historical-datastore/rollups"
+	"github.com/farshidtz/senml"
+)
+
+// Aggregation describes the aggregation of a data stream over fixed time intervals
+type Aggregation struct {
+	registry.Aggregation
+
+	interval time.Duration
+}
+
+// ParseID parses an aggregation ID in the form of <interval>[-<aggregate>,<aggregate>...]
+// All supported aggregates are computed when none is given
+func ParseID(id string) (Aggregation, error) {
+	parts := strings.SplitN(id, common.AggrIDSeparator, 2)
+
+	a := Aggregation{Aggregation: registry.Aggregation{Interval: parts[0]}}
+	var err error
+	a.interval, err = common.ParsePeriod(a.Interval)
+	if err != nil {
+		return Aggregation{}, err
+	}
+
+	if len(parts) == 1 || parts[1] == "" {
+		a.Aggregates = common.SupportedAggregates()
+		return a, nil
+	}
+	for _, aggregate := range strings.Split(parts[1], common.IDSeparator) {
+		if !common.SupportedAggregate(aggregate) {
+			return Aggregation{}, fmt.Errorf("unsupported aggregate: %s", aggregate)
+		}
+		a.Aggregates = append(a.Aggregates, aggregate)
+	}
+	return a, nil
+}
+
+// rolledUp returns true if the data stream declares a rollup which includes this aggregation
+func (a Aggregation) rolledUp(ds *registry.DataStream) bool {
+	for _, declared := range ds.Aggregation {
+		if declared.Interval != a.Interval {
+			continue
+		}
+		covered := true
+		for _, aggregate := range a.Aggregates {
+			found := false
+			for _, d := range declared.Aggregates {
+				found = found || d == aggregate
+			}
+			covered = covered && found
+		}
+		if covered {
+			return true
+		}
+	}
+	return false
+}
+
+// bucket collects the values of a single time interval
+type bucket struct {
+	start  time.Time
+	unit   string
+	values []float64
+}
+
+// records returns one SenML record per aggregate, named <data stream name>/<aggregate>
+func (b *bucket) records(name string, aggregates []string) senml.Pack {
+	pack := make(senml.Pack, 0, len(aggregates))
+	for _, aggregate := range aggregates {
+		v := rollups.Aggregate(aggregate, b.values)
+		pack = append(pack, senml.Record{
+			Name:  name + "/" + aggregate,
+			Unit:  b.unit,
+			Time:  float64(b.start.UnixNano()) / 1e9,
+			Value: &v,
+		})
+	}
+	return pack
+}
@@ -0,0 +1,247 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package aggregation
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/data"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"github.com/farshidtz/senml"
+)
+
+func setupDataStorage(t *testing.T) (data.Storage, func()) {
+	f, err := ioutil.TempFile("", "aggregation_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	storage, disconnect, err := data.NewSenmlStorage(common.DataConf{Backend: common.DataBackendConf{Type: data.SENMLSTORE, DSN: f.Name()}})
+	if err != nil {
+		os.Remove(f.Name())
+		t.Fatal(err)
+	}
+	return storage, func() {
+		disconnect()
+		os.Remove(f.Name())
+	}
+}
+
+func TestParseID(t *testing.T) {
+	aggr, err := ParseID("5m-mean,max")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aggr.interval != 5*time.Minute || len(aggr.Aggregates) != 2 || aggr.ID() != "5m-mean,max" {
+		t.Fatalf("Unexpected aggregation: %+v", aggr)
+	}
+
+	aggr, err = ParseID("1d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aggr.Aggregates) != len(common.SupportedAggregates()) {
+		t.Fatalf("Expected all aggregates, got: %v", aggr.Aggregates)
+	}
+
+	for _, id := range []string{"", "5s-mean", "1h-mode", "h1-max"} {
+		if _, err := ParseID(id); err == nil {
+			t.Errorf("Expected error for %q", id)
+		}
+	}
+}
+
+func TestDataStorageQuery(t *testing.T) {
+	dataStorage, teardown := setupDataStorage(t)
+	defer teardown()
+
+	// 10 minutes of data, one value per minute: 0, 1, ... 9
+	ds := &registry.DataStream{Name: "test/aggr", Type: common.FLOAT}
+	const start = 1543059000.0 // aligned to 5 minutes
+	var pack senml.Pack
+	for i := 0; i < 10; i++ {
+		v := float64(i)
+		pack = append(pack, senml.Record{Name: ds.Name, Value: &v, Unit: "degC", Time: start + float64(i*60)})
+	}
+	_, err := dataStorage.Submit(map[string]senml.Pack{ds.Name: pack}, map[string]*registry.DataStream{ds.Name: ds})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aggr, _ := ParseID("5m-mean,min,max")
+	storage := NewDataStorage(dataStorage)
+
+	q := data.Query{From: time.Unix(0, 0), To: time.Now(), Sort: common.ASC, Limit: -1, PerPage: 1}
+	firstPage, total, next, err := storage.Query(q, aggr, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(firstPage) != 3 || next == nil {
+		t.Fatalf("Expected one bucket with three aggregates and a next page, got %d, %v, %v", total, firstPage, next)
+	}
+	expected := map[string]float64{"test/aggr/mean": 2, "test/aggr/min": 0, "test/aggr/max": 4}
+	for _, r := range firstPage {
+		if r.Time != start || *r.Value != expected[r.Name] || r.Unit != "degC" {
+			t.Errorf("Unexpected aggregate: %+v %v", r, *r.Value)
+		}
+	}
+
+	q.From = *next
+	secondPage, total, next, err := storage.Query(q, aggr, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || next != nil {
+		t.Fatalf("Expected the last bucket, got %d, %v", total, next)
+	}
+	expected = map[string]float64{"test/aggr/mean": 7, "test/aggr/min": 5, "test/aggr/max": 9}
+	for _, r := range secondPage {
+		if r.Time != start+300 || *r.Value != expected[r.Name] {
+			t.Errorf("Unexpected aggregate: %+v %v", r, *r.Value)
+		}
+	}
+
+	// descending order returns the latest bucket first
+	q = data.Query{From: time.Unix(0, 0), To: time.Now(), Sort: common.DESC, Limit: -1, PerPage: 10}
+	descPage, total, _, err := storage.Query(q, aggr, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || descPage[0].Time != start+300 {
+		t.Fatalf("Unexpected descending result: %v", descPage)
+	}
+}
+
+func TestDataStorageQueryRollup(t *testing.T) {
+	dataStorage, teardown := setupDataStorage(t)
+	defer teardown()
+
+	ds := registry.DataStream{
+		Name:        "test/rollup",
+		Type:        common.FLOAT,
+		Aggregation: []registry.Aggregation{{Interval: "5m", Aggregates: []string{"mean", "max", "median"}}},
+	}
+	submit := func(ds registry.DataStream, times ...int) {
+		var pack senml.Pack
+		for _, i := range times {
+			v := float64(i)
+			pack = append(pack, senml.Record{Name: ds.Name, Value: &v, Time: 1543059000 + float64(i*60)})
+		}
+		_, err := dataStorage.Submit(map[string]senml.Pack{ds.Name: pack}, map[string]*registry.DataStream{ds.Name: &ds})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// some records exist before the rollup is declared
+	plain := ds
+	plain.Aggregation = nil
+	err := dataStorage.CreateHandler(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	submit(plain, 0, 1)
+	err = dataStorage.UpdateHandler(plain, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in-order, new bucket and out-of-order records
+	submit(ds, 2, 3)
+	submit(ds, 7, 9)
+	submit(ds, 4, 8)
+	submit(ds, 5, 6)
+
+	aggr, _ := ParseID("5m-max,median")
+	if !aggr.rolledUp(&ds) {
+		t.Fatal("Expected the aggregation to be served from the rollup")
+	}
+	storage := NewDataStorage(dataStorage)
+	for _, sort := range []string{common.ASC, common.DESC} {
+		q := data.Query{From: time.Unix(0, 0), To: time.Now(), Sort: sort, Limit: -1, PerPage: 1}
+		for page := 0; ; page++ {
+			rolledUp, total, next, err := storage.Query(q, aggr, &ds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// compute the same page from the raw records
+			dsWithoutRollup := ds
+			dsWithoutRollup.Aggregation = nil
+			computed, _, _, err := storage.Query(q, aggr, &dsWithoutRollup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != 1 || len(rolledUp) != 2 || !common.CompareSenml(rolledUp, computed) {
+				t.Fatalf("%s page %d: rollup %v does not match the computed aggregates %v", sort, page, rolledUp, computed)
+			}
+			if next == nil {
+				if page != 1 {
+					t.Fatalf("%s: expected two pages, got %d", sort, page+1)
+				}
+				break
+			}
+			if sort == common.DESC {
+				q.To = *next
+			} else {
+				q.From = *next
+			}
+		}
+	}
+}
+
+func TestDataStorageQueryRollupKeep(t *testing.T) {
+	dataStorage, teardown := setupDataStorage(t)
+	defer teardown()
+
+	ds := registry.DataStream{
+		Name:        "test/rollup/keep",
+		Type:        common.FLOAT,
+		Duplicates:  common.DuplicatesKeep,
+		Aggregation: []registry.Aggregation{{Interval: "5m", Aggregates: []string{"sum", "mean"}}},
+	}
+	err := dataStorage.CreateHandler(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the records at 1 and 3 minutes share their times
+	for _, times := range [][]int{{0, 1}, {1, 2}, {3, 3}} {
+		var pack senml.Pack
+		for _, i := range times {
+			v := float64(i)
+			pack = append(pack, senml.Record{Name: ds.Name, Value: &v, Time: 1543059000 + float64(i*60)})
+		}
+		_, err := dataStorage.Submit(map[string]senml.Pack{ds.Name: pack}, map[string]*registry.DataStream{ds.Name: &ds})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	aggr, _ := ParseID("5m-sum,mean")
+	storage := NewDataStorage(dataStorage)
+	q := data.Query{From: time.Unix(0, 0), To: time.Now(), Sort: common.ASC, Limit: -1, PerPage: 1}
+	expected := map[string]float64{"test/rollup/keep/sum": 10, "test/rollup/keep/mean": 10.0 / 6}
+	for _, stage := range []string{"ingestion", "rebuild"} {
+		if stage == "rebuild" {
+			err = dataStorage.UpdateHandler(registry.DataStream{Name: ds.Name}, ds)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		rolledUp, _, _, err := storage.Query(q, aggr, &ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rolledUp) != 2 {
+			t.Fatalf("%s: expected two aggregates, got %v", stage, rolledUp)
+		}
+		for _, r := range rolledUp {
+			if *r.Value != expected[r.Name] {
+				t.Errorf("%s: expected %s to be %v, got %v", stage, r.Name, expected[r.Name], *r.Value)
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package aggregation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/data"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"github.com/gorilla/mux"
+)
+
+// API describes the RESTful HTTP aggregation API
+type API struct {
+	registry registry.Storage
+	storage  Storage
+}
+
+// NewAPI returns the configured Aggregation API
+func NewAPI(registry registry.Storage, storage Storage) *API {
+	return &API{registry, storage}
+}
+
+// Query is a handler for querying aggregated data
+// Expected parameters: aggr_id, id, optional: pagination, query string
+func (api *API) Query(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	timeStart := time.Now()
+	params := mux.Vars(r)
+
+	aggr, err := ParseID(params["aggr_id"])
+	if err != nil {
+		common.ErrorResponse(http.StatusBadRequest, "Error parsing aggregation: "+err.Error(), w)
+		return
+	}
+
+	ds, err := api.registry.Get(params["id"])
+	if err != nil {
+		common.ErrorResponse(http.StatusNotFound,
+			fmt.Sprintf("Error retrieving data source %v from the registry: %v", params["id"], err.Error()),
+			w)
+		return
+	}
+	if ds.Type != common.FLOAT {
+		common.ErrorResponse(http.StatusBadRequest, "Aggregations are only possible with float type.", w)
+		return
+	}
+
+	// Parse query
+	q, err := data.ParseQueryParameters(r.Form)
+	if err != nil {
+		common.ErrorResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	if q.PerPage < 1 || q.PerPage > data.MaxPerPage {
+		common.ErrorResponse(http.StatusBadRequest,
+			fmt.Sprintf("%s must be between 1 and %d", common.ParamPerPage, data.MaxPerPage), w)
+		return
+	}
+
+	pack, total, nextLinkTS, err := api.storage.Query(q, aggr, ds)
+	if err != nil {
+		common.ErrorResponse(http.StatusInternalServerError, "Error retrieving data from the database: "+err.Error(), w)
+		return
+	}
+
+	aggrLoc := common.AggrAPILoc + "/" + aggr.ID() + "/"
+	nextlink := ""
+	if nextLinkTS != nil {
+		nextQuery := q
+		lastPage := false
+		if q.Limit > 0 { //if Limit is given by user reduce the limit by total
+			nextQuery.Limit = q.Limit - total
+			lastPage = nextQuery.Limit <= 0
+		}
+		if !lastPage {
+			if q.Sort == common.DESC {
+				nextQuery.To = *nextLinkTS
+			} else {
+				nextQuery.From = *nextLinkTS
+			}
+			nextlink = aggrLoc + data.GetUrlFromQuery(nextQuery, ds.Name)
+		}
+	}
+
+	recordSet := data.RecordSet{
+		SelfLink: aggrLoc + data.GetUrlFromQuery(q, ds.Name),
+		TimeTook: time.Since(timeStart).Seconds(),
+		Data:     pack,
+		NextLink: nextlink,
+	}
+
+	b, err := json.Marshal(recordSet)
+	if err != nil {
+		common.ErrorResponse(http.StatusInternalServerError, "Error marshalling recordset: "+err.Error(), w)
+		return
+	}
+
+	w.Header().Add("Content-Type", common.DefaultMIMEType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package aggregation
+
+import (
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/data"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"code.linksmart.eu/hds/historical-datastore/rollups"
+	datastore "github.com/dschowta/senml.datastore"
+	"github.com/farshidtz/senml"
+)
+
+// Storage is an interface of an Aggregation storage backend
+type Storage interface {
+	// Queries aggregated data of a data stream
+	// Pagination applies to the time buckets: a page has at most q.PerPage buckets and q.Limit limits the total buckets
+	// The returned time is the start of the next page (From in ascending and To in descending order), if any
+	Query(q data.Query, aggr Aggregation, ds *registry.DataStream) (senml.Pack, int, *time.Time, error)
+}
+
+// DataStorage computes aggregations on the fly from the raw records of a data storage
+type DataStorage struct {
+	storage data.Storage
+}
+
+// NewDataStorage returns an aggregation storage which reads the given data storage
+func NewDataStorage(storage data.Storage) *DataStorage {
+	return &DataStorage{storage}
+}
+
+func (s *DataStorage) Query(q data.Query, aggr Aggregation, ds *registry.DataStream) (senml.Pack, int, *time.Time, error) {
+	if aggr.rolledUp(ds) {
+		return s.queryRollup(q, aggr, ds)
+	}
+
+	maxBuckets := q.PerPage
+	if q.Limit > 0 && q.Limit < maxBuckets {
+		maxBuckets = q.Limit
+	}
+
+	var (
+		pack    senml.Pack
+		total   int
+		current *bucket
+	)
+	// read the raw records page by page, in the requested order
+	rawQuery := data.Query{
+		From:    q.From,
+		To:      q.To,
+		Sort:    q.Sort,
+		Limit:   -1,
+		PerPage: data.MaxPerPage,
+	}
+	for {
+		records, _, next, err := s.storage.Query(rawQuery, ds)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+
+		for _, r := range records {
+			if r.Value == nil {
+				continue
+			}
+			start := rollups.BucketStart(r.Time, aggr.interval)
+			if current != nil && !start.Equal(current.start) {
+				pack = append(pack, current.records(ds.Name, aggr.Aggregates)...)
+				total++
+				current = nil
+
+				if total == maxBuckets {
+					// the page is full and this record begins the next one
+					next := start
+					if q.Sort == common.DESC {
+						next = rollups.BucketEnd(start, aggr.interval)
+					}
+					return pack, total, &next, nil
+				}
+			}
+			if current == nil {
+				current = &bucket{start: start, unit: r.Unit}
+			}
+			current.values = append(current.values, *r.Value)
+		}
+
+		if next == nil {
+			break
+		}
+		rawQuery.Cursor = next
+	}
+
+	if current != nil {
+		pack = append(pack, current.records(ds.Name, aggr.Aggregates)...)
+		total++
+	}
+	return pack, total, nil, nil
+}
+
+// queryRollup reads the aggregates from the series which are continuously updated by the rollup sessions
+func (s *DataStorage) queryRollup(q data.Query, aggr Aggregation, ds *registry.DataStream) (senml.Pack, int, *time.Time, error) {
+	// one series per aggregate. The merged query returns the aggregates of a bucket in the requested order
+	series := make([]*registry.DataStream, 0, len(aggr.Aggregates))
+	names := make(map[string]string)
+	for _, aggregate := range aggr.Aggregates {
+		name := rollups.SeriesName(ds.Name, aggr.Aggregation, aggregate)
+		series = append(series, &registry.DataStream{Name: name, Type: common.FLOAT})
+		names[name] = ds.Name + "/" + aggregate
+	}
+
+	// paginate buckets rather than records
+	rollupQuery := q
+	rollupQuery.PerPage = q.PerPage * len(series)
+	if q.Limit > 0 {
+		rollupQuery.Limit = q.Limit * len(series)
+	}
+	pack, total, next, err := s.storage.Query(rollupQuery, series...)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	for i := range pack {
+		pack[i].Name = names[pack[i].Name]
+	}
+	if next == nil {
+		return pack, total / len(series), nil, nil
+	}
+
+	// the pages end with a complete bucket: the aggregates of the next bucket are moved to the next page
+	end := len(pack)
+	for end > 0 && pack[end-1].Time == next.Time {
+		end--
+	}
+	pack = pack[:end]
+	nextTS := datastore.FromSenmlTime(next.Time)
+	if q.Sort == common.DESC {
+		// same as for computed aggregates, the next page ends with the next bucket
+		nextTS = rollups.BucketEnd(nextTS, aggr.interval)
+	}
+	return pack, len(pack) / len(series), &nextTS, nil
+}
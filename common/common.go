@@ -3,17 +3,29 @@
 package common
 
 import (
+	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/farshidtz/senml"
 )
 
 const (
 	// IDSeparator is used for separation of IDs in the BrokerURL
 	IDSeparator = ","
+	// AggrIDSeparator separates the interval from the aggregates in an aggregation ID
+	AggrIDSeparator = "-"
 
 	// Location of APIs
 	RegistryAPILoc = "/registry"
 	DataAPILoc     = "/data"
+	AggrAPILoc     = "/aggr"
+	// Location of the APIs under the data of the data streams, e.g. /data/{id}/live
+	LiveAPILoc    = "/live"
+	LatestAPILoc  = "/latest"
+	QualityAPILoc = "/quality"
 	// Query parameters
 	ParamPage    = "page"
 	ParamPerPage = "perPage"
@@ -21,6 +33,45 @@ const (
 	ParamFrom    = "from"
 	ParamTo      = "to"
 	ParamSort    = "sort"
+	ParamFormat  = "format"
+	ParamStream  = "stream"
+	ParamFilter  = "filter"
+	ParamAsOf    = "asOf"
+	ParamCount   = "count"
+	ParamCursor  = "cursor"
+	ParamPartial = "partial"
+	ParamStale   = "stale"
+	// Query parameters of value filters, named after the SenML fields
+	ParamValue       = "v"
+	ParamStringValue = "vs"
+	ParamBoolValue   = "vb"
+	ParamUnit        = "u"
+	// Query parameters of resampling
+	ParamStep      = "step"
+	ParamFill      = "fill"
+	ParamFillValue = "fillValue"
+	ParamLayout    = "layout"
+	// Query parameters of downsampling
+	ParamMaxPoints  = "maxPoints"
+	ParamDownsample = "downsample"
+	// Query parameter of the data quality report: the minimum duration of reported gaps
+	ParamGap = "gap"
+	// Response header with the total number of matching entries
+	HeaderTotalCount = "X-Total-Count"
+	// Values for ParamFormat
+	FormatJSON   = "json"
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	// Values for ParamFill
+	FillNull     = "null"     // empty steps without value
+	FillPrevious = "previous" // the last value carried forward
+	FillLinear   = "linear"   // linear interpolation of float values, otherwise as previous
+	FillValue    = "value"    // the value given by ParamFillValue
+	// Values for ParamLayout
+	LayoutWide = "wide" // a row per time with a column per data stream
+	// Values for ParamDownsample
+	DownsampleLTTB   = "lttb"   // Largest-Triangle-Three-Buckets
+	DownsampleMinMax = "minmax" // the minimum and maximum of each bucket
 	// Values for ParamSort
 	ASC  = "asc"  // ascending
 	DESC = "desc" // descending
@@ -34,16 +85,29 @@ const (
 	DATA   = "data"
 )
 
+// Policies for records at the time of an existing record of a data source
+const (
+	DuplicatesOverwrite = "overwrite"
+	DuplicatesReject    = "reject"
+	DuplicatesKeep      = "keep"
+)
+
 var (
 	// APIVersion defines the API version
 	APIVersion = "N/A"
 	// Default MIME type for all responses
 	DefaultMIMEType = "application/json;version=" + APIVersion
+	// MIME type of CSV responses
+	CSVMIMEType = "text/csv"
+	// MIME type of newline delimited JSON responses
+	NDJSONMIMEType = "application/x-ndjson"
 
 	// supported type values
 	supportedTypes = []string{STRING, BOOL, FLOAT, DATA}
 	// supported aggregates
 	supportedAggregates = []string{"mean", "stddev", "sum", "min", "max", "median"}
+	// supported duplicate policies
+	supportedDuplicatePolicies = []string{DuplicatesOverwrite, DuplicatesReject, DuplicatesKeep}
 	// supported period suffixes
 	supportedPeriods = []string{"m", "h", "d", "w"}
 )
@@ -60,6 +124,67 @@ func SupportedPeriod(p string) bool {
 	return re.MatchString(p)
 }
 
+// ParsePeriod converts a supported period (e.g. 5m, 1h, 2w) to a duration
+// The suffixes stand for minutes (m), hours (h), days (d) and weeks (w). A missing number means 1.
+func ParsePeriod(p string) (time.Duration, error) {
+	if p == "" || !SupportedPeriod(p) {
+		return 0, fmt.Errorf("invalid period: %s. Expected a number followed by one of: %s", p, strings.Join(supportedPeriods, ", "))
+	}
+	n := 1
+	if len(p) > 1 {
+		var err error
+		n, err = strconv.Atoi(p[:len(p)-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid period: %s: %s", p, err)
+		}
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("invalid period: %s. Period must be greater than zero", p)
+	}
+
+	var unit time.Duration
+	switch p[len(p)-1:] {
+	case "m":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	case "d":
+		unit = 24 * time.Hour
+	case "w":
+		unit = 7 * 24 * time.Hour
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// durationDays matches the durations of whole days or weeks, which are not supported by time.ParseDuration
+var durationDays = regexp.MustCompile(`^(\d+)(d|w)$`)
+
+// ParseDuration converts a positive duration (e.g. 30s or 1h30m), or a number of days or weeks (e.g. 1d, 2w), to a
+// duration
+func ParseDuration(value string) (time.Duration, error) {
+	var d time.Duration
+	if m := durationDays.FindStringSubmatch(value); m != nil {
+		days, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, err
+		}
+		if m[2] == "w" {
+			days *= 7
+		}
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		var err error
+		d, err = time.ParseDuration(value)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s is not a positive duration", value)
+	}
+	return d, nil
+}
+
 // SupportedPeriods returns supported periods
 func SupportedPeriods() []string {
 	var periods []string
@@ -72,6 +197,18 @@ func SupportedType(t string) bool {
 	return stringInSlice(t, supportedTypes)
 }
 
+// SupportedDuplicatePolicy validates a duplicate policy
+func SupportedDuplicatePolicy(p string) bool {
+	return stringInSlice(p, supportedDuplicatePolicies)
+}
+
+// SupportedAggregates returns supported aggregates
+func SupportedAggregates() []string {
+	aggregates := make([]string, len(supportedAggregates))
+	copy(aggregates, supportedAggregates)
+	return aggregates
+}
+
 // SupportedAggregate validates an aggregate
 func SupportedAggregate(a string) bool {
 	return stringInSlice(a, supportedAggregates)
@@ -85,3 +222,34 @@ func stringInSlice(a string, list []string) bool {
 	}
 	return false
 }
+
+// SenMLFormat returns the SenML format of a media type (e.g. application/senml+cbor)
+// Empty and plain JSON media types stand for SenML JSON
+func SenMLFormat(mediaType string) (senml.Format, bool) {
+	mediaType = strings.TrimSpace(strings.Split(mediaType, ";")[0])
+	switch strings.ToLower(mediaType) {
+	case "", "application/json", senml.MediaTypeSenmlJSON:
+		return senml.JSON, true
+	case senml.MediaTypeSenmlCBOR:
+		return senml.CBOR, true
+	case senml.MediaTypeSenmlXML:
+		return senml.XML, true
+	}
+	return 0, false
+}
+
+// SenMLMediaType returns the media type of a SenML format
+func SenMLMediaType(format senml.Format) string {
+	switch format {
+	case senml.CBOR:
+		return senml.MediaTypeSenmlCBOR
+	case senml.XML:
+		return senml.MediaTypeSenmlXML
+	}
+	return senml.MediaTypeSenmlJSON
+}
+
+// SupportedSenMLMediaTypes returns the media types of the supported SenML formats
+func SupportedSenMLMediaTypes() []string {
+	return []string{senml.MediaTypeSenmlJSON, senml.MediaTypeSenmlCBOR, senml.MediaTypeSenmlXML}
+}
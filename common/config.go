@@ -45,6 +45,8 @@ type WebConfig struct {
 type RegConf struct {
 	Backend          RegBackendConf `json:"backend"`
 	RetentionPeriods []string       `json:"retentionPeriods"`
+	// Stale configures the detection of the data streams which receive no data within their expected interval
+	Stale StaleConf `json:"stale"`
 }
 
 func (c RegConf) ConfiguredRetention(period string) bool {
@@ -55,6 +57,25 @@ func (c RegConf) ConfiguredRetention(period string) bool {
 	return stringInSlice(period, c.RetentionPeriods)
 }
 
+// Stale-stream detection config
+type StaleConf struct {
+	// CheckInterval is the period of checking the data streams for staleness, e.g. 30s (default 1m)
+	CheckInterval string `json:"checkInterval"`
+	// Webhook is the URL to which the events of stale and resumed data streams are posted (optional)
+	Webhook string `json:"webhook"`
+	// MQTT is the broker to which the events are published (optional)
+	MQTT *StaleMQTTConf `json:"mqtt"`
+}
+
+// Stale-stream events MQTT config
+type StaleMQTTConf struct {
+	BrokerURL string `json:"url"`
+	Topic     string `json:"topic"`
+	QoS       byte   `json:"qos"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+}
+
 // Registry backend config
 type RegBackendConf struct {
 	Type string `json:"type"`
@@ -67,6 +88,24 @@ type DataConf struct {
 	// RetentionPeriods is deprecated, will be removed from v0.6.0. Use registry.retentionPeriods instead.
 	RetentionPeriods []string `json:"retentionPeriods"`
 	AutoRegistration bool     `json:"autoRegistration"`
+	// PurgeInterval is the period of enforcing the maximum retention of data streams (default 1h)
+	PurgeInterval string `json:"purgeInterval"`
+	// QuarantineSize is the maximum number of rejected payloads kept for inspection and replay (default 1000)
+	// A negative size disables the quarantine.
+	QuarantineSize int `json:"quarantineSize"`
+	// Queue configures the group commits of the submitted data
+	Queue QueueConf `json:"queue"`
+}
+
+// Ingestion queue config
+type QueueConf struct {
+	// FlushSize is the maximum number of data points per group commit (default 1000)
+	FlushSize int `json:"flushSize"`
+	// FlushInterval is the maximum time which data points wait for a group commit, e.g. 100ms (default 50ms)
+	FlushInterval string `json:"flushInterval"`
+	// Capacity is the maximum number of queued data points (default 100000)
+	// When full, HTTP submissions are refused with 503 and MQTT messages are held back.
+	Capacity int `json:"capacity"`
 }
 
 // Data backend config
@@ -12,6 +12,7 @@ import (
 	"io/ioutil"
 	"net/url"
 	"strings"
+	"time"
 )
 
 // loads service configuration from a file at the given path
@@ -59,6 +60,21 @@ func loadConfig(confPath *string) (*common.Config, error) {
 		}
 	}
 
+	// Check stale-stream detection
+	if conf.Reg.Stale.CheckInterval != "" {
+		if d, err := time.ParseDuration(conf.Reg.Stale.CheckInterval); err != nil || d <= 0 {
+			return nil, fmt.Errorf("Registry stale checkInterval is not valid: %s", conf.Reg.Stale.CheckInterval)
+		}
+	}
+	if conf.Reg.Stale.Webhook != "" {
+		if _, err = url.Parse(conf.Reg.Stale.Webhook); err != nil {
+			return nil, fmt.Errorf("Registry stale webhook should be a valid URL")
+		}
+	}
+	if conf.Reg.Stale.MQTT != nil && (conf.Reg.Stale.MQTT.BrokerURL == "" || conf.Reg.Stale.MQTT.Topic == "") {
+		return nil, fmt.Errorf("Registry stale mqtt url and topic have to be defined")
+	}
+
 	// VALIDATE DATA API CONFIG
 	// Check if backend is supported
 	if !data.SupportedBackends(conf.Data.Backend.Type) {
@@ -70,6 +86,20 @@ func loadConfig(confPath *string) (*common.Config, error) {
 		return nil, err
 	}
 
+	// Check purge interval
+	if conf.Data.PurgeInterval != "" {
+		if _, err = common.ParsePeriod(conf.Data.PurgeInterval); err != nil {
+			return nil, fmt.Errorf("Data purgeInterval is not valid: %s", err)
+		}
+	}
+
+	// Check queue flush interval
+	if conf.Data.Queue.FlushInterval != "" {
+		if d, err := time.ParseDuration(conf.Data.Queue.FlushInterval); err != nil || d <= 0 {
+			return nil, fmt.Errorf("Data queue flushInterval is not valid: %s", conf.Data.Queue.FlushInterval)
+		}
+	}
+
 	// VALIDATE AGGREGATION API CONFIG
 	//
 	//
@@ -4,8 +4,14 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
 	"time"
 
+	"code.linksmart.eu/hds/historical-datastore/common"
+	datastore "github.com/dschowta/senml.datastore"
 	"github.com/farshidtz/senml"
 )
 
@@ -20,6 +26,118 @@ type RecordSet struct {
 	TimeTook float64 `json:"took"`
 	//Next link for the same query, in case there more entries to follow for the same query
 	NextLink string `json:"nextLink"`
+	// Total is the number of records matching the query. It is only given when requested or when navigating by page number
+	Total *int `json:"total,omitempty"`
+	// Page is the requested page number and Pages the number of pages of the query
+	Page  int `json:"page,omitempty"`
+	Pages int `json:"pages,omitempty"`
+}
+
+// TableSet is the wide layout of a RecordSet, with a row per time and a column per data stream
+type TableSet struct {
+	// SelfLink is the SelfLink of the returned table in the Data API
+	SelfLink string `json:"selfLink"`
+	// Columns are the time, followed by the names of the data streams in the order of the query
+	Columns []string `json:"columns"`
+	// Rows are the SenML time, followed by the value of each data stream at that time or null
+	// Records of a data stream which share a time are given in separate rows.
+	Rows [][]interface{} `json:"rows"`
+	// Time is the time of query in seconds
+	TimeTook float64 `json:"took"`
+	//Next link for the same query, in case there more entries to follow for the same query
+	NextLink string `json:"nextLink"`
+	// Total is the number of records (not rows) matching the query, as in a RecordSet
+	Total *int `json:"total,omitempty"`
+	// Page is the requested page number and Pages the number of pages of the query
+	Page  int `json:"page,omitempty"`
+	Pages int `json:"pages,omitempty"`
+}
+
+// NextCursor returns the cursor of the next page, as given in the next link
+// It returns nil on the last page, or if the pages are navigated by number
+func (rs *RecordSet) NextCursor() (*Cursor, error) {
+	if rs.NextLink == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(rs.NextLink)
+	if err != nil {
+		return nil, err
+	}
+	token := u.Query().Get(common.ParamCursor)
+	if token == "" {
+		return nil, nil
+	}
+	return ParseCursor(token)
+}
+
+// SubmitResponse is the response to a data submission
+type SubmitResponse struct {
+	// Duplicates reports the submitted records at the time of another record, and how these were handled
+	Duplicates []DuplicateReport `json:"duplicates,omitempty"`
+	// Accepted and Rejected are the numbers of stored and rejected records of a partial submission
+	Accepted int `json:"accepted,omitempty"`
+	Rejected int `json:"rejected,omitempty"`
+	// Records reports the outcome of each record of a partial submission, in the order of submission
+	Records []RecordReport `json:"records,omitempty"`
+}
+
+// RecordReport is the outcome of a submitted record
+type RecordReport struct {
+	// Index is the position of the record in the submitted pack
+	Index    int     `json:"index"`
+	Name     string  `json:"name,omitempty"`
+	Time     float64 `json:"time,omitempty"`
+	Accepted bool    `json:"accepted"`
+	// Error is the reason of the rejection
+	Error string `json:"error,omitempty"`
+}
+
+// DuplicateReport is the number of duplicates of a data stream, which were handled according to the given policy
+type DuplicateReport struct {
+	Name   string `json:"name"`
+	Policy string `json:"policy"`
+	Count  int    `json:"count"`
+}
+
+// DeleteResponse is the response to a deletion of data
+type DeleteResponse struct {
+	// Deleted is the number of deleted records of each data stream
+	Deleted map[string]int `json:"deleted"`
+}
+
+// Duplicates are the indices of the submitted records per data stream which share the time of another record
+type Duplicates map[string][]int
+
+// Without returns the records of a data stream except its duplicates
+func (d Duplicates) Without(name string, records senml.Pack) senml.Pack {
+	if len(d[name]) == 0 {
+		return records
+	}
+	skip := make(map[int]bool, len(d[name]))
+	for _, i := range d[name] {
+		skip[i] = true
+	}
+	kept := make(senml.Pack, 0, len(records)-len(skip))
+	for i, r := range records {
+		if !skip[i] {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// DuplicateError is returned when a data stream which rejects duplicates receives records at the time of other records
+type DuplicateError struct {
+	Name  string
+	Times []float64
+}
+
+func (e *DuplicateError) Error() string {
+	if len(e.Times) == 0 {
+		return fmt.Sprintf("%s rejects records at the time of another record", e.Name)
+	}
+	return fmt.Sprintf("%s rejects records at the time of another record: %d duplicate(s), the first at %s",
+		e.Name, len(e.Times), datastore.FromSenmlTime(e.Times[0]).UTC().Format(time.RFC3339Nano))
 }
 
 type Query struct {
@@ -27,5 +145,44 @@ type Query struct {
 	To      time.Time
 	Sort    string
 	Limit   int
-	perPage int
+	PerPage int
+	// Cursor is the position of the page within the results of the query (optional)
+	// It replaces From in ascending and To in descending order. The Limit counts from the start of the query.
+	Cursor *Cursor
+	// Filter selects the records by value (optional). The pages and the Limit only count the matching records.
+	Filter ValueFilter
+}
+
+// Cursor is the position of a page within the results of a query
+// Unlike a timestamp, it also tells apart the records which share the timestamp of the first record of the page
+type Cursor struct {
+	// Time is the time of the first record of the page (SenML time)
+	Time float64 `json:"t"`
+	// Skip is the number of records at Time which precede the page
+	Skip int `json:"s,omitempty"`
+	// Offset is the total number of records which precede the page
+	Offset int `json:"o,omitempty"`
+}
+
+// String returns the cursor as an opaque token
+func (c Cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseCursor parses a token returned by Cursor.String
+func ParseCursor(token string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %s", err)
+	}
+	var c Cursor
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %s", err)
+	}
+	if c.Skip < 0 || c.Offset < 0 {
+		return nil, fmt.Errorf("invalid cursor: negative position")
+	}
+	return &c, nil
 }
@@ -0,0 +1,285 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"github.com/farshidtz/senml"
+)
+
+// Downsampling is the reduction of a query to the records of each data stream which preserve the shape of its values,
+// e.g. for charts
+type Downsampling struct {
+	// MaxPoints is the maximum number of records per data stream
+	MaxPoints int
+	// Method is lttb (Largest-Triangle-Three-Buckets) or minmax (the minimum and maximum of each bucket)
+	Method string
+}
+
+// ParseDownsampling parses the downsampling arguments of a query, e.g. maxPoints=500&downsample=minmax
+// It returns nil without maxPoints.
+func ParseDownsampling(form url.Values) (*Downsampling, error) {
+	if form.Get(common.ParamMaxPoints) == "" {
+		if form.Get(common.ParamDownsample) != "" {
+			return nil, fmt.Errorf("The %s argument requires a %s argument", common.ParamDownsample, common.ParamMaxPoints)
+		}
+		return nil, nil
+	}
+	maxPoints, err := strconv.Atoi(form.Get(common.ParamMaxPoints))
+	if err != nil || maxPoints < 3 {
+		return nil, fmt.Errorf("Invalid %s argument: %s. Expected a number of at least 3", common.ParamMaxPoints, form.Get(common.ParamMaxPoints))
+	}
+
+	d := &Downsampling{MaxPoints: maxPoints, Method: form.Get(common.ParamDownsample)}
+	switch d.Method {
+	case "":
+		d.Method = common.DownsampleLTTB
+	case common.DownsampleLTTB, common.DownsampleMinMax:
+	default:
+		return nil, fmt.Errorf("Invalid %s argument: %s. Supported methods are: %s, %s", common.ParamDownsample, d.Method,
+			common.DownsampleLTTB, common.DownsampleMinMax)
+	}
+	return d, nil
+}
+
+// query returns the downsampling as query parameters, e.g. &maxPoints=500&downsample=lttb
+func (d *Downsampling) query() string {
+	return fmt.Sprintf("&%s=%d&%s=%s", common.ParamMaxPoints, d.MaxPoints, common.ParamDownsample, d.Method)
+}
+
+// downsampler reduces the records of a data stream, which are added in ascending order
+type downsampler interface {
+	add(r senml.Record)
+	records() senml.Pack
+}
+
+// downsampler returns the downsampler of a data stream with the given number of records
+func (d *Downsampling) downsampler(total int) downsampler {
+	if d.Method == common.DownsampleMinMax {
+		return newMinMax(total, d.MaxPoints)
+	}
+	return newLTTB(total, d.MaxPoints)
+}
+
+// lttb selects records with Largest-Triangle-Three-Buckets
+// The first and the last records are kept, and the others are split into buckets of equal count. Of each bucket, the
+// record is selected which forms the largest triangle with the record selected from the previous bucket and the
+// average of the next bucket. Only two buckets are kept in memory, so that the records can be read page by page.
+type lttb struct {
+	threshold int
+	every     float64 // records per bucket
+	all       bool    // true if there are no more records than the threshold
+	index     int     // index in the series of the next record for a bucket
+	bucket    int     // index of the current bucket
+	current   senml.Pack
+	next      senml.Pack
+	pending   *senml.Record // the latest record, which is the last one unless other records follow
+	selected  senml.Pack
+}
+
+func newLTTB(total, threshold int) *lttb {
+	return &lttb{
+		threshold: threshold,
+		every:     float64(total-2) / float64(threshold-2),
+		all:       total <= threshold,
+		index:     1,
+	}
+}
+
+func (l *lttb) add(r senml.Record) {
+	if r.Value == nil {
+		return
+	}
+	if l.all || len(l.selected) == 0 {
+		l.selected = append(l.selected, r)
+		return
+	}
+	if l.pending != nil {
+		l.addToBucket(*l.pending)
+	}
+	l.pending = &r
+}
+
+func (l *lttb) addToBucket(r senml.Record) {
+	// the i-th bucket starts at the index int(i*every)+1
+	b := l.bucket
+	for b < l.threshold-3 && l.index >= int(float64(b+1)*l.every)+1 {
+		b++
+	}
+	l.index++
+	switch {
+	case b <= l.bucket:
+		l.current = append(l.current, r)
+	case b == l.bucket+1:
+		l.next = append(l.next, r)
+	default:
+		l.selectFromCurrent(average(l.next))
+		l.current, l.next = l.next, senml.Pack{r}
+		l.bucket++
+	}
+}
+
+// selectFromCurrent selects the record of the current bucket which forms the largest triangle with the last selected
+// record and the given point
+func (l *lttb) selectFromCurrent(t, v float64) {
+	if len(l.current) == 0 {
+		return
+	}
+	a := l.selected[len(l.selected)-1]
+	best, max := 0, -1.0
+	for i, r := range l.current {
+		area := math.Abs((a.Time-t)*(*r.Value-*a.Value) - (a.Time-r.Time)*(v-*a.Value))
+		if area > max {
+			best, max = i, area
+		}
+	}
+	l.selected = append(l.selected, l.current[best])
+}
+
+func (l *lttb) records() senml.Pack {
+	if l.pending == nil {
+		return l.selected
+	}
+	if len(l.next) > 0 {
+		l.selectFromCurrent(average(l.next))
+		l.current, l.next = l.next, nil
+	}
+	l.selectFromCurrent(l.pending.Time, *l.pending.Value)
+	l.selected = append(l.selected, *l.pending)
+	l.pending = nil
+	return l.selected
+}
+
+// average returns the average time and value of the records
+func average(pack senml.Pack) (float64, float64) {
+	var t, v float64
+	for _, r := range pack {
+		t += r.Time
+		v += *r.Value
+	}
+	return t / float64(len(pack)), v / float64(len(pack))
+}
+
+// minMax selects the minimum and the maximum records of each of the buckets of equal count, in time order
+// The envelope keeps the peaks, which Largest-Triangle-Three-Buckets may smooth out.
+type minMax struct {
+	total    int
+	buckets  int
+	all      bool // true if there are no more records than the threshold
+	count    int
+	bucket   int
+	min, max *senml.Record
+	selected senml.Pack
+}
+
+func newMinMax(total, threshold int) *minMax {
+	return &minMax{
+		total:   total,
+		buckets: threshold / 2,
+		all:     total <= threshold,
+	}
+}
+
+func (m *minMax) add(r senml.Record) {
+	if r.Value == nil {
+		return
+	}
+	if m.all {
+		m.selected = append(m.selected, r)
+		return
+	}
+	b := m.count * m.buckets / m.total
+	if b >= m.buckets {
+		b = m.buckets - 1
+	}
+	m.count++
+	if b != m.bucket {
+		m.selectFromBucket()
+		m.bucket = b
+	}
+	if m.min == nil || *r.Value < *m.min.Value {
+		m.min = &r
+	}
+	if m.max == nil || *r.Value > *m.max.Value {
+		m.max = &r
+	}
+}
+
+func (m *minMax) selectFromBucket() {
+	if m.min == nil {
+		return
+	}
+	first, second := m.min, m.max
+	if second.Time < first.Time {
+		first, second = second, first
+	}
+	m.selected = append(m.selected, *first)
+	if second != first {
+		m.selected = append(m.selected, *second)
+	}
+	m.min, m.max = nil, nil
+}
+
+func (m *minMax) records() senml.Pack {
+	m.selectFromBucket()
+	return m.selected
+}
+
+// downsample queries at most the given number of records per data stream, which are selected while reading the
+// whole time range of the query. The records of all data streams are merged in time order.
+// It also returns the status code of the error, if any.
+func (api *API) downsample(ctx context.Context, q Query, d *Downsampling, sources []*registry.DataStream) (senml.Pack, int, error) {
+	if d.MaxPoints > MaxResampledRecords/len(sources) {
+		return nil, http.StatusBadRequest,
+			fmt.Errorf("The query yields more than the maximum of %d records. Use fewer %s.", MaxResampledRecords, common.ParamMaxPoints)
+	}
+
+	// The records are counted to split them into buckets while reading
+	whole := Query{From: q.From, To: q.To, Sort: common.ASC, Limit: -1, PerPage: MaxPerPage, Filter: q.Filter}
+	downsamplers := make(map[string]downsampler, len(sources))
+	for _, ds := range sources {
+		if ds.Type != common.FLOAT {
+			return nil, http.StatusBadRequest,
+				fmt.Errorf("Downsampling is only supported for data streams of type %s: %s is of type %s", common.FLOAT, ds.Name, ds.Type)
+		}
+		_, total, err := api.storage.Pages(whole, ds)
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("Error counting data in the database: %s", err)
+		}
+		downsamplers[ds.Name] = d.downsampler(total)
+	}
+
+	records, errs := api.storage.QueryStream(ctx, whole, sources...)
+	for record := range records {
+		if downsampler, found := downsamplers[record.Name]; found {
+			downsampler.add(record)
+		}
+	}
+	if err := <-errs; err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("Error retrieving data from the database: %s", err)
+	}
+
+	var data senml.Pack
+	for _, ds := range sources {
+		data = append(data, downsamplers[ds.Name].records()...)
+	}
+	sort.SliceStable(data, func(i, j int) bool {
+		if q.Sort == common.DESC {
+			return data[i].Time > data[j].Time
+		}
+		return data[i].Time < data[j].Time
+	})
+	if q.Limit > 0 && len(data) > q.Limit {
+		data = data[:q.Limit]
+	}
+	return data, 0, nil
+}
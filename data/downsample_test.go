@@ -0,0 +1,163 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"github.com/farshidtz/senml"
+	"github.com/gorilla/mux"
+)
+
+// referenceLTTB is Largest-Triangle-Three-Buckets on all records at once, as published by Sveinn Steinarsson
+func referenceLTTB(pack senml.Pack, threshold int) senml.Pack {
+	if len(pack) <= threshold {
+		return pack
+	}
+	every := float64(len(pack)-2) / float64(threshold-2)
+	selected := senml.Pack{pack[0]}
+	a := 0
+	for i := 0; i < threshold-2; i++ {
+		start, end := int(float64(i)*every)+1, int(float64(i+1)*every)+1
+		nextStart, nextEnd := end, int(float64(i+2)*every)+1
+		if nextEnd > len(pack) {
+			nextEnd = len(pack)
+		}
+		if i == threshold-3 {
+			nextStart, nextEnd = len(pack)-1, len(pack)
+		}
+		t, v := average(pack[nextStart:nextEnd])
+		best, max := start, -1.0
+		for j := start; j < end; j++ {
+			area := math.Abs((pack[a].Time-t)*(*pack[j].Value-*pack[a].Value) - (pack[a].Time-pack[j].Time)*(v-*pack[a].Value))
+			if area > max {
+				best, max = j, area
+			}
+		}
+		selected = append(selected, pack[best])
+		a = best
+	}
+	return append(selected, pack[len(pack)-1])
+}
+
+func randomSeries(n int) senml.Pack {
+	pack := make(senml.Pack, n)
+	for i := range pack {
+		v := rand.Float64() * 100
+		pack[i] = senml.Record{Name: "a", Time: 1543059346 + float64(i), Value: &v}
+	}
+	return pack
+}
+
+func TestLTTB(t *testing.T) {
+	for _, c := range []struct{ n, threshold int }{{10, 20}, {20, 20}, {100, 3}, {100, 10}, {1000, 37}, {999, 500}} {
+		pack := randomSeries(c.n)
+		l := newLTTB(len(pack), c.threshold)
+		for _, r := range pack {
+			l.add(r)
+		}
+		expected := referenceLTTB(pack, c.threshold)
+		if selected := l.records(); !reflect.DeepEqual(selected, expected) {
+			t.Errorf("%d records to %d: expected %d records %v, got %d records %v", c.n, c.threshold, len(expected), expected, len(selected), selected)
+		}
+	}
+}
+
+func TestMinMax(t *testing.T) {
+	pack := randomSeries(100)
+	// peaks within the fourth and the last bucket of ten records
+	low, high := -1.0, 101.0
+	pack[37].Value, pack[35].Value = &low, &high
+	pack[99].Value = &high
+
+	m := newMinMax(len(pack), 20)
+	for _, r := range pack {
+		m.add(r)
+	}
+	selected := m.records()
+	if len(selected) != 20 {
+		t.Fatalf("Expected the minimum and maximum of 10 buckets, got %d records", len(selected))
+	}
+	if selected[6].Time != pack[35].Time || selected[7].Time != pack[37].Time || selected[19].Time != pack[99].Time {
+		t.Errorf("Expected the peaks in time order, got %v", selected)
+	}
+	for i := 1; i < len(selected); i++ {
+		if selected[i].Time <= selected[i-1].Time {
+			t.Fatalf("Expected the records in time order, got %v", selected)
+		}
+	}
+}
+
+func TestHttpQueryDownsampled(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+	regStorage := registry.NewMemoryStorage(common.RegConf{}, storage)
+	series := &registry.DataStream{Name: "test/downsample/float", Type: common.FLOAT}
+	state := &registry.DataStream{Name: "test/downsample/string", Type: common.STRING}
+	for _, ds := range []*registry.DataStream{series, state} {
+		_, err := regStorage.Add(*ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// more records than a page of the storage
+	const start = 1543059346.0
+	pack := submitSeries(t, storage, series, start, 1, 2*MaxPerPage+10)
+
+	api := NewAPI(regStorage, storage, false, nil)
+	r := mux.NewRouter().StrictSlash(true).SkipClean(true)
+	r.Methods("GET").Path("/data/{id:.+}").HandlerFunc(api.Query)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for method, expected := range map[string]senml.Pack{
+		common.DownsampleLTTB:   referenceLTTB(pack, 50),
+		common.DownsampleMinMax: nil,
+	} {
+		res, err := http.Get(ts.URL + "/data/" + series.Name + "?sort=asc&maxPoints=50&downsample=" + method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var recordSet RecordSet
+		err = json.NewDecoder(res.Body).Decode(&recordSet)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recordSet.Data) != 50 || recordSet.NextLink != "" {
+			t.Fatalf("%s: expected 50 records on a single page, got %d: %v", method, len(recordSet.Data), recordSet)
+		}
+		for i, r := range expected {
+			if recordSet.Data[i].Time != r.Time || *recordSet.Data[i].Value != *r.Value {
+				t.Errorf("%s: expected %v, got %v", method, expected, recordSet.Data)
+				break
+			}
+		}
+		if last := recordSet.Data[len(recordSet.Data)-1]; last.Time != pack[len(pack)-1].Time {
+			t.Errorf("%s: expected the last record of the series, got %v", method, last)
+		}
+	}
+
+	from := time.Unix(int64(start), 0).UTC().Format(time.RFC3339)
+	for _, query := range []string{series.Name + "?maxPoints=2", series.Name + "?maxPoints=50&downsample=average",
+		series.Name + "?downsample=lttb", series.Name + "?maxPoints=50&step=1m&from=" + from, state.Name + "?maxPoints=50",
+		series.Name + "?maxPoints=50&page=1"} {
+		res, err := http.Get(ts.URL + "/data/" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: server response is not %v but %v", query, http.StatusBadRequest, res.StatusCode)
+		}
+	}
+}
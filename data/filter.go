@@ -0,0 +1,162 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"github.com/farshidtz/senml"
+)
+
+// Operators of value conditions
+const (
+	OpEqual        = "eq"
+	OpNotEqual     = "ne"
+	OpGreater      = "gt"
+	OpGreaterEqual = "gte"
+	OpLess         = "lt"
+	OpLessEqual    = "lte"
+	OpRegexp       = "regex"
+)
+
+// supported operators per record field
+var valueOperators = map[string][]string{
+	common.ParamValue:       {OpEqual, OpNotEqual, OpGreater, OpGreaterEqual, OpLess, OpLessEqual},
+	common.ParamStringValue: {OpEqual, OpRegexp},
+	common.ParamBoolValue:   {OpEqual},
+	common.ParamUnit:        {OpEqual},
+}
+
+// ValueFilter selects records by value. A record matches if it meets all conditions.
+type ValueFilter []ValueCondition
+
+// ValueCondition is a condition on a field of a record: v, vs, vb or u
+type ValueCondition struct {
+	Field    string
+	Op       string
+	Operand  string
+	matching func(r senml.Record) bool
+}
+
+// ParseValueFilter parses the value conditions of a query
+// Each condition is given as field=op:operand, e.g. v=gt:80, vs=regex:^warn, vb=eq:true or u=eq:Cel.
+// Without operator, the condition is an equality, e.g. u=Cel.
+func ParseValueFilter(form url.Values) (ValueFilter, error) {
+	var filter ValueFilter
+	for _, field := range []string{common.ParamValue, common.ParamStringValue, common.ParamBoolValue, common.ParamUnit} {
+		for _, value := range form[field] {
+			c, err := parseValueCondition(field, value)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing %s argument: %s", field, err)
+			}
+			filter = append(filter, c)
+		}
+	}
+	return filter, nil
+}
+
+func parseValueCondition(field, value string) (ValueCondition, error) {
+	c := ValueCondition{Field: field, Op: OpEqual, Operand: value}
+	if i := strings.Index(value, ":"); i >= 0 && stringInList(value[:i], valueOperators[field]) {
+		c.Op, c.Operand = value[:i], value[i+1:]
+	} else if i >= 0 && field != common.ParamStringValue && field != common.ParamUnit {
+		return c, fmt.Errorf("unsupported operator %s. Supported operators are: %s", value[:i], strings.Join(valueOperators[field], ", "))
+	}
+
+	op, operand := c.Op, c.Operand
+	switch field {
+	case common.ParamValue:
+		number, err := strconv.ParseFloat(operand, 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid number: %s", operand)
+		}
+		c.matching = func(r senml.Record) bool {
+			return r.Value != nil && compare(*r.Value, op, number)
+		}
+	case common.ParamStringValue:
+		if op == OpRegexp {
+			re, err := regexp.Compile(operand)
+			if err != nil {
+				return c, err
+			}
+			c.matching = func(r senml.Record) bool {
+				return re.MatchString(r.StringValue)
+			}
+		} else {
+			c.matching = func(r senml.Record) bool {
+				return r.StringValue == operand
+			}
+		}
+	case common.ParamBoolValue:
+		boolean, err := strconv.ParseBool(operand)
+		if err != nil {
+			return c, fmt.Errorf("invalid boolean: %s", operand)
+		}
+		c.matching = func(r senml.Record) bool {
+			return r.BoolValue != nil && *r.BoolValue == boolean
+		}
+	case common.ParamUnit:
+		c.matching = func(r senml.Record) bool {
+			return r.Unit == operand
+		}
+	}
+	return c, nil
+}
+
+func compare(v float64, op string, operand float64) bool {
+	switch op {
+	case OpNotEqual:
+		return v != operand
+	case OpGreater:
+		return v > operand
+	case OpGreaterEqual:
+		return v >= operand
+	case OpLess:
+		return v < operand
+	case OpLessEqual:
+		return v <= operand
+	default:
+		return v == operand
+	}
+}
+
+// Match returns true if the record meets all conditions of the filter
+func (f ValueFilter) Match(r senml.Record) bool {
+	for _, c := range f {
+		if !c.matching(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// matcher returns the function which selects the records in the datastore, or nil without conditions
+func (f ValueFilter) matcher() func(senml.Record) bool {
+	if len(f) == 0 {
+		return nil
+	}
+	return f.Match
+}
+
+// query returns the conditions as query parameters, e.g. &v=gt:80
+func (f ValueFilter) query() string {
+	var b strings.Builder
+	for _, c := range f {
+		fmt.Fprintf(&b, "&%s=%s", c.Field, url.QueryEscape(c.Op+":"+c.Operand))
+	}
+	return b.String()
+}
+
+func stringInList(a string, list []string) bool {
+	for _, b := range list {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/farshidtz/senml"
+)
+
+func TestParseValueFilter(t *testing.T) {
+	v, b := 21.5, true
+	records := map[string]senml.Record{
+		"float":  {Name: "a", Value: &v, Unit: "Cel"},
+		"string": {Name: "a", StringValue: "warning: low battery"},
+		"bool":   {Name: "a", BoolValue: &b},
+	}
+	for query, matching := range map[string][]string{
+		"v=gt:20":                     {"float"},
+		"v=gt:20&v=lt:21.5":           {},
+		"v=ne:0":                      {"float"},
+		"v=21.5&u=Cel":                {"float"},
+		"u=eq:K":                      {},
+		"vs=regex:^warn":              {"string"},
+		"vs=warning:%20low%20battery": {"string"},
+		"vb=eq:true":                  {"bool"},
+		"vb=false":                    {},
+		"":                            {"float", "string", "bool"},
+	} {
+		filter, err := ParseValueFilter(mustParseQuery(t, query))
+		if err != nil {
+			t.Errorf("%s: %s", query, err)
+			continue
+		}
+		for name, r := range records {
+			if expected := stringInList(name, matching); filter.Match(r) != expected {
+				t.Errorf("%s: expected the %s record to match: %t", query, name, expected)
+			}
+		}
+
+		// the conditions are passed on to the next pages
+		reparsed, err := ParseValueFilter(mustParseQuery(t, filter.query()))
+		if err != nil || len(reparsed) != len(filter) {
+			t.Errorf("%s: conditions not preserved in %s: %v", query, filter.query(), err)
+		}
+	}
+
+	for _, query := range []string{"v=gt:high", "v=regex:1", "v=like:1", "vb=yes", "vb=gt:true", "vs=regex:("} {
+		if _, err := ParseValueFilter(mustParseQuery(t, query)); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+}
+
+func mustParseQuery(t *testing.T, query string) url.Values {
+	form, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return form
+}
@@ -0,0 +1,146 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"github.com/farshidtz/senml"
+)
+
+// csvHeader is the first row of CSV responses
+var csvHeader = []string{"time", "name", "value", "unit"}
+
+// responseFormat returns the format of the query response, negotiated with the format argument or the Accept header
+// The format argument takes precedence over the header. SenML CBOR and XML are returned as their media types.
+func responseFormat(r *http.Request) (string, error) {
+	switch format := r.Form.Get(common.ParamFormat); format {
+	case common.FormatJSON, common.FormatCSV, common.FormatNDJSON:
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("Invalid format argument: %s. Supported formats are: %s, %s, %s",
+			format, common.FormatJSON, common.FormatCSV, common.FormatNDJSON)
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.Split(accept, ";")[0])
+		switch mediaType {
+		case common.CSVMIMEType:
+			return common.FormatCSV, nil
+		case common.NDJSONMIMEType:
+			return common.FormatNDJSON, nil
+		case senml.MediaTypeSenmlCBOR, senml.MediaTypeSenmlXML:
+			return mediaType, nil
+		}
+	}
+	return common.FormatJSON, nil
+}
+
+// responseLayout returns the layout of the records requested by the layout argument
+// The records are listed by default. The wide layout is only supported for the JSON and CSV formats.
+func responseLayout(r *http.Request, format string) (string, error) {
+	switch layout := r.Form.Get(common.ParamLayout); layout {
+	case "":
+		return "", nil
+	case common.LayoutWide:
+		if format != common.FormatJSON && format != common.FormatCSV {
+			return "", fmt.Errorf("The %s layout is only supported for the %s and %s formats", layout, common.FormatJSON, common.FormatCSV)
+		}
+		return layout, nil
+	default:
+		return "", fmt.Errorf("Invalid layout argument: %s. Supported layouts are: %s", layout, common.LayoutWide)
+	}
+}
+
+// writeRecords writes the records in a format other than the JSON recordset
+// Only the records are written, links to other pages are sent in a Link header
+func writeRecords(w http.ResponseWriter, format string, pack senml.Pack, nextLink string) {
+	var (
+		body        []byte
+		contentType = format
+		err         error
+	)
+	switch format {
+	case common.FormatCSV:
+		var buf bytes.Buffer
+		err = writeCSV(&buf, pack)
+		body, contentType = buf.Bytes(), common.CSVMIMEType
+	case common.FormatNDJSON:
+		for _, r := range pack {
+			var b []byte
+			b, err = json.Marshal(r)
+			if err != nil {
+				break
+			}
+			body = append(append(body, b...), '\n')
+		}
+		contentType = common.NDJSONMIMEType
+	default:
+		senmlFormat, _ := common.SenMLFormat(format)
+		body, err = pack.Encode(senmlFormat, senml.OutputOptions{})
+	}
+	if err != nil {
+		common.ErrorResponse(http.StatusInternalServerError, "Error encoding records: "+err.Error(), w)
+		return
+	}
+
+	if nextLink != "" {
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextLink))
+	}
+	w.Header().Add("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// writeCSV writes the records as rows of time, name, value and unit
+// The time is in RFC3339 format and the value is any of the SenML values (v, vs, vb, vd or s)
+func writeCSV(w io.Writer, pack senml.Pack) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+	for _, r := range pack {
+		err = writer.Write(csvRow(r))
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvRow(r senml.Record) []string {
+	return []string{
+		time.Unix(0, int64(r.Time*1e9)).UTC().Format(time.RFC3339Nano),
+		r.Name,
+		csvValue(r),
+		r.Unit,
+	}
+}
+
+func csvValue(r senml.Record) string {
+	switch {
+	case r.Value != nil:
+		return strconv.FormatFloat(*r.Value, 'f', -1, 64)
+	case r.StringValue != "":
+		return r.StringValue
+	case r.BoolValue != nil:
+		return strconv.FormatBool(*r.BoolValue)
+	case r.DataValue != "":
+		return r.DataValue
+	case r.Sum != nil:
+		return strconv.FormatFloat(*r.Sum, 'f', -1, 64)
+	}
+	return ""
+}
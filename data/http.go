@@ -9,6 +9,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -28,19 +29,27 @@ type API struct {
 	registry         registry.Storage
 	storage          Storage
 	autoRegistration bool
+	// quarantine of the rejected payloads (optional)
+	quarantine *Quarantine
 }
 
 // NewAPI returns the configured Data API
-func NewAPI(registry registry.Storage, storage Storage, autoRegistration bool) *API {
-	return &API{registry, storage, autoRegistration}
+// The rejected payloads are kept in the quarantine, unless it is nil
+func NewAPI(registry registry.Storage, storage Storage, autoRegistration bool, quarantine *Quarantine) *API {
+	return &API{registry, storage, autoRegistration, quarantine}
 }
 
 // Submit is a handler for submitting a new data point
-// Expected parameters: id(s)
+// Expected parameters: id(s), optional: partial (true to store the valid records and report the rejected ones)
 func (api *API) Submit(w http.ResponseWriter, r *http.Request) {
 	//params := mux.Vars(r)
-	data := make(map[string]senml.Pack)
-	sources := make(map[string]*registry.DataStream)
+
+	format, ok := common.SenMLFormat(r.Header.Get("Content-Type"))
+	if !ok {
+		common.ErrorResponse(http.StatusUnsupportedMediaType,
+			fmt.Sprintf("Unsupported Content-Type: %s. Supported types are: %s", r.Header.Get("Content-Type"), strings.Join(common.SupportedSenMLMediaTypes(), ", ")), w)
+		return
+	}
 
 	// Read body
 	body, err := ioutil.ReadAll(r.Body)
@@ -51,9 +60,12 @@ func (api *API) Submit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse payload
-	senmlPack, err := senml.Decode(body, senml.JSON)
+	origin := QuarantineEntry{Source: QuarantineHTTP, Topic: r.URL.Path}
+	senmlPack, err := senml.Decode(body, format)
 	if err != nil {
-		common.ErrorResponse(http.StatusBadRequest, "Error parsing message body: "+err.Error(), w)
+		origin.Reason, origin.Payload, origin.ContentType = "Error parsing message body: "+err.Error(), body, r.Header.Get("Content-Type")
+		api.quarantine.Add(origin)
+		common.ErrorResponse(http.StatusBadRequest, origin.Reason, w)
 		return
 	}
 
@@ -61,67 +73,208 @@ func (api *API) Submit(w http.ResponseWriter, r *http.Request) {
 	dsResources := make(map[string]*registry.DataStream)
 	// Fill the data map with provided data points
 	records := senmlPack.Normalize()
-	for _, r := range records {
+	sub := newSubmission(records, r.URL.Query().Get(common.ParamPartial) == "true", api.quarantine, origin)
+	for i, r := range records {
 		if r.Name == "" {
-			common.ErrorResponse(http.StatusBadRequest, fmt.Sprintf("Data source name not specified."), w)
-			return
+			if sub.reject(w, i, http.StatusBadRequest, "Data source name not specified.") {
+				return
+			}
+			continue
 		}
 		// Check if there is a data source for this entry
 		ds, ok := dsResources[r.Name]
 		if !ok {
 			ds, err = api.registry.Get(r.Name)
 			if err != nil {
-				common.ErrorResponse(http.StatusNotFound, fmt.Sprintf("Data point for unknown data source %v.", r.Name), w)
-				return
+				if sub.reject(w, i, http.StatusNotFound, fmt.Sprintf("Data point for unknown data source %v.", r.Name)) {
+					return
+				}
+				continue
 			}
 			dsResources[ds.Name] = ds
 		}
 
 		// Check if type of value matches the data source type in registry
-		typeError := false
-		switch ds.Type {
-		case common.FLOAT:
-			if r.Value == nil {
-				typeError = true
-			}
-		case common.STRING:
-			if r.StringValue == "" {
-				typeError = true
-			}
-		case common.BOOL:
-			if r.BoolValue == nil {
-				typeError = true
+		if err := checkValueType(r, ds); err != nil {
+			if sub.reject(w, i, http.StatusBadRequest, err.Error()) {
+				return
 			}
+			continue
 		}
-		if typeError {
-			common.ErrorResponse(http.StatusBadRequest,
-				fmt.Sprintf("Value for %v is empty or has a type other than what is set in registry: %v", r.Name, ds.Type), w)
-			return
+		sub.add(i, ds)
+	}
+
+	// Add data to the storage
+	sub.store(w, api.storage)
+}
+
+// submission collects the records of a submission per data stream
+// In partial mode, the invalid records are rejected one by one instead of failing the submission, and the valid
+// records are stored. The rejected records are quarantined.
+type submission struct {
+	records    senml.Pack
+	partial    bool
+	quarantine *Quarantine
+	// origin of the submission in the quarantine
+	origin  QuarantineEntry
+	data    map[string]senml.Pack
+	sources map[string]*registry.DataStream
+	// positions of the records of each data stream in the submission
+	indices map[string][]int
+	// reasons of the rejected records by position
+	rejected map[int]string
+}
+
+func newSubmission(records senml.Pack, partial bool, quarantine *Quarantine, origin QuarantineEntry) *submission {
+	return &submission{
+		records:    records,
+		partial:    partial,
+		quarantine: quarantine,
+		origin:     origin,
+		data:       make(map[string]senml.Pack),
+		sources:    make(map[string]*registry.DataStream),
+		indices:    make(map[string][]int),
+		rejected:   make(map[int]string),
+	}
+}
+
+// add adds the i-th record for storage in the given data stream
+func (s *submission) add(i int, ds *registry.DataStream) {
+	s.data[ds.Name] = append(s.data[ds.Name], s.records[i])
+	s.sources[ds.Name] = ds
+	s.indices[ds.Name] = append(s.indices[ds.Name], i)
+}
+
+// reject rejects the i-th record. Unless in partial mode, it fails the submission and returns true.
+func (s *submission) reject(w http.ResponseWriter, i int, code int, msg string) bool {
+	if !s.partial {
+		entry := s.origin
+		entry.Reason, entry.Records = msg, s.records
+		s.quarantine.Add(entry)
+		common.ErrorResponse(code, msg, w)
+		return true
+	}
+	s.rejected[i] = msg
+	return false
+}
+
+// store adds the collected data to the storage and reports the handling of the submitted records
+// It returns false if the data could not be stored.
+func (s *submission) store(w http.ResponseWriter, storage Storage) bool {
+	// the records rejected in partial mode are quarantined by reason
+	var reasons []string
+	rejected := make(map[string]senml.Pack)
+	for i, r := range s.records {
+		if reason, found := s.rejected[i]; found {
+			if _, found := rejected[reason]; !found {
+				reasons = append(reasons, reason)
+			}
+			rejected[reason] = append(rejected[reason], r)
 		}
+	}
+	for _, reason := range reasons {
+		entry := s.origin
+		entry.Reason, entry.Records = reason, rejected[reason]
+		s.quarantine.Add(entry)
+	}
 
-		_, ok = data[ds.Name]
-		if !ok {
-			data[ds.Name] = senml.Pack{}
-			sources[ds.Name] = ds
+	submit := storage.Submit
+	if s.partial {
+		submit = storage.SubmitPartial
+	}
+	duplicates, err := submit(s.data, s.sources)
+	if err == ErrQueueFull || err == ErrQueueClosed {
+		w.Header().Set("Retry-After", "1")
+		common.ErrorResponse(http.StatusServiceUnavailable, "Error writing data to the database: "+err.Error(), w)
+		return false
+	} else if _, ok := err.(*DuplicateError); ok {
+		common.ErrorResponse(http.StatusConflict, err.Error(), w)
+		return false
+	} else if err != nil {
+		common.ErrorResponse(http.StatusInternalServerError, "Error writing data to the database: "+err.Error(), w)
+		return false
+	}
+
+	var res SubmitResponse
+	for name, indices := range duplicates {
+		policy := policyOf(s.sources[name])
+		res.Duplicates = append(res.Duplicates, DuplicateReport{Name: name, Policy: policy, Count: len(indices)})
+		if policy == common.DuplicatesReject {
+			// only left out in partial mode
+			for _, i := range indices {
+				s.rejected[s.indices[name][i]] = "Record at the time of another record of " + name
+			}
+		}
+	}
+	sort.Slice(res.Duplicates, func(i, j int) bool {
+		return res.Duplicates[i].Name < res.Duplicates[j].Name
+	})
+	if s.partial {
+		res.Records = make([]RecordReport, len(s.records))
+		for i, r := range s.records {
+			res.Records[i] = RecordReport{Index: i, Name: r.Name, Time: r.Time, Accepted: true}
+			if reason, rejected := s.rejected[i]; rejected {
+				res.Records[i].Accepted, res.Records[i].Error = false, reason
+				res.Rejected++
+			} else {
+				res.Accepted++
+			}
 		}
-		data[ds.Name] = append(data[ds.Name], r)
 	}
 
-	// Add data to the storage
-	err = api.storage.Submit(data, sources)
+	b, err := json.Marshal(res)
 	if err != nil {
-		common.ErrorResponse(http.StatusInternalServerError, "Error writing data to the database: "+err.Error(), w)
-		return
+		common.ErrorResponse(http.StatusInternalServerError, "Error marshalling response: "+err.Error(), w)
+		return true
 	}
 	w.Header().Set("Content-Type", common.DefaultMIMEType)
 	w.WriteHeader(http.StatusAccepted)
-	return
+	w.Write(b)
+	return true
+}
+
+// checkValueType checks if the value of a record matches the type of its data stream
+func checkValueType(r senml.Record, ds *registry.DataStream) error {
+	typeError := false
+	switch ds.Type {
+	case common.FLOAT:
+		if r.Value == nil {
+			typeError = true
+		}
+	case common.STRING:
+		if r.StringValue == "" {
+			typeError = true
+		}
+	case common.BOOL:
+		if r.BoolValue == nil {
+			typeError = true
+		}
+	}
+	if typeError {
+		return fmt.Errorf("Value for %v is empty or has a type other than what is set in registry: %v", r.Name, ds.Type)
+	}
+	return nil
+}
+
+// policyOf returns the duplicates policy of a data stream
+func policyOf(ds *registry.DataStream) string {
+	if ds == nil || ds.Duplicates == "" {
+		return common.DuplicatesOverwrite
+	}
+	return ds.Duplicates
 }
 
 // SubmitWithoutID is a handler for submitting a new data point
-// Expected parameters: none
+// Expected parameters: optional: partial (true to store the valid records and report the rejected ones)
 func (api *API) SubmitWithoutID(w http.ResponseWriter, r *http.Request) {
 
+	format, ok := common.SenMLFormat(r.Header.Get("Content-Type"))
+	if !ok {
+		common.ErrorResponse(http.StatusUnsupportedMediaType,
+			fmt.Sprintf("Unsupported Content-Type: %s. Supported types are: %s", r.Header.Get("Content-Type"), strings.Join(common.SupportedSenMLMediaTypes(), ", ")), w)
+		return
+	}
+
 	// Read body
 	body, err := ioutil.ReadAll(r.Body)
 	defer r.Body.Close()
@@ -131,9 +284,12 @@ func (api *API) SubmitWithoutID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse payload
-	senmlPack, err := senml.Decode(body, senml.JSON)
+	origin := QuarantineEntry{Source: QuarantineHTTP, Topic: r.URL.Path}
+	senmlPack, err := senml.Decode(body, format)
 	if err != nil {
-		common.ErrorResponse(http.StatusBadRequest, "Error parsing message body: "+err.Error(), w)
+		origin.Reason, origin.Payload, origin.ContentType = "Error parsing message body: "+err.Error(), body, r.Header.Get("Content-Type")
+		api.quarantine.Add(origin)
+		common.ErrorResponse(http.StatusBadRequest, origin.Reason, w)
 		return
 	}
 
@@ -141,22 +297,25 @@ func (api *API) SubmitWithoutID(w http.ResponseWriter, r *http.Request) {
 	nameDSs := make(map[string]*registry.DataStream)
 
 	// Fill the data map with provided data points
-	data := make(map[string]senml.Pack)
-	sources := make(map[string]*registry.DataStream)
 	records := senmlPack.Normalize()
-	for _, r := range records {
+	sub := newSubmission(records, r.URL.Query().Get(common.ParamPartial) == "true", api.quarantine, origin)
+	for i, r := range records {
 
 		ds, found := nameDSs[r.Name]
 		if !found {
-			ds, err = api.registry.FilterOne("resource", "equals", r.Name)
+			ds, err = api.registry.FilterOne("name", "equals", r.Name)
 			if err != nil {
-				common.ErrorResponse(http.StatusBadRequest, fmt.Sprintf("Error retrieving data source with name %v from the registry: %v", r.Name, err.Error()), w)
-				return
+				if sub.reject(w, i, http.StatusBadRequest, fmt.Sprintf("Error retrieving data source with name %v from the registry: %v", r.Name, err.Error())) {
+					return
+				}
+				continue
 			}
 			if ds == nil {
 				if !api.autoRegistration {
-					common.ErrorResponse(http.StatusNotFound, fmt.Sprintf("Data source with name %v is not registered.", r.Name), w)
-					return
+					if sub.reject(w, i, http.StatusNotFound, fmt.Sprintf("Data source with name %v is not registered.", r.Name)) {
+						return
+					}
+					continue
 				}
 
 				// Register a data source with this name
@@ -175,8 +334,10 @@ func (api *API) SubmitWithoutID(w http.ResponseWriter, r *http.Request) {
 				}
 				addedDS, err := api.registry.Add(newDS)
 				if err != nil {
-					common.ErrorResponse(http.StatusBadRequest, fmt.Sprintf("Error registering %v in the registry: %v", r.Name, err.Error()), w)
-					return
+					if sub.reject(w, i, http.StatusBadRequest, fmt.Sprintf("Error registering %v in the registry: %v", r.Name, err.Error())) {
+						return
+					}
+					continue
 				}
 				ds = addedDS
 			}
@@ -184,49 +345,23 @@ func (api *API) SubmitWithoutID(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Check if type of value matches the data source type in registry
-		typeError := false
-		switch ds.Type {
-		case common.FLOAT:
-			if r.Value == nil {
-				typeError = true
-			}
-		case common.STRING:
-			if r.StringValue == "" {
-				typeError = true
-			}
-		case common.BOOL:
-			if r.BoolValue == nil {
-				typeError = true
+		if err := checkValueType(r, ds); err != nil {
+			if sub.reject(w, i, http.StatusBadRequest, err.Error()) {
+				return
 			}
-		}
-		if typeError {
-			common.ErrorResponse(http.StatusBadRequest,
-				fmt.Sprintf("Value for %v is empty or has a type other than what is set in registry: %v", r.Name, ds.Type), w)
-			return
+			continue
 		}
 
 		// Prepare for storage
-		_, found = data[ds.Name]
-		if !found {
-			data[ds.Name] = senml.Pack{}
-			sources[ds.Name] = ds
-		}
-		data[ds.Name] = append(data[ds.Name], r)
+		sub.add(i, ds)
 	}
 
 	// Add data to the storage
-	err = api.storage.Submit(data, sources)
-	if err != nil {
-		common.ErrorResponse(http.StatusInternalServerError, "Error writing data to the database: "+err.Error(), w)
-		return
-	}
-	w.Header().Set("Content-Type", common.DefaultMIMEType)
-	w.WriteHeader(http.StatusAccepted)
-	return
+	sub.store(w, api.storage)
 }
 
 func GetUrlFromQuery(q Query, id ...string) (url string) {
-	var sort, limit, start, end, perPage string
+	var sort, limit, start, end, perPage, cursor string
 	if q.Sort != "" {
 		sort = fmt.Sprintf("&%v=%v", common.ParamSort, q.Sort)
 	}
@@ -234,47 +369,97 @@ func GetUrlFromQuery(q Query, id ...string) (url string) {
 		limit = fmt.Sprintf("&%v=%v", common.ParamLimit, q.Limit)
 	}
 	if !q.From.IsZero() {
-		start = fmt.Sprintf("&%v=%v", common.ParamFrom, q.From.UTC().Format(time.RFC3339))
+		start = fmt.Sprintf("&%v=%v", common.ParamFrom, q.From.UTC().Format(time.RFC3339Nano))
 	}
 	if !q.To.IsZero() {
-		end = fmt.Sprintf("&%v=%v", common.ParamTo, q.To.UTC().Format(time.RFC3339))
+		end = fmt.Sprintf("&%v=%v", common.ParamTo, q.To.UTC().Format(time.RFC3339Nano))
 	}
 
-	if q.perPage > 0 {
-		perPage = fmt.Sprintf("&%v=%v", common.ParamPerPage, q.perPage)
+	if q.PerPage > 0 {
+		perPage = fmt.Sprintf("&%v=%v", common.ParamPerPage, q.PerPage)
+	}
+	if q.Cursor != nil {
+		cursor = fmt.Sprintf("&%v=%v", common.ParamCursor, q.Cursor.String())
 	}
 
-	return fmt.Sprintf("%v?%s%s%s%s%s",
+	return fmt.Sprintf("%v?%s%s%s%s%s%s%s",
 		strings.Join(id, common.IDSeparator),
 		perPage,
-		sort, limit, start, end,
+		sort, limit, start, end, q.Filter.query(), cursor,
 	)
 }
 
-// Query is a handler for querying data
-// Expected parameters: id(s), optional: pagination, query string
-func (api *API) Query(w http.ResponseWriter, r *http.Request) {
-	r.ParseForm()
-	timeStart := time.Now()
-	params := mux.Vars(r)
-	var recordSet RecordSet
+// dataLink returns the link of a query on the data streams given by id(s) and/or a registry filter
+func dataLink(q Query, ids []string, filter string) string {
+	if filter == "" {
+		return common.DataAPILoc + "/" + GetUrlFromQuery(q, ids...)
+	}
+	link := common.DataAPILoc + GetUrlFromQuery(q)
+	if len(ids) > 0 {
+		link = common.DataAPILoc + "/" + GetUrlFromQuery(q, ids...)
+	}
+	return link + fmt.Sprintf("&%s=%s", common.ParamFilter, url.QueryEscape(filter))
+}
 
-	// Parse id(s) and get sources from registry
-	ids := strings.Split(params["id"], common.IDSeparator)
-	sources := []*registry.DataStream{}
+// splitIDs splits the id(s) of a request path
+func splitIDs(ids string) []string {
+	if ids == "" {
+		return nil
+	}
+	return strings.Split(ids, common.IDSeparator)
+}
+
+// querySources returns the data streams given by id(s) and/or a registry filter in the form of <path>/<op>/<value>
+// The filter is evaluated on every request, e.g. for every page of the results. The data streams are returned once,
+// in the order of the ids followed by the order of the registry. It also returns the status code of the error, if any.
+func (api *API) querySources(ids []string, filter string) ([]*registry.DataStream, int, error) {
+	var sources []*registry.DataStream
+	selected := make(map[string]bool)
 	for _, id := range ids {
 		ds, err := api.registry.Get(id)
 		if err != nil {
-			common.ErrorResponse(http.StatusNotFound,
-				fmt.Sprintf("Error retrieving data source %v from the registry: %v", id, err.Error()),
-				w)
-			return
+			return nil, http.StatusNotFound, fmt.Errorf("Error retrieving data source %v from the registry: %v", id, err.Error())
+		}
+		if !selected[ds.Name] {
+			selected[ds.Name] = true
+			sources = append(sources, ds)
 		}
-		sources = append(sources, ds)
+	}
+	if filter != "" {
+		matched, err := api.filterStreams(filter)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		for i := range matched {
+			if !selected[matched[i].Name] {
+				selected[matched[i].Name] = true
+				sources = append(sources, &matched[i])
+			}
+		}
+	} else if len(ids) == 0 {
+		return nil, http.StatusBadRequest, fmt.Errorf("Either id(s) or the %s argument must be given", common.ParamFilter)
 	}
 	if len(sources) == 0 {
-		common.ErrorResponse(http.StatusNotFound,
-			"None of the specified data sources could be retrieved from the registry.", w)
+		return nil, http.StatusNotFound, fmt.Errorf("None of the specified data sources could be retrieved from the registry.")
+	}
+	return sources, 0, nil
+}
+
+// Query is a handler for querying data
+// Expected parameters: id(s) and/or filter (e.g. meta.building/equals/B12), optional: pagination, query string,
+// value filters (e.g. v=gt:80, vs=regex:^warn), resampling (e.g. step=1m&fill=linear), downsampling (e.g. maxPoints=500),
+// layout (wide for a table of a row per time and a column per data stream)
+func (api *API) Query(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	timeStart := time.Now()
+	params := mux.Vars(r)
+	var recordSet RecordSet
+
+	// Parse id(s) and/or filter and get sources from registry
+	ids, filter := splitIDs(params["id"]), r.Form.Get(common.ParamFilter)
+	sources, code, err := api.querySources(ids, filter)
+	if err != nil {
+		common.ErrorResponse(code, err.Error(), w)
 		return
 	}
 
@@ -284,37 +469,198 @@ func (api *API) Query(w http.ResponseWriter, r *http.Request) {
 		common.ErrorResponse(http.StatusBadRequest, err.Error(), w)
 		return
 	}
+	q.Filter, err = ParseValueFilter(r.Form)
+	if err != nil {
+		common.ErrorResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	resampling, err := ParseResampling(r.Form)
+	if err != nil {
+		common.ErrorResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	downsampling, err := ParseDownsampling(r.Form)
+	if err != nil {
+		common.ErrorResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	if resampling != nil && downsampling != nil {
+		common.ErrorResponse(http.StatusBadRequest,
+			fmt.Sprintf("The %s and %s arguments are mutually exclusive", common.ParamStep, common.ParamMaxPoints), w)
+		return
+	}
+	format, err := responseFormat(r)
+	if err != nil {
+		common.ErrorResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	layout, err := responseLayout(r, format)
+	if err != nil {
+		common.ErrorResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+
+	// Counting reads the whole time range of the query, therefore the total is only given on demand
+	var page int
+	if p := r.Form.Get(common.ParamPage); p != "" {
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			common.ErrorResponse(http.StatusBadRequest, fmt.Sprintf("Invalid page argument: %s", p), w)
+			return
+		}
+	}
+	count := page > 0 || r.Form.Get(common.ParamCount) == "true"
+	if page > 0 && q.Cursor != nil {
+		common.ErrorResponse(http.StatusBadRequest,
+			fmt.Sprintf("The %s and %s arguments are mutually exclusive", common.ParamPage, common.ParamCursor), w)
+		return
+	}
+
+	// Resampled and downsampled queries read the whole time range and are not paginated
+	wholeRange := resampling != nil || downsampling != nil
+	if wholeRange {
+		if page > 0 || q.Cursor != nil {
+			common.ErrorResponse(http.StatusBadRequest, "Page navigation is not supported for resampled or downsampled queries", w)
+			return
+		}
+		count = false
+	}
 
-	data, total, nextLinkTS, err := api.storage.Query(q, sources...)
+	// NDJSON is always streamed, the other formats on demand
+	if !wholeRange && (format == common.FormatNDJSON || r.Form.Get(common.ParamStream) == "true") {
+		if layout == common.LayoutWide {
+			common.ErrorResponse(http.StatusBadRequest, "The wide layout is not supported for streamed responses", w)
+			return
+		}
+		if page > 0 {
+			common.ErrorResponse(http.StatusBadRequest, "Page navigation is not supported for streamed responses", w)
+			return
+		}
+		selfLink := dataLink(q, ids, filter) + fmt.Sprintf("&%s=true", common.ParamStream)
+		if r.Form.Get(common.ParamFormat) != "" {
+			selfLink += fmt.Sprintf("&%s=%s", common.ParamFormat, format)
+		}
+		api.streamQuery(w, r, q, format, selfLink, sources)
+		return
+	}
+
+	var (
+		pages      []Cursor
+		totalCount int
+	)
+	if count {
+		pages, totalCount, err = api.storage.Pages(q, sources...)
+		if err != nil {
+			common.ErrorResponse(http.StatusInternalServerError, "Error counting data in the database: "+err.Error(), w)
+			return
+		}
+		w.Header().Set(common.HeaderTotalCount, strconv.Itoa(totalCount))
+	}
+
+	var (
+		data senml.Pack
+		next *Cursor
+	)
+	if resampling != nil {
+		var code int
+		data, code, err = api.resample(r.Context(), q, resampling, sources)
+		if err != nil {
+			common.ErrorResponse(code, err.Error(), w)
+			return
+		}
+	} else if downsampling != nil {
+		var code int
+		data, code, err = api.downsample(r.Context(), q, downsampling, sources)
+		if err != nil {
+			common.ErrorResponse(code, err.Error(), w)
+			return
+		}
+	} else if page == 0 {
+		data, _, next, err = api.storage.Query(q, sources...)
+	} else if page <= len(pages) {
+		pageQuery := q
+		pageQuery.Cursor = &pages[page-1]
+		data, _, _, err = api.storage.Query(pageQuery, sources...)
+	} else {
+		data = senml.Pack{}
+	}
 	if err != nil {
 		common.ErrorResponse(http.StatusInternalServerError, "Error retrieving data from the database: "+err.Error(), w)
 		return
 	}
 
-	curlink := common.DataAPILoc + "/" + GetUrlFromQuery(q, ids...)
+	curlink := dataLink(q, ids, filter)
+	if resampling != nil {
+		curlink += resampling.query()
+	}
+	if downsampling != nil {
+		curlink += downsampling.query()
+	}
 
 	nextlink := ""
 
-	if nextLinkTS != nil {
+	if page > 0 {
+		// pages are navigated by number, so that the links remain valid for parallel retrieval
+		if page < len(pages) {
+			nextlink = fmt.Sprintf("%s&%s=%d", curlink, common.ParamPage, page+1)
+		}
+		curlink += fmt.Sprintf("&%s=%d", common.ParamPage, page)
+	} else if next != nil {
 		nextQuery := q
-		lastPage := false
-		if q.Limit > 0 { //if Limit is given by user reduce the limit by total
-			newLimit := q.Limit - total
-			if newLimit > 0 {
-				nextQuery.Limit = newLimit
-			} else {
-				lastPage = true
-			}
+		nextQuery.Cursor = next
+		nextlink = dataLink(nextQuery, ids, filter)
+	}
+	if count && page == 0 {
+		curlink += fmt.Sprintf("&%s=true", common.ParamCount)
+		if nextlink != "" {
+			nextlink += fmt.Sprintf("&%s=true", common.ParamCount)
+		}
+	}
+	if layout != "" {
+		curlink += fmt.Sprintf("&%s=%s", common.ParamLayout, layout)
+		if nextlink != "" {
+			nextlink += fmt.Sprintf("&%s=%s", common.ParamLayout, layout)
 		}
+	}
 
-		if !lastPage {
-			if q.Sort == common.DESC {
-				nextQuery.To = *nextLinkTS
-			} else {
-				nextQuery.From = *nextLinkTS
-			}
-			nextlink = common.DataAPILoc + "/" + GetUrlFromQuery(nextQuery, ids...)
+	if layout == common.LayoutWide {
+		if nextlink != "" && r.Form.Get(common.ParamFormat) != "" {
+			nextlink += fmt.Sprintf("&%s=%s", common.ParamFormat, format)
+		}
+		table := newTable(data, sources)
+		if format == common.FormatCSV {
+			writeTableCSV(w, table, nextlink)
+			return
+		}
+		tableSet := TableSet{
+			SelfLink: curlink,
+			TimeTook: time.Since(timeStart).Seconds(),
+			Columns:  table.columns(),
+			Rows:     table.rows(),
+			NextLink: nextlink,
+			Page:     page,
+		}
+		if count {
+			tableSet.Total = &totalCount
+			tableSet.Pages = len(pages)
+		}
+		b, err := json.Marshal(tableSet)
+		if err != nil {
+			common.ErrorResponse(http.StatusInternalServerError, "Error marshalling table: "+err.Error(), w)
+			return
 		}
+		w.Header().Add("Content-Type", common.DefaultMIMEType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+		return
+	}
+
+	if format != common.FormatJSON {
+		if nextlink != "" && r.Form.Get(common.ParamFormat) != "" {
+			nextlink += fmt.Sprintf("&%s=%s", common.ParamFormat, format)
+		}
+		writeRecords(w, format, data, nextlink)
+		return
 	}
 
 	recordSet = RecordSet{
@@ -322,9 +668,14 @@ func (api *API) Query(w http.ResponseWriter, r *http.Request) {
 		TimeTook: time.Since(timeStart).Seconds(),
 		Data:     data,
 		NextLink: nextlink,
+		Page:     page,
+	}
+	if count {
+		recordSet.Total = &totalCount
+		recordSet.Pages = len(pages)
 	}
 
-	csvStr, err := json.Marshal(recordSet)
+	b, err := json.Marshal(recordSet)
 	if err != nil {
 		common.ErrorResponse(http.StatusInternalServerError, "Error marshalling recordset: "+err.Error(), w)
 		return
@@ -332,7 +683,175 @@ func (api *API) Query(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Add("Content-Type", common.DefaultMIMEType)
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(csvStr))
+	w.Write(b)
+}
+
+// Latest is a handler for querying the latest record of each data source
+// Expected parameters: id(s) and/or filter (e.g. meta.building/equals/B12), optional: asOf (the latest record at or
+// before this time, by default without limit)
+func (api *API) Latest(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	timeStart := time.Now()
+	params := mux.Vars(r)
+
+	// Parse id(s) and/or filter and get sources from registry
+	ids, filter := splitIDs(params["id"]), r.Form.Get(common.ParamFilter)
+	sources, code, err := api.querySources(ids, filter)
+	if err != nil {
+		common.ErrorResponse(code, err.Error(), w)
+		return
+	}
+
+	q := Query{To: endOfTime, Sort: common.DESC, Limit: 1, PerPage: 1}
+	selfLink := common.DataAPILoc + "/" + strings.Join(ids, common.IDSeparator) + common.LatestAPILoc
+	if len(ids) == 0 {
+		selfLink = common.DataAPILoc + common.LatestAPILoc
+	}
+	args := url.Values{}
+	if filter != "" {
+		args.Set(common.ParamFilter, filter)
+	}
+	if asOf := r.Form.Get(common.ParamAsOf); asOf != "" {
+		q.To, err = parseTime(asOf)
+		if err != nil {
+			common.ErrorResponse(http.StatusBadRequest, fmt.Sprintf("Error parsing %s argument: %s", common.ParamAsOf, err), w)
+			return
+		}
+		args.Set(common.ParamAsOf, q.To.UTC().Format(time.RFC3339Nano))
+	}
+	if len(args) > 0 {
+		selfLink += "?" + args.Encode()
+	}
+	format, err := responseFormat(r)
+	if err != nil {
+		common.ErrorResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+
+	// Data streams without any record until then are left out
+	data := make(senml.Pack, 0, len(sources))
+	for _, ds := range sources {
+		latest, _, _, err := api.storage.Query(q, ds)
+		if err != nil {
+			common.ErrorResponse(http.StatusInternalServerError, "Error retrieving data from the database: "+err.Error(), w)
+			return
+		}
+		data = append(data, latest...)
+	}
+
+	if format != common.FormatJSON {
+		writeRecords(w, format, data, "")
+		return
+	}
+	b, err := json.Marshal(RecordSet{
+		SelfLink: selfLink,
+		TimeTook: time.Since(timeStart).Seconds(),
+		Data:     data,
+	})
+	if err != nil {
+		common.ErrorResponse(http.StatusInternalServerError, "Error marshalling recordset: "+err.Error(), w)
+		return
+	}
+	w.Header().Add("Content-Type", common.DefaultMIMEType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// Count is a handler for counting the data points of a query, e.g. with a HEAD request
+// The total is returned in the X-Total-Count header, without any data
+// Expected parameters: id(s) and/or filter, optional: from, to, limit, value filters
+func (api *API) Count(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	params := mux.Vars(r)
+
+	// Parse id(s) and/or filter and get sources from registry
+	sources, code, err := api.querySources(splitIDs(params["id"]), r.Form.Get(common.ParamFilter))
+	if err != nil {
+		common.ErrorResponse(code, err.Error(), w)
+		return
+	}
+
+	q, err := ParseQueryParameters(r.Form)
+	if err != nil {
+		common.ErrorResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	q.Filter, err = ParseValueFilter(r.Form)
+	if err != nil {
+		common.ErrorResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+
+	_, total, err := api.storage.Pages(q, sources...)
+	if err != nil {
+		common.ErrorResponse(http.StatusInternalServerError, "Error counting data in the database: "+err.Error(), w)
+		return
+	}
+	w.Header().Set(common.HeaderTotalCount, strconv.Itoa(total))
+	w.WriteHeader(http.StatusOK)
+}
+
+// Delete is a handler for deleting data within a time range
+// Expected parameters: id(s), from, to
+// Responds with the number of deleted records of each data stream
+func (api *API) Delete(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	params := mux.Vars(r)
+
+	// Deleting all the data of a data stream is done by removing it from the registry
+	if r.Form.Get(common.ParamFrom) == "" || r.Form.Get(common.ParamTo) == "" {
+		common.ErrorResponse(http.StatusBadRequest,
+			fmt.Sprintf("Both %s and %s arguments are required for deleting data", common.ParamFrom, common.ParamTo), w)
+		return
+	}
+	q, err := ParseQueryParameters(r.Form)
+	if err != nil {
+		common.ErrorResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+
+	// Parse id(s) and get sources from registry. Nothing is deleted unless all of them are found.
+	ids := strings.Split(params["id"], common.IDSeparator)
+	sources := []*registry.DataStream{}
+	for _, id := range ids {
+		ds, err := api.registry.Get(id)
+		if err != nil {
+			common.ErrorResponse(http.StatusNotFound,
+				fmt.Sprintf("Error retrieving data source %v from the registry: %v", id, err.Error()),
+				w)
+			return
+		}
+		sources = append(sources, ds)
+	}
+
+	// The data streams are deleted one after the other: on error, the data of the previous ones remains deleted
+	res := DeleteResponse{Deleted: make(map[string]int, len(sources))}
+	for _, ds := range sources {
+		count, err := api.storage.Delete(ds, q.From, q.To)
+		if err != nil {
+			msg := fmt.Sprintf("Error deleting data of %s from the database: %s", ds.Name, err)
+			if len(res.Deleted) > 0 {
+				deleted := make([]string, 0, len(res.Deleted))
+				for name := range res.Deleted {
+					deleted = append(deleted, name)
+				}
+				sort.Strings(deleted)
+				msg += fmt.Sprintf(". The data of %s was deleted", strings.Join(deleted, ", "))
+			}
+			common.ErrorResponse(http.StatusInternalServerError, msg, w)
+			return
+		}
+		res.Deleted[ds.Name] += count
+	}
+
+	b, err := json.Marshal(&res)
+	if err != nil {
+		common.ErrorResponse(http.StatusInternalServerError, "Error marshalling response: "+err.Error(), w)
+		return
+	}
+	w.Header().Add("Content-Type", common.DefaultMIMEType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
 }
 
 // Utility functions
@@ -346,7 +865,7 @@ func ParseQueryParameters(form url.Values) (Query, error) {
 		// Start from zero time
 		q.From = time.Time{}
 	} else {
-		q.From, err = time.Parse(time.RFC3339, form.Get(common.ParamFrom))
+		q.From, err = parseTime(form.Get(common.ParamFrom))
 		if err != nil {
 			return Query{}, fmt.Errorf("Error parsing start argument: %s", err)
 		}
@@ -357,7 +876,7 @@ func ParseQueryParameters(form url.Values) (Query, error) {
 		// Open-ended query
 		q.To = time.Now().UTC()
 	} else {
-		q.To, err = time.Parse(time.RFC3339, form.Get(common.ParamTo))
+		q.To, err = parseTime(form.Get(common.ParamTo))
 		if err != nil {
 			return Query{}, fmt.Errorf("Error parsing end argument: %s", err)
 		}
@@ -386,12 +905,22 @@ func ParseQueryParameters(form url.Values) (Query, error) {
 		return Query{}, fmt.Errorf("Invalid sort argument: %v", q.Sort)
 	}
 
+	if token := form.Get(common.ParamCursor); token != "" {
+		q.Cursor, err = ParseCursor(token)
+		if err != nil {
+			return Query{}, fmt.Errorf("Error parsing cursor argument: %s", err)
+		}
+	}
+
 	if form.Get(common.ParamPerPage) == "" {
-		q.perPage = MaxPerPage
+		q.PerPage = MaxPerPage
 	} else {
-		q.perPage, err = strconv.Atoi(form.Get(common.ParamPerPage))
+		q.PerPage, err = strconv.Atoi(form.Get(common.ParamPerPage))
 		if err != nil {
-			return Query{}, fmt.Errorf("Error parsing limit argument: %s", err)
+			return Query{}, fmt.Errorf("Error parsing %s argument: %s", common.ParamPerPage, err)
+		}
+		if q.PerPage < 1 || q.PerPage > MaxPerPage {
+			return Query{}, fmt.Errorf("Invalid %s argument: %d. It must be between 1 and %d", common.ParamPerPage, q.PerPage, MaxPerPage)
 		}
 	}
 
@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"code.linksmart.eu/com/go-sec/auth/obtainer"
+	"code.linksmart.eu/hds/historical-datastore/common"
 	"code.linksmart.eu/hds/historical-datastore/registry"
 	"code.linksmart.eu/sc/service-catalog/utils"
 )
@@ -63,6 +64,8 @@ func (c *RemoteClient) Submit(data []byte, contentType string, id ...string) err
 	return nil
 }
 
+// Query data of the given data sources
+// The next page is queried with the cursor of the returned RecordSet (see RecordSet.NextCursor) set in q
 func (c *RemoteClient) Query(q Query, id ...string) (*RecordSet, error) {
 	path := fmt.Sprintf("%v/%v",
 		c.serverEndpoint,
@@ -95,3 +98,41 @@ func (c *RemoteClient) Query(q Query, id ...string) (*RecordSet, error) {
 	return &rs, nil
 
 }
+
+// QueryTable queries the data of the given data sources in the wide layout, with a row per time and a column per data
+// source. The rows are aligned to the steps of the resampling, if given.
+func (c *RemoteClient) QueryTable(q Query, resampling *Resampling, id ...string) (*TableSet, error) {
+	path := fmt.Sprintf("%v/%v&%v=%v",
+		c.serverEndpoint,
+		GetUrlFromQuery(q, id...),
+		common.ParamLayout, common.LayoutWide)
+	if resampling != nil {
+		path += resampling.query()
+	}
+	res, err := utils.HTTPRequest("GET",
+		path,
+		nil,
+		nil,
+		c.ticket,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read body of response: %v", err.Error())
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %v", res.StatusCode, string(body))
+	}
+
+	var ts TableSet
+	err = json.Unmarshal(body, &ts)
+	if err != nil {
+		return nil, err
+	}
+	return &ts, nil
+}
@@ -4,11 +4,13 @@ package data
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -48,11 +50,13 @@ func setupHTTPAPI() (*mux.Router, []string) {
 		testIDs = append(testIDs, created.Name)
 	}
 
-	api := NewAPI(regStorage, &dummyDataStorage{}, false)
+	api := NewAPI(regStorage, &dummyDataStorage{}, false, nil)
 
 	r := mux.NewRouter().StrictSlash(true).SkipClean(true)
 	r.Methods("POST").Path("/data/{id:.+}").HandlerFunc(api.Submit)
 	r.Methods("GET").Path("/data/{id:.+}").HandlerFunc(api.Query)
+	r.Methods("HEAD").Path("/data/{id:.+}").HandlerFunc(api.Count)
+	r.Methods("DELETE").Path("/data/{id:.+}").HandlerFunc(api.Delete)
 
 	return r, testIDs
 }
@@ -95,9 +99,9 @@ func TestHttpSubmit(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	//if res.StatusCode != http.StatusUnsupportedMediaType {
-	//	t.Errorf("Server response is not %v but %v", http.StatusUnsupportedMediaType, res.StatusCode)
-	//}
+	if res.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Server response is not %v but %v", http.StatusUnsupportedMediaType, res.StatusCode)
+	}
 
 	// try bad payload
 	res, err = http.Post(ts.URL+"/data/"+all, "application/senml+json", bytes.NewReader([]byte{0xde, 0xad}))
@@ -118,6 +122,149 @@ func TestHttpSubmit(t *testing.T) {
 	if res.StatusCode != http.StatusAccepted {
 		t.Errorf("Server response is not %v but %v", http.StatusAccepted, res.StatusCode)
 	}
+
+	// try the other SenML formats
+	for _, format := range []senml.Format{senml.CBOR, senml.XML} {
+		b, err = senml.Pack(records).Encode(format, senml.OutputOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		contentType := senml.MediaTypeSenmlCBOR
+		if format == senml.XML {
+			contentType = senml.MediaTypeSenmlXML
+		}
+		res, err = http.Post(ts.URL+"/data/"+all, contentType, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusAccepted {
+			t.Errorf("Server response for %s is not %v but %v", contentType, http.StatusAccepted, res.StatusCode)
+		}
+	}
+}
+
+func TestHttpSubmitDuplicates(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+	regStorage := registry.NewMemoryStorage(common.RegConf{}, storage)
+	for _, ds := range []registry.DataStream{
+		{Name: "test/reject", Type: common.FLOAT, Duplicates: common.DuplicatesReject},
+		{Name: "test/keep", Type: common.FLOAT, Duplicates: common.DuplicatesKeep},
+	} {
+		_, err := regStorage.Add(ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := NewAPI(regStorage, storage, false, nil)
+	r := mux.NewRouter().StrictSlash(true).SkipClean(true)
+	r.Methods("POST").Path("/data/{id:.+}").HandlerFunc(api.Submit)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	submit := func(name string) (int, SubmitResponse) {
+		v := 1.0
+		b, _ := json.Marshal(senml.Pack{{Name: name, Time: 1543059346, Value: &v}})
+		res, err := http.Post(ts.URL+"/data/"+name, senml.MediaTypeSenmlJSON, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var body SubmitResponse
+		if res.StatusCode == http.StatusAccepted {
+			err = json.NewDecoder(res.Body).Decode(&body)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.StatusCode, body
+	}
+
+	for _, name := range []string{"test/reject", "test/keep"} {
+		code, body := submit(name)
+		if code != http.StatusAccepted || len(body.Duplicates) != 0 {
+			t.Fatalf("%s: unexpected response to the first submission: %v %+v", name, code, body)
+		}
+	}
+	if code, _ := submit("test/reject"); code != http.StatusConflict {
+		t.Errorf("Server response is not %v but %v", http.StatusConflict, code)
+	}
+	code, body := submit("test/keep")
+	expected := []DuplicateReport{{Name: "test/keep", Policy: common.DuplicatesKeep, Count: 1}}
+	if code != http.StatusAccepted || !reflect.DeepEqual(body.Duplicates, expected) {
+		t.Errorf("Unexpected response: %v %+v", code, body)
+	}
+}
+
+func TestHttpSubmitPartial(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+	regStorage := registry.NewMemoryStorage(common.RegConf{}, storage)
+	for _, ds := range []registry.DataStream{
+		{Name: "test/float", Type: common.FLOAT},
+		{Name: "test/reject", Type: common.FLOAT, Duplicates: common.DuplicatesReject},
+	} {
+		_, err := regStorage.Add(ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := NewAPI(regStorage, storage, false, nil)
+	r := mux.NewRouter().StrictSlash(true).SkipClean(true)
+	r.Methods("POST").Path("/data").HandlerFunc(api.SubmitWithoutID)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	v := 1.0
+	b, _ := json.Marshal(senml.Pack{
+		{Name: "test/float", Time: 1543059346, Value: &v},
+		{Name: "test/float", Time: 1543059347, StringValue: "wrong type"},
+		{Name: "test/unknown", Time: 1543059346, Value: &v},
+		{Name: "test/reject", Time: 1543059346, Value: &v},
+		{Name: "test/reject", Time: 1543059346, Value: &v},
+	})
+
+	// all-or-nothing by default
+	res, err := http.Post(ts.URL+"/data", senml.MediaTypeSenmlJSON, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Server response is not %v but %v", http.StatusBadRequest, res.StatusCode)
+	}
+
+	res, err = http.Post(ts.URL+"/data?partial=true", senml.MediaTypeSenmlJSON, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("Server response is not %v but %v", http.StatusAccepted, res.StatusCode)
+	}
+	var body SubmitResponse
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.Accepted != 2 || body.Rejected != 3 || len(body.Records) != 5 {
+		t.Fatalf("Expected 2 accepted and 3 rejected records, got %+v", body)
+	}
+	for i, accepted := range []bool{true, false, false, true, false} {
+		report := body.Records[i]
+		if report.Index != i || report.Accepted != accepted || (report.Error == "") == !accepted {
+			t.Errorf("Unexpected report of record %d: %+v", i, report)
+		}
+	}
+
+	pack, _, _, err := storage.Query(Query{From: time.Unix(0, 0), To: time.Now(), Sort: common.ASC, Limit: -1, PerPage: MaxPerPage},
+		&registry.DataStream{Name: "test/float"}, &registry.DataStream{Name: "test/reject"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pack) != 2 {
+		t.Errorf("Expected 2 stored records, got %v", pack)
+	}
 }
 
 func TestHttpQuery(t *testing.T) {
@@ -145,18 +292,605 @@ func TestHttpQuery(t *testing.T) {
 	//t.Error("TODO: check response body")
 }
 
+func TestHttpQueryPages(t *testing.T) {
+	router, testIDs := setupHTTPAPI()
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// the dummy storage plans two pages with a total of three records
+	for query, expected := range map[string]RecordSet{
+		"?count=true": {Pages: 2},
+		"?page=1":     {Page: 1, Pages: 2, NextLink: "page=2"},
+		"?page=2":     {Page: 2, Pages: 2},
+		"?page=3":     {Page: 3, Pages: 2},
+	} {
+		res, err := http.Get(ts.URL + "/data/" + testIDs[0] + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var recordSet RecordSet
+		err = json.NewDecoder(res.Body).Decode(&recordSet)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK || res.Header.Get(common.HeaderTotalCount) != "3" {
+			t.Fatalf("%s: unexpected response: %v, total %q", query, res.StatusCode, res.Header.Get(common.HeaderTotalCount))
+		}
+		if recordSet.Total == nil || *recordSet.Total != 3 || recordSet.Page != expected.Page || recordSet.Pages != expected.Pages {
+			t.Errorf("%s: unexpected record set: %+v", query, recordSet)
+		}
+		if !strings.HasSuffix(recordSet.SelfLink, strings.TrimPrefix(query, "?")) {
+			t.Errorf("%s: unexpected self link: %s", query, recordSet.SelfLink)
+		}
+		if expected.NextLink != "" && !strings.HasSuffix(recordSet.NextLink, expected.NextLink) ||
+			expected.NextLink == "" && recordSet.NextLink != "" {
+			t.Errorf("%s: unexpected next link: %s", query, recordSet.NextLink)
+		}
+	}
+
+	for _, query := range []string{"?page=0", "?page=x", "?page=1&stream=true", "?cursor=x", "?page=1&cursor=" + Cursor{Time: 1}.String(),
+		"?perPage=0", "?perPage=-1", "?perPage=1001"} {
+		res, err := http.Get(ts.URL + "/data/" + testIDs[0] + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: server response is not %v but %v", query, http.StatusBadRequest, res.StatusCode)
+		}
+	}
+}
+
+func TestHttpCount(t *testing.T) {
+	router, testIDs := setupHTTPAPI()
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	res, err := http.Head(ts.URL + "/data/" + strings.Join(testIDs, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get(common.HeaderTotalCount) != "3" {
+		t.Fatalf("Unexpected response: %v, total %q", res.StatusCode, res.Header.Get(common.HeaderTotalCount))
+	}
+
+	res, err = http.Head(ts.URL + "/data/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Server response is not %v but %v", http.StatusNotFound, res.StatusCode)
+	}
+}
+
+func TestHttpDelete(t *testing.T) {
+	router, testIDs := setupHTTPAPI()
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	all := strings.Join(testIDs, ",")
+	for query, expected := range map[string]int{
+		"":                           http.StatusBadRequest, // the whole data is deleted via the registry
+		"?from=2015-04-24T11:56:51Z": http.StatusBadRequest,
+		"?from=2015-04-24T11:56:51Z&to=2015-04-24T11:56:50Z": http.StatusBadRequest,
+		"?from=2015-04-24T11:56:51Z&to=2015-04-25T11:56:51Z": http.StatusOK,
+	} {
+		req, err := http.NewRequest("DELETE", ts.URL+"/data/"+all+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != expected {
+			t.Errorf("Server response for %q is not %v but %v", query, expected, res.StatusCode)
+		}
+	}
+
+	req, _ := http.NewRequest("DELETE", ts.URL+"/data/unknown?from=2015-04-24T11:56:51Z&to=2015-04-25T11:56:51Z", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Server response is not %v but %v", http.StatusNotFound, res.StatusCode)
+	}
+}
+
+func TestHttpDeleteCounts(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+	regStorage := registry.NewMemoryStorage(common.RegConf{}, storage)
+	dss := []*registry.DataStream{
+		{Name: "test/delete/1", Type: common.FLOAT},
+		{Name: "test/delete/2", Type: common.FLOAT},
+	}
+	const start = 1543059346.0
+	for i, ds := range dss {
+		_, err := regStorage.Add(*ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		submitSeries(t, storage, ds, start, 1, 3+i)
+	}
+	api := NewAPI(regStorage, storage, false, nil)
+	r := mux.NewRouter().StrictSlash(true).SkipClean(true)
+	r.Methods("DELETE").Path("/data/{id:.+}").HandlerFunc(api.Delete)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	del := func(ids string) (int, DeleteResponse) {
+		req, _ := http.NewRequest("DELETE", ts.URL+"/data/"+ids+"?from=2018-11-24T11:00:00Z&to=2018-11-24T12:00:00Z", nil)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var body DeleteResponse
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.StatusCode, body
+	}
+
+	// an unknown data stream fails the deletion before anything is deleted
+	if code, _ := del(dss[0].Name + ",test/delete/unknown"); code != http.StatusNotFound {
+		t.Fatalf("Server response is not %v but %v", http.StatusNotFound, code)
+	}
+	_, total, _, err := storage.Query(Query{To: time.Now(), Sort: common.ASC, Limit: -1, PerPage: 10}, dss[0])
+	if err != nil || total != 3 {
+		t.Fatalf("Expected the records to remain, got %d: %v", total, err)
+	}
+
+	code, body := del(dss[0].Name + "," + dss[1].Name)
+	if expected := map[string]int{dss[0].Name: 3, dss[1].Name: 4}; code != http.StatusOK || !reflect.DeepEqual(body.Deleted, expected) {
+		t.Errorf("Expected the deleted records %v, got %v %v", expected, code, body.Deleted)
+	}
+}
+
+func TestHttpQueryCSV(t *testing.T) {
+	router, testIDs := setupHTTPAPI()
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	all := strings.Join(testIDs, ",")
+	for _, accept := range []string{"text/csv", "application/json;q=0.9, text/csv"} {
+		req, _ := http.NewRequest("GET", ts.URL+"/data/"+all, nil)
+		req.Header.Set("Accept", accept)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != common.CSVMIMEType {
+			t.Fatalf("Unexpected response for Accept %q: %v %s", accept, res.StatusCode, res.Header.Get("Content-Type"))
+		}
+		if string(b) != "time,name,value,unit\n" {
+			t.Errorf("Unexpected CSV body: %q", b)
+		}
+	}
+
+	res, err := http.Get(ts.URL + "/data/" + all + "?format=csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.Header.Get("Content-Type") != common.CSVMIMEType {
+		t.Errorf("Expected CSV for the format argument, got %s", res.Header.Get("Content-Type"))
+	}
+
+	res, err = http.Get(ts.URL + "/data/" + all + "?format=xls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Server response is not %v but %v", http.StatusBadRequest, res.StatusCode)
+	}
+}
+
+func TestHttpQuerySenML(t *testing.T) {
+	router, testIDs := setupHTTPAPI()
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	all := strings.Join(testIDs, ",")
+	for _, mediaType := range []string{senml.MediaTypeSenmlCBOR, senml.MediaTypeSenmlXML} {
+		req, _ := http.NewRequest("GET", ts.URL+"/data/"+all, nil)
+		req.Header.Set("Accept", mediaType)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != mediaType {
+			t.Fatalf("Unexpected response for Accept %q: %v %s", mediaType, res.StatusCode, res.Header.Get("Content-Type"))
+		}
+		format, _ := common.SenMLFormat(mediaType)
+		if _, err := senml.Decode(b, format); err != nil {
+			t.Errorf("Error decoding %s response: %s", mediaType, err)
+		}
+	}
+}
+
+func TestHttpQueryStream(t *testing.T) {
+	router, testIDs := setupHTTPAPI()
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// NDJSON
+	res, err := http.Get(ts.URL + "/data/" + testIDs[0] + "?format=ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != common.NDJSONMIMEType {
+		t.Fatalf("Unexpected response: %v %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got: %s", b)
+	}
+	for _, line := range lines {
+		var r senml.Record
+		if err := json.Unmarshal([]byte(line), &r); err != nil || r.Value == nil {
+			t.Errorf("Invalid record %q: %v", line, err)
+		}
+	}
+
+	// chunked recordset
+	res, err = http.Get(ts.URL + "/data/" + testIDs[0] + "?stream=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	var recordSet RecordSet
+	err = json.Unmarshal(b, &recordSet)
+	if err != nil {
+		t.Fatalf("Invalid recordset %s: %s", b, err)
+	}
+	if len(recordSet.Data) != 3 || recordSet.NextLink != "" || !strings.Contains(recordSet.SelfLink, "stream=true") {
+		t.Errorf("Unexpected recordset: %+v", recordSet)
+	}
+
+	// streamed CSV
+	res, err = http.Get(ts.URL + "/data/" + testIDs[0] + "?stream=true&format=csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if rows := strings.Split(strings.TrimSpace(string(b)), "\n"); len(rows) != 4 || rows[0] != "time,name,value,unit" {
+		t.Errorf("Unexpected CSV: %s", b)
+	}
+}
+
+func TestHttpLatest(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+	regStorage := registry.NewMemoryStorage(common.RegConf{}, storage)
+	for _, ds := range []registry.DataStream{
+		{Name: "test/latest1", Type: common.FLOAT},
+		{Name: "test/latest2", Type: common.FLOAT},
+		{Name: "test/empty", Type: common.FLOAT},
+	} {
+		_, err := regStorage.Add(ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	const start = 1543059346.0
+	submitSeries(t, storage, &registry.DataStream{Name: "test/latest1", Type: common.FLOAT}, start, 10, 5) // values 0-4
+	submitSeries(t, storage, &registry.DataStream{Name: "test/latest2", Type: common.FLOAT}, start, 20, 5)
+
+	api := NewAPI(regStorage, storage, false, nil)
+	r := mux.NewRouter().StrictSlash(true).SkipClean(true)
+	r.Methods("GET").Path("/data/latest").HandlerFunc(api.Latest)
+	r.Methods("GET").Path("/data/{id:.+}/latest").HandlerFunc(api.Latest)
+	r.Methods("GET").Path("/data/{id:.+}").HandlerFunc(api.Query)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for query, expected := range map[string][]float64{
+		"test/latest1,test/latest2,test/empty/latest": {4, 4},
+		// between the records
+		"test/latest1,test/latest2,test/empty/latest?asOf=" + time.Unix(int64(start)+35, 0).UTC().Format(time.RFC3339): {3, 1},
+		// before the first record of the second stream
+		"test/latest1,test/latest2,test/empty/latest?asOf=" + time.Unix(int64(start)-1, 0).UTC().Format(time.RFC3339): {},
+		// once per data stream, in the order of the ids followed by those of the filter
+		"test/latest2,test/latest2/latest?filter=name/prefix/test/latest": {4, 4},
+		"latest?filter=name/prefix/test/latest":                           {4, 4},
+	} {
+		res, err := http.Get(ts.URL + "/data/" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var recordSet RecordSet
+		err = json.NewDecoder(res.Body).Decode(&recordSet)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recordSet.Data) != len(expected) {
+			t.Fatalf("%q: expected %v, got %v", query, expected, recordSet.Data)
+		}
+		for i, r := range recordSet.Data {
+			if *r.Value != expected[i] {
+				t.Errorf("%q: expected %v, got %v", query, expected, recordSet.Data)
+			}
+		}
+	}
+
+	res, err := http.Get(ts.URL + "/data/test/latest1/latest?asOf=yesterday")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Server response is not %v but %v", http.StatusBadRequest, res.StatusCode)
+	}
+
+	// without asOf, a record in the future is the latest one
+	future := float64(time.Now().Add(time.Hour).Unix())
+	submitSeries(t, storage, &registry.DataStream{Name: "test/latest1", Type: common.FLOAT}, future, 0, 1)
+	res, err = http.Get(ts.URL + "/data/test/latest1/latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recordSet RecordSet
+	err = json.NewDecoder(res.Body).Decode(&recordSet)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recordSet.Data) != 1 || recordSet.Data[0].Time != future {
+		t.Errorf("Expected the record at %v, got %v", future, recordSet.Data)
+	}
+}
+
+func TestHttpQueryFilter(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+	regStorage := registry.NewMemoryStorage(common.RegConf{}, storage)
+	const start = 1543059346.0
+	for i, ds := range []registry.DataStream{
+		{Name: "test/b12/temperature", Type: common.FLOAT, Meta: map[string]interface{}{"building": "B12"}},
+		{Name: "test/b12/humidity", Type: common.FLOAT, Meta: map[string]interface{}{"building": "B12"}},
+		{Name: "test/b13/temperature", Type: common.FLOAT, Meta: map[string]interface{}{"building": "B13"}},
+	} {
+		_, err := regStorage.Add(ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		submitSeries(t, storage, &ds, start+float64(i), 3, 4)
+	}
+
+	api := NewAPI(regStorage, storage, false, nil)
+	r := mux.NewRouter().StrictSlash(true).SkipClean(true)
+	r.Methods("GET").Path("/data").HandlerFunc(api.Query)
+	r.Methods("HEAD").Path("/data").HandlerFunc(api.Count)
+	r.Methods("GET").Path("/data/{id:.+}").HandlerFunc(api.Query)
+	r.Methods("HEAD").Path("/data/{id:.+}").HandlerFunc(api.Count)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// the pages of the selected streams are merged by time
+	var names []string
+	link := "/data?filter=meta.building/equals/B12&perPage=3&sort=asc"
+	for link != "" {
+		res, err := http.Get(ts.URL + link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var recordSet RecordSet
+		err = json.NewDecoder(res.Body).Decode(&recordSet)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: server response is not %v but %v", link, http.StatusOK, res.StatusCode)
+		}
+		for _, r := range recordSet.Data {
+			names = append(names, r.Name)
+		}
+		link = recordSet.NextLink
+	}
+	if len(names) != 8 || names[0] != "test/b12/temperature" || names[1] != "test/b12/humidity" {
+		t.Errorf("Expected the records of both streams of the building in turns, got %v", names)
+	}
+
+	res, err := http.Head(ts.URL + "/data?filter=meta.building/equals/B13")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.Header.Get(common.HeaderTotalCount) != "4" {
+		t.Errorf("Expected a total of 4 records, got %q", res.Header.Get(common.HeaderTotalCount))
+	}
+
+	// the data streams given by id and filter are only queried once
+	res, err = http.Head(ts.URL + "/data/test/b13/temperature?filter=meta.building/prefix/B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.Header.Get(common.HeaderTotalCount) != "12" {
+		t.Errorf("Expected a total of 12 records, got %q", res.Header.Get(common.HeaderTotalCount))
+	}
+
+	for query, code := range map[string]int{
+		"":                                 http.StatusBadRequest,
+		"?filter=meta.building":            http.StatusBadRequest,
+		"?filter=meta.building/equals/B14": http.StatusNotFound,
+	} {
+		res, err := http.Get(ts.URL + "/data" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != code {
+			t.Errorf("%q: server response is not %v but %v", query, code, res.StatusCode)
+		}
+	}
+}
+
+func TestHttpQueryWide(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+	regStorage := registry.NewMemoryStorage(common.RegConf{}, storage)
+	const start = 1543059300.0
+	power := &registry.DataStream{Name: "test/wide/power", Type: common.FLOAT}
+	temperature := &registry.DataStream{Name: "test/wide/temperature", Type: common.FLOAT}
+	for _, ds := range []*registry.DataStream{power, temperature} {
+		_, err := regStorage.Add(*ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	submitSeries(t, storage, power, start, 30, 4)       // 0-3 every 30s
+	submitSeries(t, storage, temperature, start, 60, 2) // 0-1 every minute
+
+	api := NewAPI(regStorage, storage, false, nil)
+	r := mux.NewRouter().StrictSlash(true).SkipClean(true)
+	r.Methods("GET").Path("/data/{id:.+}").HandlerFunc(api.Query)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	path := ts.URL + "/data/" + power.Name + "," + temperature.Name + "?sort=asc&layout=wide"
+	for query, expected := range map[string][][]interface{}{
+		"": {
+			{start, 0.0, 0.0},
+			{start + 30, 1.0, nil},
+			{start + 60, 2.0, 1.0},
+			{start + 90, 3.0, nil},
+		},
+		"&step=1m&from=" + time.Unix(int64(start), 0).UTC().Format(time.RFC3339) + "&to=" + time.Unix(int64(start)+60, 0).UTC().Format(time.RFC3339): {
+			{start, 0.5, 0.0},
+			{start + 60, 2.0, 1.0}, // the records until the end of the query
+		},
+	} {
+		res, err := http.Get(path + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tableSet TableSet
+		err = json.NewDecoder(res.Body).Decode(&tableSet)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(tableSet.Columns, []string{"time", power.Name, temperature.Name}) {
+			t.Errorf("%q: unexpected columns: %v", query, tableSet.Columns)
+		}
+		if !reflect.DeepEqual(tableSet.Rows, expected) {
+			t.Errorf("%q: expected rows %v, got %v", query, expected, tableSet.Rows)
+		}
+		if !strings.Contains(tableSet.SelfLink, "layout=wide") {
+			t.Errorf("%q: unexpected self link: %s", query, tableSet.SelfLink)
+		}
+	}
+
+	// the layout is kept on the next pages
+	res, err := http.Get(path + "&perPage=3&format=csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "time,test/wide/power,test/wide/temperature\n" +
+		"2018-11-24T11:35:00Z,0,0\n" +
+		"2018-11-24T11:35:30Z,1,\n"
+	if string(b) != expected {
+		t.Errorf("Expected CSV:\n%s\ngot:\n%s", expected, b)
+	}
+	if link := res.Header.Get("Link"); !strings.Contains(link, "layout=wide") || !strings.Contains(link, "format=csv") {
+		t.Errorf("Unexpected link to the next page: %s", link)
+	}
+
+	for _, query := range []string{"?layout=tall", "?layout=wide&format=ndjson", "?layout=wide&stream=true"} {
+		res, err := http.Get(ts.URL + "/data/" + power.Name + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: server response is not %v but %v", query, http.StatusBadRequest, res.StatusCode)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	v, b := 21.5, true
+	pack := senml.Pack{
+		{Name: "a", Unit: "degC", Time: 1543059346.5, Value: &v},
+		{Name: "b", Time: 1543059347, BoolValue: &b},
+		{Name: "c", Time: 1543059348, StringValue: "x,y"},
+	}
+	var buf bytes.Buffer
+	err := writeCSV(&buf, pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "time,name,value,unit\n" +
+		"2018-11-24T11:35:46.5Z,a,21.5,degC\n" +
+		"2018-11-24T11:35:47Z,b,true,\n" +
+		"2018-11-24T11:35:48Z,c,\"x,y\",\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected CSV:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
 //TODO TEST limits
 
 // DUMMY DATA STORAGE
 
 type dummyDataStorage struct{}
 
-func (s *dummyDataStorage) Submit(data map[string]senml.Pack, sources map[string]*registry.DataStream) error {
-	return nil
+func (s *dummyDataStorage) Submit(data map[string]senml.Pack, sources map[string]*registry.DataStream) (Duplicates, error) {
+	return nil, nil
 }
-func (s *dummyDataStorage) Query(q Query, ds ...*registry.DataStream) (senml.Pack, int, *time.Time, error) {
+func (s *dummyDataStorage) SubmitPartial(data map[string]senml.Pack, sources map[string]*registry.DataStream) (Duplicates, error) {
+	return nil, nil
+}
+func (s *dummyDataStorage) Query(q Query, ds ...*registry.DataStream) (senml.Pack, int, *Cursor, error) {
 	return senml.Pack{}, 0, nil, nil
 }
+func (s *dummyDataStorage) QueryStream(ctx context.Context, q Query, ds ...*registry.DataStream) (<-chan senml.Record, <-chan error) {
+	records := make(chan senml.Record, 3)
+	errs := make(chan error, 1)
+	for i := 0; i < 3; i++ {
+		v := float64(i)
+		records <- senml.Record{Name: ds[0].Name, Time: float64(1543059346 + i), Value: &v}
+	}
+	close(records)
+	close(errs)
+	return records, errs
+}
+func (s *dummyDataStorage) Pages(q Query, ds ...*registry.DataStream) ([]Cursor, int, error) {
+	return []Cursor{{Time: 1543059346, Offset: 0}, {Time: 1543059346, Skip: 2, Offset: 2}}, 3, nil
+}
+func (s *dummyDataStorage) Delete(ds *registry.DataStream, from, to time.Time) (int, error) {
+	return 0, nil
+}
+func (s *dummyDataStorage) IngestionEvents(ds *registry.DataStream, from, to time.Time) ([]IngestionEvent, error) {
+	return nil, nil
+}
 func (s *dummyDataStorage) Disconnect() error {
 	return nil
 }
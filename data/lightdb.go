@@ -1,17 +1,39 @@
 package data
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
 	"time"
 
 	"code.linksmart.eu/hds/historical-datastore/common"
 	"code.linksmart.eu/hds/historical-datastore/registry"
+	"code.linksmart.eu/hds/historical-datastore/rollups"
 	datastore "github.com/dschowta/senml.datastore"
 	"github.com/farshidtz/senml"
 )
 
 type LightdbStorage struct {
 	storage *datastore.SenmlDataStore
+	// rollup sessions of the data streams which declare aggregations, and the locks which serialize the updates of
+	// their rollups. The mutex only guards the maps.
+	sessions    map[string]*rollups.Session
+	rollupLocks map[string]*sync.Mutex
+	// time ranges of the failed updates of rollups, which are repaired with the next submission
+	repairs map[string]timeRange
+	mutex   sync.Mutex
+	// time of the latest stored record of the data streams, for the detection of out-of-order records
+	latest      map[string]float64
+	latestMutex sync.Mutex
+}
+
+// timeRange is a range of SenML times (inclusive)
+type timeRange struct {
+	from, to float64
 }
 
 func NewSenmlStorage(conf common.DataConf) (storage *LightdbStorage, disconnect_func func() error, err error) {
@@ -22,57 +44,740 @@ func NewSenmlStorage(conf common.DataConf) (storage *LightdbStorage, disconnect_
 	}
 	storage = new(LightdbStorage)
 	storage.storage = datastore
+	storage.sessions = make(map[string]*rollups.Session)
+	storage.rollupLocks = make(map[string]*sync.Mutex)
+	storage.repairs = make(map[string]timeRange)
+	storage.latest = make(map[string]float64)
 	return storage, storage.Disconnect, nil
 }
 
-func (s *LightdbStorage) Submit(data map[string]senml.Pack, sources map[string]*registry.DataStream) error {
-	for _, dps := range data {
-		err := s.storage.Add(dps)
+func (s *LightdbStorage) Submit(data map[string]senml.Pack, sources map[string]*registry.DataStream) (Duplicates, error) {
+	return s.submit(data, sources, false)
+}
+
+func (s *LightdbStorage) SubmitPartial(data map[string]senml.Pack, sources map[string]*registry.DataStream) (Duplicates, error) {
+	return s.submit(data, sources, true)
+}
+
+// submit adds the data of all data streams in a single transaction
+// In partial mode, the records rejected by the duplicates policy are discarded instead of failing the submission
+func (s *LightdbStorage) submit(data map[string]senml.Pack, sources map[string]*registry.DataStream, partial bool) (Duplicates, error) {
+	policies := make(map[string]datastore.DuplicatePolicy)
+	for name := range data {
+		if ds := sources[name]; ds != nil {
+			policies[name] = duplicatePolicy(ds.Duplicates)
+			if partial && policies[name] == datastore.Reject {
+				policies[name] = datastore.Discard
+			}
+		}
+	}
+
+	// the rollups are locked while the records are stored and rolled up, so that the rollup sessions add each record
+	// once. The locks are taken in the order of the names, as submissions may share data streams.
+	var aggregated []string
+	for name := range data {
+		if ds := sources[name]; ds != nil && len(ds.Aggregation) > 0 {
+			aggregated = append(aggregated, name)
+		}
+	}
+	sort.Strings(aggregated)
+	for _, name := range aggregated {
+		unlock := s.lockRollups(name)
+		defer unlock()
+	}
+
+	for name := range data {
+		if sources[name] != nil {
+			if err := s.loadLatest(name); err != nil {
+				return nil, fmt.Errorf("error querying the latest record of %s: %s", name, err)
+			}
+		}
+	}
+
+	indices, err := s.storage.AddSeries(data, policies)
+	if err == datastore.ErrDuplicate {
+		for name, dps := range indices {
+			if policies[name] != datastore.Reject {
+				continue
+			}
+			times := make([]float64, 0, len(dps))
+			for _, i := range dps {
+				times = append(times, data[name][i].Time)
+			}
+			return nil, &DuplicateError{Name: name, Times: times}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating batch points: %s", err)
+	}
+	duplicates := Duplicates(indices)
+	s.recordIngestion(data, sources, duplicates)
+
+	// the data is stored: a failed update of the rollups does not fail the submission, but is repaired with the next
+	// submission to the data stream
+	for _, name := range aggregated {
+		err := s.updateRollups(*sources[name], policies[name], data[name], duplicates)
 		if err != nil {
-			return fmt.Errorf("error creating batch points: %s", err)
+			log.Printf("LightdbStorage: error updating rollups of %s, to be repaired with its next submission: %s", name, err)
+		}
+	}
+	return duplicates, nil
+}
+
+// updateRollups rolls up the stored records of a data stream, including the time range of a failed update if any.
+// It requires the rollup lock of the data stream.
+func (s *LightdbStorage) updateRollups(ds registry.DataStream, policy datastore.DuplicatePolicy, dps senml.Pack, duplicates Duplicates) error {
+	if policy == datastore.Discard {
+		dps = duplicates.Without(ds.Name, dps)
+	}
+	// the aggregates of overwritten records are recomputed from the stored records
+	recompute := policy == datastore.Overwrite && len(duplicates[ds.Name]) > 0
+	var from, to float64
+	for i, r := range dps {
+		if i == 0 || r.Time < from {
+			from = r.Time
+		}
+		if i == 0 || r.Time > to {
+			to = r.Time
+		}
+	}
+	s.mutex.Lock()
+	repair, failed := s.repairs[ds.Name]
+	delete(s.repairs, ds.Name)
+	s.mutex.Unlock()
+	if failed {
+		if len(dps) == 0 {
+			from, to = repair.from, repair.to
+		}
+		from, to = math.Min(from, repair.from), math.Max(to, repair.to)
+		recompute = true
+	} else if len(dps) == 0 {
+		return nil
+	}
+
+	var err error
+	if recompute {
+		err = s.resetRollups(ds, datastore.FromSenmlTime(from), datastore.FromSenmlTime(to), false)
+	} else {
+		err = s.rollup(ds, dps)
+	}
+	if err != nil {
+		// the session may include records of which the aggregates were not stored
+		s.mutex.Lock()
+		delete(s.sessions, ds.Name)
+		s.repairs[ds.Name] = timeRange{from: from, to: to}
+		s.mutex.Unlock()
+	}
+	return err
+}
+
+// loadLatest loads the time of the latest stored record of the data stream, unless known
+func (s *LightdbStorage) loadLatest(name string) error {
+	s.latestMutex.Lock()
+	_, found := s.latest[name]
+	s.latestMutex.Unlock()
+	if found {
+		return nil
+	}
+	latest, _, err := s.querySeries(Query{To: endOfTime, Sort: common.DESC}, 1, name)
+	if err != nil && err != datastore.ErrSeriesNotFound {
+		return err
+	}
+
+	s.latestMutex.Lock()
+	defer s.latestMutex.Unlock()
+	// unless updated by a concurrent submission
+	if _, found := s.latest[name]; !found {
+		s.latest[name] = 0
+		if len(latest) > 0 {
+			s.latest[name] = latest[0].Time
 		}
 	}
 	return nil
 }
 
-func (s *LightdbStorage) Query(q Query, sources ...*registry.DataStream) (senml.Pack, int, *time.Time, error) {
-	//TODO: Support multidimensional queries
-	/*Multi dimensional queries have problems with pagination:
+// recordIngestion stores the duplicate and the out-of-order records of the stored data as ingestion events, and
+// updates the times of the latest records.
+// The events are only needed for the data quality report, so that failing to store them does not fail the submission.
+func (s *LightdbStorage) recordIngestion(data map[string]senml.Pack, sources map[string]*registry.DataStream, duplicates Duplicates) {
+	arrival := datastore.ToSenmlTime(time.Now())
+	events := make(map[string]senml.Pack)
+	policies := make(map[string]datastore.DuplicatePolicy)
+	s.latestMutex.Lock()
+	for name, dps := range data {
+		if sources[name] == nil {
+			continue
+		}
+		isDuplicate := make(map[int]bool, len(duplicates[name]))
+		for _, i := range duplicates[name] {
+			isDuplicate[i] = true
+		}
+		series := qualitySeries(name)
+		latest := s.latest[name]
+		for i, r := range dps {
+			var kind string
+			if isDuplicate[i] {
+				kind = EventDuplicate
+			} else if r.Time < latest {
+				kind = EventOutOfOrder
+			}
+			if kind != "" {
+				v := arrival
+				events[series] = append(events[series], senml.Record{Name: series, Time: r.Time, StringValue: kind, Value: &v})
+				policies[series] = datastore.Keep
+			}
+			latest = math.Max(latest, r.Time)
+		}
+		s.latest[name] = latest
+	}
+	s.latestMutex.Unlock()
+	if len(events) == 0 {
+		return
+	}
+	if _, err := s.storage.AddSeries(events, policies); err != nil {
+		log.Printf("LightdbStorage: error storing ingestion events: %s", err)
+	}
+}
 
-	1. Multinextlinks (each dimension in a multidimensional time series gives a next link)
-		1. +handles all the multidimensional scenarios
-		2. - overhead on client to keep track of time series
-	2. Combined response: (a combined list is given with a single next link)
-		1. +handles all the multidimensional scnarios
-		2. - overhead on server to combine the results and deduce the nextlink
+// forgetLatest discards the time of the latest record of the data stream, e.g. after deleting records
+func (s *LightdbStorage) forgetLatest(name string) {
+	s.latestMutex.Lock()
+	delete(s.latest, name)
+	s.latestMutex.Unlock()
+}
+
+// IngestionEvents returns the ingestion events of the records of a data stream within the given time range
+func (s *LightdbStorage) IngestionEvents(ds *registry.DataStream, from, to time.Time) ([]IngestionEvent, error) {
+	records, err := s.loader(qualitySeries(ds.Name))(from, to)
+	if err == datastore.ErrSeriesNotFound {
+		// no event has been recorded
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	events := make([]IngestionEvent, 0, len(records))
+	for _, r := range records {
+		e := IngestionEvent{Kind: r.StringValue, Time: r.Time}
+		if r.Value != nil {
+			e.Arrival = *r.Value
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// duplicatePolicy returns the datastore policy of a data stream duplicates policy
+func duplicatePolicy(policy string) datastore.DuplicatePolicy {
+	switch policy {
+	case common.DuplicatesReject:
+		return datastore.Reject
+	case common.DuplicatesKeep:
+		return datastore.Keep
+	default:
+		return datastore.Overwrite
+	}
+}
+
+// lockRollups locks the rollups of the data stream and returns the function which unlocks them
+// The locks are kept for the lifetime of the storage, as deleting them could let a waiting update run concurrently.
+func (s *LightdbStorage) lockRollups(name string) (unlock func()) {
+	s.mutex.Lock()
+	lock, found := s.rollupLocks[name]
+	if !found {
+		lock = new(sync.Mutex)
+		s.rollupLocks[name] = lock
+	}
+	s.mutex.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// session returns the rollup session of the data stream, which is created if needed
+func (s *LightdbStorage) session(ds registry.DataStream) (*rollups.Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, found := s.sessions[ds.Name]
+	if found {
+		return session, nil
+	}
+	session, err := rollups.NewSession(ds)
+	if err != nil {
+		return nil, err
+	}
+	s.sessions[ds.Name] = session
+	return session, nil
+}
+
+// setSession replaces the rollup session of the data stream, or discards it if nil
+func (s *LightdbStorage) setSession(name string, session *rollups.Session) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if session == nil {
+		delete(s.sessions, name)
+		return
+	}
+	s.sessions[name] = session
+}
+
+// rollup updates the rollup series of the data stream with the given (already stored) records
+// It requires the rollup lock of the data stream.
+func (s *LightdbStorage) rollup(ds registry.DataStream, records senml.Pack) error {
+	session, err := s.session(ds)
+	if err != nil {
+		return err
+	}
+	rolled, err := session.Add(records, s.loader(ds.Name))
+	if err != nil {
+		return err
+	}
+	if len(rolled) == 0 {
+		return nil
+	}
+	return s.storage.AddNormalized(rolled)
+}
+
+// rebuildRollups replaces the rollup series of the data stream with the ones computed from the stored records
+func (s *LightdbStorage) rebuildRollups(oldDS, newDS registry.DataStream) error {
+	unlock := s.lockRollups(newDS.Name)
+	defer unlock()
+
+	s.setSession(oldDS.Name, nil)
+	for _, ds := range []registry.DataStream{oldDS, newDS} {
+		err := s.dropRollups(ds)
+		if err != nil {
+			return err
+		}
+	}
+	if len(newDS.Aggregation) == 0 {
+		return nil
+	}
+
+	session, err := rollups.NewSession(newDS)
+	if err != nil {
+		return err
+	}
+	for _, aggr := range newDS.Aggregation {
+		for _, aggregate := range aggr.Aggregates {
+			err = s.storage.Create(rollups.SeriesName(newDS.Name, aggr, aggregate))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// roll up all existing records, like the ingestion does. The range is split at 1970, as the storage keeps the
+	// records before 1970 after the others, and buckets never span 1970 as they are aligned to the Unix epoch.
+	err = s.rollupRange(session, newDS.Name, beginningOfTime, time.Unix(0, -1))
+	if err != nil {
+		return err
+	}
+	err = s.rollupRange(session, newDS.Name, time.Unix(0, 0), endOfTime)
+	if err != nil {
+		return err
+	}
+	s.setSession(newDS.Name, session)
+	return nil
+}
+
+// rollupRange rolls up the stored records of the data stream within the given time range, page by page
+func (s *LightdbStorage) rollupRange(session *rollups.Session, name string, from, to time.Time) error {
+	q := Query{From: from, To: to, Sort: common.ASC, Limit: -1, PerPage: MaxPerPage}
+	for {
+		records, _, next, err := s.Query(q, &registry.DataStream{Name: name})
+		if err != nil {
+			return err
+		}
+		rolled, err := session.Reload(records, s.loader(name))
+		if err != nil {
+			return err
+		}
+		if len(rolled) > 0 {
+			err = s.storage.AddNormalized(rolled)
+			if err != nil {
+				return err
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		q.Cursor = next
+	}
+}
+
+// purgeRollups updates the rollups of the data stream after deleting the records within the given time range.
+// The aggregates of the affected buckets are deleted and the buckets at the bounds of the range, which may keep some
+// of their records, are rolled up again.
+func (s *LightdbStorage) purgeRollups(ds registry.DataStream, from, to time.Time) error {
+	unlock := s.lockRollups(ds.Name)
+	defer unlock()
+
+	return s.resetRollups(ds, from, to, true)
+}
+
+// resetRollups deletes the aggregates of the buckets within the given time range and rolls up the buckets again: all
+// of them, or only those at the bounds of the range if its records were purged.
+// It requires the rollup lock of the data stream.
+func (s *LightdbStorage) resetRollups(ds registry.DataStream, from, to time.Time, purged bool) error {
+	// the open buckets of the session may include deleted records
+	s.setSession(ds.Name, nil)
+	session, err := rollups.NewSession(ds)
+	if err != nil {
+		return err
+	}
+	for _, aggr := range ds.Aggregation {
+		interval, err := common.ParsePeriod(aggr.Interval)
+		if err != nil {
+			return err
+		}
+		start := rollups.BucketStart(datastore.ToSenmlTime(from), interval)
+		last := rollups.BucketStart(datastore.ToSenmlTime(to), interval)
+		end := rollups.BucketEnd(last, interval)
+		for _, aggregate := range aggr.Aggregates {
+			_, err := s.storage.DeleteRange(rollups.SeriesName(ds.Name, aggr, aggregate), datastore.ToSenmlTime(start), datastore.ToSenmlTime(end))
+			if err != nil && err != datastore.ErrSeriesNotFound {
+				return err
+			}
+		}
+		if !purged {
+			err = s.rollupRange(session, ds.Name, start, end)
+		} else {
+			err = s.rollupRange(session, ds.Name, start, rollups.BucketEnd(start, interval))
+			if err == nil && last.After(start) {
+				err = s.rollupRange(session, ds.Name, last, end)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.setSession(ds.Name, session)
+	return nil
+}
+
+func (s *LightdbStorage) dropRollups(ds registry.DataStream) error {
+	for _, aggr := range ds.Aggregation {
+		for _, aggregate := range aggr.Aggregates {
+			err := s.storage.Delete(rollups.SeriesName(ds.Name, aggr, aggregate))
+			if err != nil && err != datastore.ErrSeriesNotFound {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loader returns a rollups.Loader for the given series
+func (s *LightdbStorage) loader(series string) rollups.Loader {
+	return func(from, to time.Time) (senml.Pack, error) {
+		var pack senml.Pack
+		q := Query{From: from, To: to, Sort: common.ASC, Limit: -1, PerPage: MaxPerPage}
+		for {
+			records, _, next, err := s.Query(q, &registry.DataStream{Name: series})
+			if err != nil {
+				return nil, err
+			}
+			pack = append(pack, records...)
+			if next == nil {
+				return pack, nil
+			}
+			q.Cursor = next
+		}
+	}
+}
+
+func (s *LightdbStorage) Query(q Query, sources ...*registry.DataStream) (senml.Pack, int, *Cursor, error) {
+	var offset, skip int
+	if q.Cursor != nil {
+		offset, skip = q.Cursor.Offset, q.Cursor.Skip
+		if q.Sort == common.DESC {
+			q.To = datastore.FromSenmlTime(q.Cursor.Time)
+		} else {
+			q.From = datastore.FromSenmlTime(q.Cursor.Time)
+		}
+	}
 
-	*/
 	//TODO: Is this a right place to decide the maxentries? Should be at API level
-	maxEntries := q.perPage
-	if q.Limit > 0 && q.perPage > q.Limit { //if limit is provided by the user and it is less than perPage, then use the limit
-		maxEntries = q.Limit
+	maxEntries := q.PerPage
+	if q.Limit > 0 && q.Limit-offset < maxEntries { //if limit is provided by the user and less than perPage remains, then use the limit
+		maxEntries = q.Limit - offset
+		if maxEntries <= 0 {
+			return senml.Pack{}, 0, nil, nil
+		}
 	}
 
-	senmlQuery := datastore.Query{
-		From:       datastore.ToSenmlTime(q.From),
-		To:         datastore.ToSenmlTime(q.To),
-		MaxEntries: maxEntries,
-		Series:     sources[0].Name,
-		Sort:       q.Sort,
+	/*Queries are answered with a combined response: each series is queried for a full page (plus the records which
+	are skipped at the cursor), the results are merged in time order and the cursor of the next page is deduced for the
+	merged list. A series can contribute at most skip+maxEntries records to a page, therefore the first skip+maxEntries
+	records of the merge are exactly the first skip+maxEntries records of the combined series.
+	*/
+	cursors := make([]*seriesCursor, 0, len(sources))
+	for _, ds := range sources {
+		pack, nextEntry, err := s.querySeries(q, skip+maxEntries, ds.Name)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		cursors = append(cursors, &seriesCursor{pack: pack, nextEntry: nextEntry})
+	}
+
+	before := func(t1, t2 float64) bool {
+		if q.Sort == common.DESC {
+			return t1 > t2
+		}
+		return t1 < t2
+	}
+
+	retPack := make(senml.Pack, 0, maxEntries)
+	for skipped := 0; len(retPack) < maxEntries; {
+		// pick the earliest head (in the sorting order). Ties are resolved in the order of the given sources
+		var head *seriesCursor
+		for _, c := range cursors {
+			if c.pos < len(c.pack) && (head == nil || before(c.pack[c.pos].Time, head.pack[head.pos].Time)) {
+				head = c
+			}
+		}
+		if head == nil {
+			break
+		}
+		record := head.pack[head.pos]
+		head.pos++
+		if skipped < skip && record.Time == q.Cursor.Time {
+			// returned on a previous page
+			skipped++
+			continue
+		}
+		retPack = append(retPack, record)
+	}
+
+	// The next entry is the earliest of the records that were not consumed by the merge
+	var nextEntry *float64
+	for _, c := range cursors {
+		var candidate *float64
+		if c.pos < len(c.pack) {
+			candidate = &c.pack[c.pos].Time
+		} else if c.nextEntry != nil {
+			candidate = c.nextEntry
+		}
+		if candidate != nil && (nextEntry == nil || before(*candidate, *nextEntry)) {
+			nextEntry = candidate
+		}
 	}
-	retPack, nextlink, err := s.storage.Query(senmlQuery)
+	if nextEntry == nil || q.Limit > 0 && offset+len(retPack) >= q.Limit {
+		return retPack, len(retPack), nil, nil
+	}
+
+	// The next page starts at the next entry, after the records with the same timestamp which were already returned
+	next := &Cursor{Time: *nextEntry, Offset: offset + len(retPack)}
+	for i := len(retPack) - 1; i >= 0 && retPack[i].Time == next.Time; i-- {
+		next.Skip++
+	}
+	if q.Cursor != nil && q.Cursor.Time == next.Time {
+		next.Skip += skip
+	}
+	return retPack, len(retPack), next, nil
+}
+
+func (s *LightdbStorage) Pages(q Query, sources ...*registry.DataStream) ([]Cursor, int, error) {
+	if q.PerPage <= 0 {
+		return nil, 0, fmt.Errorf("invalid number of entries per page: %d", q.PerPage)
+	}
+	// The keys of all series are walked in the merged order, to find the records which share the time of a page start.
+	// Only the records are read which need to be filtered.
+	queries := make([]datastore.Query, 0, len(sources))
+	for _, ds := range sources {
+		queries = append(queries, datastore.Query{
+			From:       datastore.ToSenmlTime(q.From),
+			To:         datastore.ToSenmlTime(q.To),
+			MaxEntries: q.PerPage,
+			Series:     ds.Name,
+			Sort:       q.Sort,
+			Filter:     q.Filter.matcher(),
+		})
+	}
+	starts, total, err := s.storage.GetMergedPages(queries)
 	if err != nil {
-		return nil, 0, nil, err
+		return nil, 0, err
+	}
+	pages := make([]Cursor, 0, len(starts))
+	for i, start := range starts {
+		pages = append(pages, Cursor{Time: start.Time, Offset: i * q.PerPage, Skip: start.Skip})
 	}
 
-	var nextLinkTime *time.Time
+	if q.Limit > 0 && total > q.Limit {
+		total = q.Limit
+		for len(pages) > 0 && pages[len(pages)-1].Offset >= q.Limit {
+			pages = pages[:len(pages)-1]
+		}
+	}
+	return pages, total, nil
+}
 
-	if nextlink != nil {
-		t := datastore.FromSenmlTime(*nextlink)
-		nextLinkTime = &t
+func (s *LightdbStorage) Delete(ds *registry.DataStream, from, to time.Time) (int, error) {
+	count, err := s.storage.DeleteRange(ds.Name, datastore.ToSenmlTime(from), datastore.ToSenmlTime(to))
+	if err != nil {
+		return 0, err
 	}
+	_, err = s.storage.DeleteRange(qualitySeries(ds.Name), datastore.ToSenmlTime(from), datastore.ToSenmlTime(to))
+	if err != nil && err != datastore.ErrSeriesNotFound {
+		return count, fmt.Errorf("error deleting ingestion events of %s: %s", ds.Name, err)
+	}
+	s.forgetLatest(ds.Name)
+	if count > 0 && len(ds.Aggregation) > 0 {
+		err = s.purgeRollups(*ds, from, to)
+		if err != nil {
+			return count, fmt.Errorf("error updating rollups of %s: %s", ds.Name, err)
+		}
+	}
+	return count, nil
+}
+
+func (s *LightdbStorage) QueryStream(ctx context.Context, q Query, sources ...*registry.DataStream) (<-chan senml.Record, <-chan error) {
+	recordCh := make(chan senml.Record, 10)
+	errCh := make(chan error, 1)
+	go func() {
+		err := s.stream(ctx, q, recordCh, sources)
+		close(recordCh)
+		errCh <- err
+		close(errCh)
+	}()
+	return recordCh, errCh
+}
+
+// stream merges the records of the series, which are read from the datastore without buffering, in time order
+func (s *LightdbStorage) stream(ctx context.Context, q Query, out chan<- senml.Record, sources []*registry.DataStream) (err error) {
+	var offset, skip int
+	if q.Cursor != nil {
+		offset, skip = q.Cursor.Offset, q.Cursor.Skip
+		if q.Sort == common.DESC {
+			q.To = datastore.FromSenmlTime(q.Cursor.Time)
+		} else {
+			q.From = datastore.FromSenmlTime(q.Cursor.Time)
+		}
+	}
+	limit := -1 // no limit
+	if q.Limit > 0 {
+		limit = q.Limit - offset
+		if limit <= 0 {
+			return nil
+		}
+	}
+	maxEntries := limit
+	if limit > 0 {
+		maxEntries += skip
+	}
+	streams := make([]*seriesStream, 0, len(sources))
+	for _, ds := range sources {
+		senmlQuery := datastore.Query{
+			From:       datastore.ToSenmlTime(q.From),
+			To:         datastore.ToSenmlTime(q.To),
+			MaxEntries: maxEntries,
+			Series:     ds.Name,
+			Sort:       q.Sort,
+			Filter:     q.Filter.matcher(),
+		}
+		records, nextEntry, errs := s.storage.QueryOnChannel(senmlQuery)
+		streams = append(streams, &seriesStream{records: records, nextEntry: nextEntry, errs: errs})
+	}
+	defer func() {
+		// the datastore keeps the transactions open until the channels are drained
+		for _, st := range streams {
+			if drainErr := st.drain(); err == nil {
+				err = drainErr
+			}
+		}
+	}()
+
+	before := func(t1, t2 float64) bool {
+		if q.Sort == common.DESC {
+			return t1 > t2
+		}
+		return t1 < t2
+	}
+	for _, st := range streams {
+		st.next()
+	}
+	for count, skipped := 0, 0; limit < 0 || count < limit; {
+		// same as in Query, ties are resolved in the order of the given sources
+		var head *seriesStream
+		for _, st := range streams {
+			if st.head != nil && (head == nil || before(st.head.Time, head.head.Time)) {
+				head = st
+			}
+		}
+		if head == nil {
+			return nil
+		}
+		if skipped < skip && head.head.Time == q.Cursor.Time {
+			skipped++
+			head.next()
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		select {
+		case out <- *head.head:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		count++
+		head.next()
+	}
+	return nil
+}
+
+// seriesStream keeps track of the head of a series in a streamed multi-series merge
+type seriesStream struct {
+	records   <-chan senml.Record
+	nextEntry chan *float64
+	errs      chan error
+	head      *senml.Record
+	drained   bool
+}
+
+// next reads the next record of the series into head, or sets head to nil at the end of the series
+func (st *seriesStream) next() {
+	r, ok := <-st.records
+	if !ok {
+		st.head = nil
+		return
+	}
+	st.head = &r
+}
 
-	return retPack, len(retPack), nextLinkTime, nil
+// drain consumes the remaining records of the series and returns the error of the query
+func (st *seriesStream) drain() error {
+	if st.drained {
+		return nil
+	}
+	st.drained = true
+	for range st.records {
+	}
+	<-st.nextEntry
+	return <-st.errs
+}
+
+// querySeries queries a single series for at most maxEntries records
+func (s *LightdbStorage) querySeries(q Query, maxEntries int, series string) (senml.Pack, *float64, error) {
+	senmlQuery := datastore.Query{
+		From:       datastore.ToSenmlTime(q.From),
+		To:         datastore.ToSenmlTime(q.To),
+		MaxEntries: maxEntries,
+		Series:     series,
+		Sort:       q.Sort,
+		Filter:     q.Filter.matcher(),
+	}
+	return s.storage.Query(senmlQuery)
+}
+
+// seriesCursor keeps track of the position of a series in a multi-series merge
+type seriesCursor struct {
+	pack      senml.Pack
+	pos       int
+	nextEntry *float64
 }
 
 func (s *LightdbStorage) Disconnect() error {
@@ -81,13 +786,21 @@ func (s *LightdbStorage) Disconnect() error {
 
 // CreateHandler handles the creation of a new data source
 func (s *LightdbStorage) CreateHandler(ds registry.DataStream) error {
-	return s.storage.Create(ds.Name)
+	err := s.storage.Create(ds.Name)
+	if err != nil {
+		return err
+	}
+	if len(ds.Aggregation) > 0 {
+		return s.rebuildRollups(ds, ds)
+	}
+	return nil
 }
 
 // UpdateHandler handles updates of a data source
 func (s *LightdbStorage) UpdateHandler(oldDS registry.DataStream, newDS registry.DataStream) error {
-	//TODO supporting retetion
-
+	if !reflect.DeepEqual(oldDS.Aggregation, newDS.Aggregation) {
+		return s.rebuildRollups(oldDS, newDS)
+	}
 	return nil
 }
 
@@ -97,6 +810,18 @@ func (s *LightdbStorage) DeleteHandler(ds registry.DataStream) error {
 	if err != nil && err != datastore.ErrSeriesNotFound {
 		return err
 	}
+	err = s.storage.Delete(qualitySeries(ds.Name))
+	if err != nil && err != datastore.ErrSeriesNotFound {
+		return err
+	}
+	s.forgetLatest(ds.Name)
+	unlock := s.lockRollups(ds.Name)
+	s.setSession(ds.Name, nil)
+	err = s.dropRollups(ds)
+	unlock()
+	if err != nil {
+		return err
+	}
 	//log.Println("LightdbStorage: dropped measurements for", ds.Name)
 	return nil
 }
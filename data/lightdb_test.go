@@ -0,0 +1,584 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"code.linksmart.eu/hds/historical-datastore/rollups"
+	datastore "github.com/dschowta/senml.datastore"
+	"github.com/farshidtz/senml"
+)
+
+func setupLightdbStorage(t *testing.T) (*LightdbStorage, func()) {
+	f, err := ioutil.TempFile("", "lightdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	storage, disconnect, err := NewSenmlStorage(common.DataConf{Backend: common.DataBackendConf{Type: SENMLSTORE, DSN: f.Name()}})
+	if err != nil {
+		os.Remove(f.Name())
+		t.Fatal(err)
+	}
+	return storage, func() {
+		disconnect()
+		os.Remove(f.Name())
+	}
+}
+
+// submitSeries stores count float records for the given stream, starting at start and spaced by step seconds
+func submitSeries(t *testing.T, storage Storage, ds *registry.DataStream, start, step float64, count int) senml.Pack {
+	var pack senml.Pack
+	for i := 0; i < count; i++ {
+		v := float64(i)
+		pack = append(pack, senml.Record{Name: ds.Name, Value: &v, Time: start + float64(i)*step})
+	}
+	_, err := storage.Submit(map[string]senml.Pack{ds.Name: pack}, map[string]*registry.DataStream{ds.Name: ds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pack
+}
+
+func TestLightdbMultiSeriesQuery(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+
+	ds1 := &registry.DataStream{Name: "test/multi1", Type: common.FLOAT}
+	ds2 := &registry.DataStream{Name: "test/multi2", Type: common.FLOAT}
+	const start = 1543059346.0
+	// ds1 has records at even seconds, ds2 at every third second, so that some timestamps are shared
+	submitSeries(t, storage, ds1, start, 2, 10)
+	submitSeries(t, storage, ds2, start, 3, 10)
+	const total = 20
+
+	for _, sort := range []string{common.ASC, common.DESC} {
+		q := Query{
+			From:    time.Unix(0, 0),
+			To:      time.Now(),
+			Sort:    sort,
+			Limit:   -1,
+			PerPage: 3,
+		}
+		var all senml.Pack
+		for pages := 0; ; pages++ {
+			if pages > total {
+				t.Fatalf("%s: too many pages", sort)
+			}
+			pack, count, next, err := storage.Query(q, ds1, ds2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != len(pack) || count > q.PerPage {
+				t.Fatalf("%s: unexpected page size %d", sort, count)
+			}
+			all = append(all, pack...)
+			if next == nil {
+				break
+			}
+			q.Cursor = next
+		}
+
+		if len(all) != total {
+			t.Fatalf("%s: expected %d records over all pages, got %d", sort, total, len(all))
+		}
+		for i := 1; i < len(all); i++ {
+			if sort == common.ASC && all[i].Time < all[i-1].Time || sort == common.DESC && all[i].Time > all[i-1].Time {
+				t.Fatalf("%s: records are not ordered at %d: %v, %v", sort, i, all[i-1].Time, all[i].Time)
+			}
+			if all[i].Time == all[i-1].Time && all[i].Name == all[i-1].Name {
+				t.Fatalf("%s: record returned twice: %v", sort, all[i])
+			}
+		}
+	}
+}
+
+func TestLightdbPages(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+
+	ds1 := &registry.DataStream{Name: "test/pages1", Type: common.FLOAT}
+	ds2 := &registry.DataStream{Name: "test/pages2", Type: common.FLOAT}
+	const start = 1543059346.0
+	// same layout as in TestLightdbMultiSeriesQuery, with shared timestamps
+	submitSeries(t, storage, ds1, start, 2, 10)
+	submitSeries(t, storage, ds2, start, 3, 10)
+
+	for _, sources := range [][]*registry.DataStream{{ds1}, {ds1, ds2}} {
+		for _, sort := range []string{common.ASC, common.DESC} {
+			for _, limit := range []int{-1, 7} {
+				q := Query{From: time.Unix(0, 0), To: time.Now(), Sort: sort, Limit: limit, PerPage: 3}
+				pages, total, err := storage.Pages(q, sources...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// the pages must start at the cursors of the next links
+				var expected []Cursor
+				offset := 0
+				for next := q; ; {
+					pack, count, cursor, err := storage.Query(next, sources...)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if count == 0 {
+						break
+					}
+					if next.Cursor == nil {
+						expected = append(expected, Cursor{Time: pack[0].Time})
+					} else {
+						expected = append(expected, *next.Cursor)
+					}
+					offset += count
+					if cursor == nil {
+						break
+					}
+					next.Cursor = cursor
+				}
+				if total != offset {
+					t.Errorf("%d series, %s, limit %d: expected a total of %d, got %d", len(sources), sort, limit, offset, total)
+				}
+				if len(pages) != len(expected) {
+					t.Fatalf("%d series, %s, limit %d: expected pages %v, got %v", len(sources), sort, limit, expected, pages)
+				}
+				for i := range pages {
+					if pages[i] != expected[i] {
+						t.Errorf("%d series, %s, limit %d: expected page %d to be %v, got %v", len(sources), sort, limit, i+1, expected[i], pages[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestLightdbQueryCursor(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+
+	// bursts of five records with the same timestamp
+	var sources []*registry.DataStream
+	for i := 0; i < 5; i++ {
+		ds := &registry.DataStream{Name: fmt.Sprintf("test/burst%d", i), Type: common.FLOAT}
+		submitSeries(t, storage, ds, 1543059346, 1, 3)
+		sources = append(sources, ds)
+	}
+
+	for _, sort := range []string{common.ASC, common.DESC} {
+		for _, limit := range []int{-1, 11} {
+			expected := 15
+			if limit > 0 {
+				expected = limit
+			}
+			q := Query{From: time.Unix(0, 0), To: time.Now(), Sort: sort, Limit: limit, PerPage: 4}
+			seen := make(map[string]bool)
+			for pages := 0; ; pages++ {
+				if pages > expected {
+					t.Fatalf("%s, limit %d: too many pages", sort, limit)
+				}
+				pack, _, next, err := storage.Query(q, sources...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, r := range pack {
+					key := fmt.Sprintf("%s@%v", r.Name, r.Time)
+					if seen[key] {
+						t.Fatalf("%s, limit %d: record returned twice: %s", sort, limit, key)
+					}
+					seen[key] = true
+				}
+				if next == nil {
+					break
+				}
+				if len(pack) != q.PerPage {
+					t.Fatalf("%s, limit %d: expected a full page before the last, got %d records", sort, limit, len(pack))
+				}
+				// the cursor is passed on as a token
+				q.Cursor, err = ParseCursor(next.String())
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(seen) != expected {
+				t.Errorf("%s, limit %d: expected %d records over all pages, got %d", sort, limit, expected, len(seen))
+			}
+
+			// a stream continues at the cursor as well
+			q.Cursor = &Cursor{Time: 1543059346, Skip: 2, Offset: 2}
+			if sort == common.DESC {
+				q.Cursor.Time += 2
+			}
+			records, errs := storage.QueryStream(context.Background(), q, sources...)
+			count := 0
+			for range records {
+				count++
+			}
+			if err := <-errs; err != nil {
+				t.Fatal(err)
+			}
+			if count != expected-2 {
+				t.Errorf("%s, limit %d: expected %d streamed records after the cursor, got %d", sort, limit, expected-2, count)
+			}
+		}
+	}
+}
+
+func TestLightdbDuplicates(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+
+	const start = 1543059346.0
+	record := func(name string, i int, v float64) senml.Record {
+		return senml.Record{Name: name, Time: start + float64(i), Value: &v}
+	}
+	for policy, expected := range map[string][]float64{
+		"":                         {0, 3, 5},
+		common.DuplicatesOverwrite: {0, 3, 5},
+		common.DuplicatesReject:    {0, 1},
+		common.DuplicatesKeep:      {0, 1, 3, 4, 5},
+	} {
+		ds := &registry.DataStream{Name: "test/duplicates/" + policy, Type: common.FLOAT, Duplicates: policy}
+		sources := map[string]*registry.DataStream{ds.Name: ds}
+		_, err := storage.Submit(map[string]senml.Pack{ds.Name: {record(ds.Name, 0, 0), record(ds.Name, 1, 1)}}, sources)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// one record at the time of a stored record, and one at the time of another submitted record
+		duplicates, err := storage.Submit(map[string]senml.Pack{ds.Name: {record(ds.Name, 1, 3), record(ds.Name, 2, 4), record(ds.Name, 2, 5)}}, sources)
+		if policy == common.DuplicatesReject {
+			dupErr, ok := err.(*DuplicateError)
+			if !ok || len(dupErr.Times) != 2 || dupErr.Times[0] != start+1 {
+				t.Fatalf("%s: expected a duplicate error, got %v", policy, err)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(duplicates[ds.Name], []int{0, 2}) {
+			t.Fatalf("%s: expected the duplicates 0 and 2, got %v", policy, duplicates)
+		}
+
+		for _, sort := range []string{common.ASC, common.DESC} {
+			// pages of a single record must still return all records at the same time
+			q := Query{From: time.Unix(0, 0), To: time.Now(), Sort: sort, Limit: -1, PerPage: 1}
+			var values []float64
+			for {
+				pack, _, next, err := storage.Query(q, ds)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, r := range pack {
+					values = append(values, *r.Value)
+				}
+				if next == nil {
+					break
+				}
+				q.Cursor = next
+			}
+			if sort == common.DESC {
+				// records at the same time are returned in the reverse order as well
+				for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+					values[i], values[j] = values[j], values[i]
+				}
+			}
+			if !reflect.DeepEqual(values, expected) {
+				t.Errorf("%q, %s: expected values %v, got %v", policy, sort, expected, values)
+			}
+
+			_, total, err := storage.Pages(q, ds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != len(expected) {
+				t.Errorf("%q, %s: expected a total of %d, got %d", policy, sort, len(expected), total)
+			}
+		}
+	}
+}
+
+func TestLightdbSubmitPartial(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+
+	const start = 1543059346.0
+	accepting := &registry.DataStream{Name: "test/partial/accepting", Type: common.FLOAT}
+	rejecting := &registry.DataStream{Name: "test/partial/rejecting", Type: common.FLOAT, Duplicates: common.DuplicatesReject}
+	sources := map[string]*registry.DataStream{accepting.Name: accepting, rejecting.Name: rejecting}
+	submitSeries(t, storage, accepting, start-1, 1, 1)
+	submitSeries(t, storage, rejecting, start, 1, 1)
+
+	v := 1.0
+	data := map[string]senml.Pack{
+		accepting.Name: {{Name: accepting.Name, Time: start, Value: &v}},
+		rejecting.Name: {{Name: rejecting.Name, Time: start + 1, Value: &v}, {Name: rejecting.Name, Time: start, Value: &v}},
+	}
+	count := func(ds *registry.DataStream) int {
+		_, total, err := storage.Pages(Query{From: time.Unix(0, 0), To: time.Now(), Sort: common.ASC, Limit: -1, PerPage: MaxPerPage}, ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return total
+	}
+
+	// a rejection fails the whole submission
+	_, err := storage.Submit(data, sources)
+	if _, ok := err.(*DuplicateError); !ok {
+		t.Fatalf("Expected a duplicate error, got %v", err)
+	}
+	if count(accepting) != 1 || count(rejecting) != 1 {
+		t.Fatalf("Expected no stored records of the failed submission, got %d and %d", count(accepting)-1, count(rejecting)-1)
+	}
+
+	// a partial submission leaves out the rejected record
+	duplicates, err := storage.SubmitPartial(data, sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(duplicates, Duplicates{rejecting.Name: {1}}) {
+		t.Fatalf("Expected the second record of %s as duplicate, got %v", rejecting.Name, duplicates)
+	}
+	if count(accepting) != 2 || count(rejecting) != 2 {
+		t.Fatalf("Expected 2 stored records of each, got %d and %d", count(accepting), count(rejecting))
+	}
+}
+
+func TestLightdbSubmitRollupRepair(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+
+	ds := registry.DataStream{Name: "test/rollup/repair", Type: common.FLOAT,
+		Aggregation: []registry.Aggregation{{Interval: "1h", Aggregates: []string{"sum"}}}}
+	// the rollups of an invalid aggregation cannot be updated
+	broken := ds
+	broken.Aggregation = []registry.Aggregation{{Interval: "h1", Aggregates: []string{"sum"}}}
+
+	const start = 1543057200.0                      // aligned to the hour
+	submitSeries(t, storage, &broken, start, 60, 3) // values 0-2
+	q := Query{From: time.Unix(0, 0), To: time.Now(), Sort: common.ASC, Limit: -1, PerPage: MaxPerPage}
+	if _, total, _, err := storage.Query(q, &ds); err != nil || total != 3 {
+		t.Fatalf("Expected the records to be stored in spite of the rollups, got %d: %v", total, err)
+	}
+
+	// the next submission repairs the rollups of the previous one
+	submitSeries(t, storage, &ds, start+180, 60, 2) // values 0-1
+	rollup := &registry.DataStream{Name: rollups.SeriesName(ds.Name, ds.Aggregation[0], "sum"), Type: common.FLOAT}
+	pack, _, _, err := storage.Query(q, rollup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pack) != 1 || pack[0].Time != start || *pack[0].Value != 0+1+2+0+1 {
+		t.Fatalf("Unexpected rollup after repair: %v", pack)
+	}
+}
+
+func TestLightdbRebuildRollupsFullRange(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+
+	ds := registry.DataStream{Name: "test/rollup/range", Type: common.FLOAT}
+	err := storage.CreateHandler(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a record before 1970 (as SenML relative time), one in the past and one in the future
+	now := time.Now()
+	future := now.Add(240 * time.Hour).Unix()
+	for _, at := range []float64{-float64(now.Unix() + 86400), 1543059346, float64(future)} {
+		submitSeries(t, storage, &ds, at, 0, 1)
+	}
+	expected := []time.Time{time.Unix(-86400, 0), time.Unix(1543059346, 0), time.Unix(future, 0)}
+	for i := range expected {
+		expected[i] = expected[i].Truncate(time.Hour)
+	}
+
+	// declaring the aggregation rolls up all of them
+	aggregated := ds
+	aggregated.Aggregation = []registry.Aggregation{{Interval: "1h", Aggregates: []string{"sum"}}}
+	err = storage.UpdateHandler(ds, aggregated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollup := &registry.DataStream{Name: rollups.SeriesName(ds.Name, aggregated.Aggregation[0], "sum"), Type: common.FLOAT}
+	q := Query{From: beginningOfTime, To: time.Unix(0, -1), Sort: common.ASC, Limit: -1, PerPage: MaxPerPage}
+	pack, _, _, err := storage.Query(q, rollup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pack) != 1 || !datastore.FromSenmlTime(pack[0].Time).Equal(expected[0]) {
+		t.Fatalf("Expected the rollup at %s before 1970, got %v", expected[0], pack)
+	}
+	q.From, q.To = time.Unix(0, 0), endOfTime
+	pack, _, _, err = storage.Query(q, rollup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, at := range expected[1:] {
+		found := false
+		for _, r := range pack {
+			found = found || datastore.FromSenmlTime(r.Time).Equal(at)
+		}
+		if !found {
+			t.Errorf("Expected a rollup at %s, got %v", at, pack)
+		}
+	}
+}
+
+func TestLightdbValueFilter(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+
+	ds1 := &registry.DataStream{Name: "test/filter1", Type: common.FLOAT}
+	ds2 := &registry.DataStream{Name: "test/filter2", Type: common.FLOAT}
+	const start = 1543059346.0
+	submitSeries(t, storage, ds1, start, 1, 20)
+	submitSeries(t, storage, ds2, start, 2, 10)
+
+	filter, err := ParseValueFilter(url.Values{common.ParamValue: {"gte:6", "lt:18"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = 12 + 4 // 6..17 of the first and 6..9 of the second stream
+
+	for _, sort := range []string{common.ASC, common.DESC} {
+		q := Query{From: time.Unix(0, 0), To: time.Now(), Sort: sort, Limit: -1, PerPage: 3, Filter: filter}
+		count := 0
+		for {
+			pack, _, next, err := storage.Query(q, ds1, ds2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range pack {
+				if *r.Value < 6 || *r.Value >= 18 {
+					t.Fatalf("%s: unexpected record %v", sort, r)
+				}
+			}
+			count += len(pack)
+			if next == nil {
+				break
+			}
+			q.Cursor = next
+		}
+		if count != expected {
+			t.Errorf("%s: expected %d matching records, got %d", sort, expected, count)
+		}
+
+		q.Cursor = nil
+		pages, total, err := storage.Pages(q, ds1, ds2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != expected || len(pages) != (expected+2)/3 {
+			t.Errorf("%s: expected %d matching records on %d pages, got %d on %d", sort, expected, (expected+2)/3, total, len(pages))
+		}
+
+		// the limit counts the matching records
+		q.Limit = 5
+		records, errs := storage.QueryStream(context.Background(), q, ds1, ds2)
+		streamed := 0
+		for range records {
+			streamed++
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		if streamed != 5 {
+			t.Errorf("%s: expected 5 streamed records, got %d", sort, streamed)
+		}
+	}
+}
+
+func TestLightdbDelete(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+
+	ds := &registry.DataStream{Name: "test/delete", Type: common.FLOAT}
+	const start = 1543059346.0
+	submitSeries(t, storage, ds, start, 1, 10)
+
+	// delete the records 3, 4 and 5
+	count, err := storage.Delete(ds, time.Unix(int64(start)+3, 0), time.Unix(int64(start)+5, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("Expected 3 deleted records, got %d", count)
+	}
+
+	q := Query{From: time.Unix(0, 0), To: time.Now(), Sort: common.ASC, Limit: -1, PerPage: MaxPerPage}
+	pack, _, _, err := storage.Query(q, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pack) != 7 {
+		t.Fatalf("Expected 7 remaining records, got %v", pack)
+	}
+	for _, r := range pack {
+		if *r.Value >= 3 && *r.Value <= 5 {
+			t.Errorf("Record was not deleted: %v", r)
+		}
+	}
+}
+
+func TestLightdbQueryStream(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+
+	ds1 := &registry.DataStream{Name: "test/stream1", Type: common.FLOAT}
+	ds2 := &registry.DataStream{Name: "test/stream2", Type: common.FLOAT}
+	const start = 1543059346.0
+	submitSeries(t, storage, ds1, start, 2, 1500)
+	submitSeries(t, storage, ds2, start, 3, 1500)
+
+	for _, sort := range []string{common.ASC, common.DESC} {
+		q := Query{From: time.Unix(0, 0), To: time.Now(), Sort: sort, Limit: -1}
+		records, errs := storage.QueryStream(context.Background(), q, ds1, ds2)
+		count := 0
+		var last *senml.Record
+		for r := range records {
+			if last != nil && ((sort == common.ASC && r.Time < last.Time) || (sort == common.DESC && r.Time > last.Time)) {
+				t.Fatalf("%s: record %v is out of order after %v", sort, r, *last)
+			}
+			r := r
+			last = &r
+			count++
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		if count != 3000 {
+			t.Fatalf("%s: expected 3000 records, got %d", sort, count)
+		}
+	}
+
+	// limit
+	q := Query{From: time.Unix(0, 0), To: time.Now(), Sort: common.ASC, Limit: 10}
+	records, errs := storage.QueryStream(context.Background(), q, ds1, ds2)
+	count := 0
+	for range records {
+		count++
+	}
+	if err := <-errs; err != nil || count != 10 {
+		t.Fatalf("Expected 10 records, got %d, %v", count, err)
+	}
+
+	// cancellation stops the query
+	ctx, cancel := context.WithCancel(context.Background())
+	q.Limit = -1
+	records, errs = storage.QueryStream(ctx, q, ds1, ds2)
+	<-records
+	cancel()
+	for range records {
+	}
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("Expected the query to be cancelled, got %v", err)
+	}
+}
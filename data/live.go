@@ -0,0 +1,302 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"github.com/farshidtz/senml"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// liveBufferSize is the number of packs which are buffered for a live subscriber before it is dropped
+	liveBufferSize = 100
+	// liveKeepAlive is the interval of keep-alive messages on idle Server-Sent Events connections
+	liveKeepAlive = 30 * time.Second
+)
+
+// Subscriber is implemented by storages which notify about the stored data
+type Subscriber interface {
+	// Subscribe returns a channel which receives the records of the given data streams after they are stored
+	// The channel is closed when unsubscribing, or when the subscriber does not keep up with the data
+	Subscribe(names []string) (packs <-chan senml.Pack, unsubscribe func())
+}
+
+// LiveStorage is a data storage which notifies live subscribers of the submitted data
+// It wraps a storage backend, so that all data submitted over HTTP and MQTT reaches the subscribers
+type LiveStorage struct {
+	Storage
+	mutex       sync.RWMutex
+	subscribers map[*liveSubscriber]bool
+}
+
+type liveSubscriber struct {
+	names map[string]bool
+	packs chan senml.Pack
+}
+
+// NewLiveStorage returns a storage which notifies live subscribers of the data submitted to the given storage
+func NewLiveStorage(storage Storage) *LiveStorage {
+	return &LiveStorage{
+		Storage:     storage,
+		subscribers: make(map[*liveSubscriber]bool),
+	}
+}
+
+// Submit stores the data and then sends it to the subscribers of the data streams
+func (s *LiveStorage) Submit(data map[string]senml.Pack, sources map[string]*registry.DataStream) (Duplicates, error) {
+	duplicates, err := s.Storage.Submit(data, sources)
+	if err != nil {
+		return duplicates, err
+	}
+	s.publish(data)
+	return duplicates, nil
+}
+
+// SubmitPartial stores the data and then sends the stored records to the subscribers of the data streams
+func (s *LiveStorage) SubmitPartial(data map[string]senml.Pack, sources map[string]*registry.DataStream) (Duplicates, error) {
+	duplicates, err := s.Storage.SubmitPartial(data, sources)
+	if err != nil {
+		return duplicates, err
+	}
+	s.publish(storedRecords(data, sources, duplicates))
+	return duplicates, nil
+}
+
+// Enqueue queues the data for storage, if the storage supports it, and sends it to the subscribers once stored
+// Otherwise, it stores the data right away.
+func (s *LiveStorage) Enqueue(data map[string]senml.Pack, sources map[string]*registry.DataStream, done func(Duplicates, error)) error {
+	enqueuer, ok := s.Storage.(Enqueuer)
+	if !ok {
+		done(s.Submit(data, sources))
+		return nil
+	}
+	return enqueuer.Enqueue(data, sources, func(duplicates Duplicates, err error) {
+		if err == nil {
+			s.publish(storedRecords(data, sources, duplicates))
+		}
+		done(duplicates, err)
+	})
+}
+
+// storedRecords returns the submitted records without those which were left out as duplicates
+func storedRecords(data map[string]senml.Pack, sources map[string]*registry.DataStream, duplicates Duplicates) map[string]senml.Pack {
+	stored := make(map[string]senml.Pack, len(data))
+	for name, records := range data {
+		if ds := sources[name]; ds != nil && ds.Duplicates == common.DuplicatesReject {
+			records = duplicates.Without(name, records)
+		}
+		stored[name] = records
+	}
+	return stored
+}
+
+func (s *LiveStorage) publish(data map[string]senml.Pack) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for sub := range s.subscribers {
+		var pack senml.Pack
+		for name, records := range data {
+			if sub.names[name] {
+				pack = append(pack, records...)
+			}
+		}
+		if len(pack) == 0 {
+			continue
+		}
+		select {
+		case sub.packs <- pack:
+		default:
+			// never block the ingestion because of a slow client
+			log.Printf("Live: dropping a subscriber which does not keep up with the data")
+			delete(s.subscribers, sub)
+			close(sub.packs)
+		}
+	}
+}
+
+func (s *LiveStorage) Subscribe(names []string) (<-chan senml.Pack, func()) {
+	sub := &liveSubscriber{
+		names: make(map[string]bool),
+		packs: make(chan senml.Pack, liveBufferSize),
+	}
+	for _, name := range names {
+		sub.names[name] = true
+	}
+
+	s.mutex.Lock()
+	s.subscribers[sub] = true
+	s.mutex.Unlock()
+
+	return sub.packs, func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if s.subscribers[sub] {
+			delete(s.subscribers, sub)
+			close(sub.packs)
+		}
+	}
+}
+
+// Live is a handler for subscribing to the data which is stored from now on
+// The data is pushed as SenML packs over a WebSocket, if requested, or otherwise as Server-Sent Events
+// Expected parameters: id(s) and/or filter (e.g. meta.building/equals/B12). The filter is evaluated when subscribing.
+func (api *API) Live(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	params := mux.Vars(r)
+
+	subscriber, ok := api.storage.(Subscriber)
+	if !ok {
+		common.ErrorResponse(http.StatusNotImplemented, "Live data is not supported by the data storage", w)
+		return
+	}
+
+	var names []string
+	if params["id"] != "" {
+		for _, id := range strings.Split(params["id"], common.IDSeparator) {
+			ds, err := api.registry.Get(id)
+			if err != nil {
+				common.ErrorResponse(http.StatusNotFound,
+					fmt.Sprintf("Error retrieving data source %v from the registry: %v", id, err.Error()),
+					w)
+				return
+			}
+			names = append(names, ds.Name)
+		}
+	}
+	if filter := r.Form.Get(common.ParamFilter); filter != "" {
+		matched, err := api.filterStreams(filter)
+		if err != nil {
+			common.ErrorResponse(http.StatusBadRequest, err.Error(), w)
+			return
+		}
+		for _, ds := range matched {
+			names = append(names, ds.Name)
+		}
+	}
+	if len(names) == 0 {
+		common.ErrorResponse(http.StatusNotFound, "None of the specified data sources could be retrieved from the registry.", w)
+		return
+	}
+
+	packs, unsubscribe := subscriber.Subscribe(names)
+	defer unsubscribe()
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		websocket.Server{Handler: func(ws *websocket.Conn) {
+			liveWebSocket(ws, packs)
+		}}.ServeHTTP(w, r)
+		return
+	}
+	liveEvents(w, r, packs)
+}
+
+// Close ends all subscriptions, e.g. on shutdown
+func (s *LiveStorage) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for sub := range s.subscribers {
+		delete(s.subscribers, sub)
+		close(sub.packs)
+	}
+}
+
+// liveEvents pushes the packs as Server-Sent Events until the client goes away
+func liveEvents(w http.ResponseWriter, r *http.Request, packs <-chan senml.Pack) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		common.ErrorResponse(http.StatusInternalServerError, "Streaming is not supported by the connection", w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case pack, ok := <-packs:
+			if !ok {
+				return
+			}
+			b, err := json.Marshal(pack)
+			if err != nil {
+				log.Printf("Live: error marshalling pack: %s", err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "data: %s\n\n", b)
+			if err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// liveWebSocket sends the packs as JSON messages until the client goes away
+func liveWebSocket(ws *websocket.Conn, packs <-chan senml.Pack) {
+	// messages from the client are not expected, reading only detects the closing of the connection
+	closed := make(chan struct{})
+	go func() {
+		var discard []byte
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+		close(closed)
+	}()
+
+	for {
+		select {
+		case pack, ok := <-packs:
+			if !ok {
+				ws.Close()
+				return
+			}
+			err := websocket.JSON.Send(ws, pack)
+			if err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// filterStreams returns all data streams which match a registry filter in the form of <path>/<op>/<value>
+func (api *API) filterStreams(filter string) ([]registry.DataStream, error) {
+	parts := strings.SplitN(filter, "/", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Invalid filter argument: %s. Expected <path>/<op>/<value>", filter)
+	}
+	var matched []registry.DataStream
+	for page := 1; ; page++ {
+		streams, total, err := api.registry.Filter(parts[0], parts[1], parts[2], page, registry.MaxPerPage)
+		if err != nil {
+			return nil, fmt.Errorf("Error filtering the registry: %s", err)
+		}
+		matched = append(matched, streams...)
+		if page*registry.MaxPerPage >= total {
+			return matched, nil
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"github.com/farshidtz/senml"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/websocket"
+)
+
+func setupLiveAPI(t *testing.T) (*httptest.Server, *LiveStorage) {
+	storage := NewLiveStorage(&dummyDataStorage{})
+	regStorage := registry.NewMemoryStorage(common.RegConf{}, storage)
+	for _, ds := range []registry.DataStream{
+		{Name: "test/live1", Type: common.FLOAT, Meta: map[string]interface{}{"building": "B12"}},
+		{Name: "test/live2", Type: common.FLOAT},
+	} {
+		_, err := regStorage.Add(ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := NewAPI(regStorage, storage, false, nil)
+
+	r := mux.NewRouter().StrictSlash(true).SkipClean(true)
+	r.Methods("POST").Path("/data/{id:.+}").HandlerFunc(api.Submit)
+	// like in the service, the live api is matched before the data of the data streams
+	r.Methods("GET").Path("/data/live").HandlerFunc(api.Live)
+	r.Methods("GET").Path("/data/{id:.+}/live").HandlerFunc(api.Live)
+	r.Methods("GET").Path("/data/{id:.+}").HandlerFunc(api.Query)
+	return httptest.NewServer(r), storage
+}
+
+// waitForSubscribers waits until the live handlers have subscribed
+func waitForSubscribers(t *testing.T, storage *LiveStorage, n int) {
+	for i := 0; i < 100; i++ {
+		storage.mutex.RLock()
+		subscribed := len(storage.subscribers)
+		storage.mutex.RUnlock()
+		if subscribed == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d subscribers", n)
+}
+
+func submitLive(t *testing.T, url string, name string, v float64) {
+	b, _ := json.Marshal(senml.Pack{{Name: name, Value: &v}})
+	res, err := http.Post(url+"/data/"+name, senml.MediaTypeSenmlJSON, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("Server response is not %v but %v", http.StatusAccepted, res.StatusCode)
+	}
+}
+
+func TestHttpLiveEvents(t *testing.T) {
+	ts, storage := setupLiveAPI(t)
+	defer ts.Close()
+
+	// subscribe to the streams of building B12
+	res, err := http.Get(ts.URL + "/data/live?filter=meta.building/equals/B12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected response: %v %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	waitForSubscribers(t, storage, 1)
+
+	submitLive(t, ts.URL, "test/live2", 1) // not subscribed
+	submitLive(t, ts.URL, "test/live1", 2)
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var pack senml.Pack
+		err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &pack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pack) != 1 || pack[0].Name != "test/live1" || *pack[0].Value != 2 {
+			t.Fatalf("Unexpected event: %v", pack)
+		}
+		return
+	}
+	t.Fatalf("No event received: %v", scanner.Err())
+}
+
+func TestHttpLiveWebSocket(t *testing.T) {
+	ts, storage := setupLiveAPI(t)
+	defer ts.Close()
+
+	ws, err := websocket.Dial(strings.Replace(ts.URL, "http", "ws", 1)+"/data/test/live1,test/live2/live", "", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	waitForSubscribers(t, storage, 1)
+
+	submitLive(t, ts.URL, "test/live2", 3)
+
+	var pack senml.Pack
+	err = websocket.JSON.Receive(ws, &pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pack) != 1 || pack[0].Name != "test/live2" || *pack[0].Value != 3 {
+		t.Fatalf("Unexpected message: %v", pack)
+	}
+
+	ws.Close()
+	waitForSubscribers(t, storage, 0)
+}
+
+func TestLiveStorageSlowSubscriber(t *testing.T) {
+	storage := NewLiveStorage(&dummyDataStorage{})
+	packs, unsubscribe := storage.Subscribe([]string{"test/live"})
+	defer unsubscribe()
+
+	v := 1.0
+	data := map[string]senml.Pack{"test/live": {{Name: "test/live", Value: &v}}}
+	for i := 0; i <= liveBufferSize; i++ {
+		_, err := storage.Submit(data, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the buffered packs are delivered and then the channel is closed
+	count := 0
+	for range packs {
+		count++
+	}
+	if count != liveBufferSize {
+		t.Fatalf("Expected %d packs, got %d", liveBufferSize, count)
+	}
+}
+
+func TestLiveEventsClose(t *testing.T) {
+	ts, storage := setupLiveAPI(t)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/data/test/live1/live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	waitForSubscribers(t, storage, 1)
+
+	// closing the storage on shutdown ends the response
+	storage.Close()
+	done := make(chan error)
+	go func() {
+		_, err := ioutil.ReadAll(res.Body)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The live events did not end on close")
+	}
+}
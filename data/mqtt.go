@@ -27,10 +27,12 @@ type MQTTConnector struct {
 	storage  Storage
 	clientID string
 	managers map[string]*Manager
-	// cache of resource->ds
-	cache map[string]*registry.DataStream
 	// failed mqtt registrations
 	failedRegistrations map[string]*registry.MQTTSource
+	// quarantine of the rejected payloads (optional)
+	quarantine *Quarantine
+	// no more subscriptions are retried once stopped
+	stopped bool
 }
 
 type Manager struct {
@@ -46,16 +48,19 @@ type Subscription struct {
 	url       string
 	topic     string
 	qos       byte
+	format    senml.Format
 	receivers int
 }
 
-func NewMQTTConnector(storage Storage, clientID string) (*MQTTConnector, error) {
+// NewMQTTConnector returns a connector which stores the data of the MQTT data sources
+// The rejected payloads are kept in the quarantine, unless it is nil
+func NewMQTTConnector(storage Storage, clientID string, quarantine *Quarantine) (*MQTTConnector, error) {
 	c := &MQTTConnector{
 		storage:             storage,
 		clientID:            clientID,
 		managers:            make(map[string]*Manager),
-		cache:               make(map[string]*registry.DataStream),
 		failedRegistrations: make(map[string]*registry.MQTTSource),
+		quarantine:          quarantine,
 	}
 	return c, nil
 }
@@ -90,14 +95,14 @@ func (c *MQTTConnector) Start(reg registry.Storage) error {
 	return nil
 }
 
-func (c *MQTTConnector) flushCache() {
-	c.cache = make(map[string]*registry.DataStream)
-}
-
 func (c *MQTTConnector) retryRegistrations() {
 	for {
 		time.Sleep(mqttRetryInterval * time.Second)
 		c.Lock()
+		if c.stopped {
+			c.Unlock()
+			return
+		}
 		for id, mqttSource := range c.failedRegistrations {
 			err := c.register(*mqttSource)
 			if err != nil {
@@ -110,7 +115,24 @@ func (c *MQTTConnector) retryRegistrations() {
 	}
 }
 
+// Stop disconnects from all brokers, so that no more data is received, e.g. before the data storage is closed
+func (c *MQTTConnector) Stop() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.stopped = true
+	for url, manager := range c.managers {
+		manager.client.Disconnect(250)
+		delete(c.managers, url)
+		log.Printf("MQTT: %s: Disconnected!", url)
+	}
+}
+
 func (c *MQTTConnector) register(source registry.MQTTSource) error {
+	format, ok := common.SenMLFormat(source.Format)
+	if !ok {
+		return fmt.Errorf("MQTT: Unsupported format: %s", source.Format)
+	}
 
 	if _, exists := c.managers[source.BrokerURL]; !exists { // NO CLIENT FOR THIS BROKER
 		manager := &Manager{
@@ -123,6 +145,7 @@ func (c *MQTTConnector) register(source registry.MQTTSource) error {
 			url:       source.BrokerURL,
 			topic:     source.Topic,
 			qos:       source.QoS,
+			format:    format,
 			receivers: 1,
 		}
 
@@ -154,6 +177,7 @@ func (c *MQTTConnector) register(source registry.MQTTSource) error {
 				url:       source.BrokerURL,
 				topic:     source.Topic,
 				qos:       source.QoS,
+				format:    format,
 				receivers: 1,
 			}
 			// Subscribe
@@ -165,6 +189,9 @@ func (c *MQTTConnector) register(source registry.MQTTSource) error {
 
 		} else { // There is a subscription for this topic
 			//log.Printf("MQTT: %s: Already subscribed to %s", mqttConf.BrokerURL, mqttConf.Topic)
+			if manager.subscriptions[source.Topic].format != format {
+				return fmt.Errorf("MQTT: Topic %s is already subscribed with a different format", source.Topic)
+			}
 			manager.subscriptions[source.Topic].receivers++
 		}
 	}
@@ -223,9 +250,21 @@ func (s *Subscription) onMessage(client paho.Client, msg paho.Message) {
 
 	//log.Printf("MQTT: %s %s", msg.Topic(), msg.Payload())
 
-	senmlPack, err := senml.Decode(msg.Payload(), senml.JSON)
+	// the rejected payload or records are quarantined
+	origin := QuarantineEntry{Source: QuarantineMQTT, Broker: s.url, Topic: msg.Topic()}
+	quarantine := func(reason string, records ...senml.Record) {
+		entry := origin
+		entry.Reason, entry.Records = reason, records
+		if len(records) == 0 {
+			entry.Payload, entry.ContentType = msg.Payload(), common.SenMLMediaType(s.format)
+		}
+		s.connector.quarantine.Add(entry)
+	}
+
+	senmlPack, err := senml.Decode(msg.Payload(), s.format)
 	if err != nil {
-		logMQTTError(http.StatusBadRequest, "Error parsing json: %s : %v", msg.Payload(), err)
+		logMQTTError(http.StatusBadRequest, "Error parsing SenML: %s : %v", msg.Payload(), err)
+		quarantine("Error parsing SenML: " + err.Error())
 		return
 	}
 
@@ -233,21 +272,26 @@ func (s *Subscription) onMessage(client paho.Client, msg paho.Message) {
 	records := senmlPack.Normalize()
 	data := make(map[string]senml.Pack)
 	sources := make(map[string]*registry.DataStream)
+	var reasons []string
+	rejected := make(map[string]senml.Pack)
+	reject := func(r senml.Record, reason string) {
+		if _, found := rejected[reason]; !found {
+			reasons = append(reasons, reason)
+		}
+		rejected[reason] = append(rejected[reason], r)
+	}
 	for _, r := range records {
-		// Find the data source for this entry
-		ds, exists := s.connector.cache[r.Name]
-		if !exists {
-			ds, err = s.connector.registry.Get(r.Name)
-			if err != nil {
-				if registry.ErrType(err, registry.ErrNotFound) {
-					logMQTTError(http.StatusNotFound, "Warning: Resource not found: %v", r.Name)
-					continue
-				}
-				logMQTTError(http.StatusInternalServerError, "Error finding resource: %v", r.Name)
+		// Find the data source for this entry (cached by the registry)
+		ds, err := s.connector.registry.Get(r.Name)
+		if err != nil {
+			if registry.ErrType(err, registry.ErrNotFound) {
+				logMQTTError(http.StatusNotFound, "Warning: Resource not found: %v", r.Name)
+				reject(r, fmt.Sprintf("Resource not found: %v", r.Name))
 				continue
 			}
-
-			s.connector.cache[r.Name] = ds
+			logMQTTError(http.StatusInternalServerError, "Error finding resource: %v", r.Name)
+			reject(r, fmt.Sprintf("Error finding resource: %v: %v", r.Name, err))
+			continue
 		}
 
 		// Check if the message is wanted
@@ -283,6 +327,7 @@ func (s *Subscription) onMessage(client paho.Client, msg paho.Message) {
 		if typeError {
 			logMQTTError(http.StatusBadRequest,
 				"Value for %v is empty or has a type other than what is set in registry: %v", r.Name, ds.Type)
+			reject(r, fmt.Sprintf("Value for %v is empty or has a type other than what is set in registry: %v", r.Name, ds.Type))
 			continue
 		}
 
@@ -293,17 +338,49 @@ func (s *Subscription) onMessage(client paho.Client, msg paho.Message) {
 		}
 		data[ds.Name] = append(data[ds.Name], r)
 	}
+	for _, reason := range reasons {
+		quarantine(reason, rejected[reason]...)
+	}
 
-	if len(data) > 0 {
-		// Add data to the storage
-		err = s.connector.storage.Submit(data, sources)
+	if len(data) == 0 {
+		return
+	}
+	// Add data to the storage
+	stored := func(duplicates Duplicates, err error) {
 		if err != nil {
-			logMQTTError(http.StatusInternalServerError, "Error writing data to the database: %v", err)
+			// the submission is atomic, so none of its records are stored
+			reason := "Error writing data to the database: " + err.Error()
+			if _, ok := err.(*DuplicateError); ok {
+				logMQTTError(http.StatusConflict, "Error writing data to the database: %v", err)
+				reason = err.Error()
+			} else if err == ErrQueueFull || err == ErrQueueClosed {
+				logMQTTError(http.StatusServiceUnavailable, "Error writing data to the database: %v", err)
+			} else {
+				logMQTTError(http.StatusInternalServerError, "Error writing data to the database: %v", err)
+			}
+			var records senml.Pack
+			for _, dps := range data {
+				records = append(records, dps...)
+			}
+			quarantine(reason, records...)
 			return
 		}
+		for name, indices := range duplicates {
+			log.Printf("%s %d record(s) of %s at the time of another record (%s)", logHeader, len(indices), name, policyOf(sources[name]))
+		}
 
 		log.Printf("%s %d %v\n", logHeader, http.StatusAccepted, time.Now().Sub(t1))
 	}
+	if enqueuer, ok := s.connector.storage.(Enqueuer); ok {
+		// while the queue is full, this holds back the delivery of further messages for a bounded time, after which
+		// the data is quarantined
+		err = enqueuer.Enqueue(data, sources, stored)
+		if err != nil {
+			stored(nil, err)
+		}
+		return
+	}
+	stored(s.connector.storage.Submit(data, sources))
 }
 
 // NOTIFICATION HANDLERS
@@ -328,10 +405,6 @@ func (c *MQTTConnector) UpdateHandler(oldDS registry.DataStream, newDS registry.
 	c.Lock()
 	defer c.Unlock()
 
-	if oldDS.Retention != newDS.Retention {
-		c.flushCache()
-	}
-
 	if oldDS.Source.MQTTSource != newDS.Source.MQTTSource {
 		// Remove old subscription
 		if oldDS.Source.MQTTSource != nil {
@@ -357,11 +430,8 @@ func (c *MQTTConnector) DeleteHandler(oldDS registry.DataStream) error {
 	c.Lock()
 	defer c.Unlock()
 
-	c.flushCache()
-
 	// Remove old subscription
 	if oldDS.Source.MQTTSource != nil {
-		delete(c.cache, oldDS.Name)
 		err := c.unregister(oldDS.Source.MQTTSource)
 		if err != nil {
 			return fmt.Errorf("MQTT: Error removing subscription: %v", err)
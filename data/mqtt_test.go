@@ -0,0 +1,111 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"github.com/farshidtz/senml"
+)
+
+// testMessage is an MQTT message delivered to a subscription
+type testMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m testMessage) Duplicate() bool   { return false }
+func (m testMessage) Qos() byte         { return 0 }
+func (m testMessage) Retained() bool    { return false }
+func (m testMessage) Topic() string     { return m.topic }
+func (m testMessage) MessageID() uint16 { return 0 }
+func (m testMessage) Payload() []byte   { return m.payload }
+
+// setupSubscription returns a subscription of a registered data stream, which receives the messages without a broker
+func setupSubscription(t *testing.T, storage Storage, ds registry.DataStream) (*Subscription, *Quarantine) {
+	regStorage := registry.NewMemoryStorage(common.RegConf{})
+	_, err := regStorage.Add(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quarantine := NewQuarantine(0)
+	connector, err := NewMQTTConnector(storage, "test", quarantine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connector.registry = regStorage
+	return &Subscription{
+		connector: connector,
+		url:       ds.Source.MQTTSource.BrokerURL,
+		topic:     ds.Source.MQTTSource.Topic,
+		format:    senml.JSON,
+	}, quarantine
+}
+
+func TestMQTTQuarantineDuplicates(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+	ds := registry.DataStream{
+		Name:       "test/mqtt/reject",
+		Type:       common.FLOAT,
+		Duplicates: common.DuplicatesReject,
+		Source: registry.Source{
+			SrcType:    registry.MqttType,
+			MQTTSource: &registry.MQTTSource{BrokerURL: "tcp://localhost:1883", Topic: "test/mqtt"},
+		},
+	}
+	subscription, quarantine := setupSubscription(t, storage, ds)
+
+	v := 1.0
+	payload, _ := json.Marshal(senml.Pack{{Name: ds.Name, Time: 1543059346, Value: &v}})
+	for i := 0; i < 2; i++ {
+		subscription.onMessage(nil, testMessage{topic: ds.Source.MQTTSource.Topic, payload: payload})
+	}
+
+	// the second message is rejected and quarantined with its records
+	entries, total := quarantine.List(1, 10)
+	if total != 1 {
+		t.Fatalf("Expected 1 quarantined message, got %d: %+v", total, entries)
+	}
+	if len(entries[0].Records) != 1 || entries[0].Records[0].Name != ds.Name || entries[0].Topic != ds.Source.MQTTSource.Topic {
+		t.Errorf("Unexpected quarantined message: %+v", entries[0])
+	}
+	if expected := (&DuplicateError{Name: ds.Name, Times: []float64{1543059346}}).Error(); entries[0].Reason != expected {
+		t.Errorf("Expected the reason %q, got %q", expected, entries[0].Reason)
+	}
+}
+
+func TestMQTTQuarantineQueueFull(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+	queue, err := NewWriteQueue(storage, common.QueueConf{Capacity: 1, FlushInterval: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+	queue.enqueueWait = 10 * time.Millisecond
+	ds := registry.DataStream{
+		Name: "test/mqtt/full",
+		Type: common.FLOAT,
+		Source: registry.Source{
+			SrcType:    registry.MqttType,
+			MQTTSource: &registry.MQTTSource{BrokerURL: "tcp://localhost:1883", Topic: "test/mqtt"},
+		},
+	}
+	subscription, quarantine := setupSubscription(t, queue, ds)
+
+	// the first message fills the queue until the next flush, the second one is quarantined after a while
+	for i := 0; i < 2; i++ {
+		v := float64(i)
+		payload, _ := json.Marshal(senml.Pack{{Name: ds.Name, Time: 1543059346 + float64(i), Value: &v}})
+		subscription.onMessage(nil, testMessage{topic: ds.Source.MQTTSource.Topic, payload: payload})
+	}
+	entries, total := quarantine.List(1, 10)
+	if total != 1 || len(entries[0].Records) != 1 || entries[0].Records[0].Time != 1543059347 {
+		t.Fatalf("Expected the second message to be quarantined, got %d: %+v", total, entries)
+	}
+}
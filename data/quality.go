@@ -0,0 +1,345 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	datastore "github.com/dschowta/senml.datastore"
+	"github.com/farshidtz/senml"
+	"github.com/gorilla/mux"
+)
+
+// MaxQualityIssues is the maximum number of listed issues of each kind in a data quality report. All issues are counted.
+const MaxQualityIssues = 1000
+
+// MaxQualityDays is the maximum number of days of the time range of a data quality report, which has the completeness
+// of each day
+const MaxQualityDays = 366
+
+const (
+	// EventDuplicate is the ingestion event of a record submitted at the time of another record
+	EventDuplicate = "duplicate"
+	// EventOutOfOrder is the ingestion event of a record submitted after a later record of the data stream
+	EventOutOfOrder = "outOfOrder"
+)
+
+const secondsPerDay = 24 * 60 * 60
+
+// IngestionEvent is a record of a data stream which was not submitted in time order
+type IngestionEvent struct {
+	Kind string `json:"kind"`
+	// Time is the time of the record and Arrival the time of its submission (SenML times)
+	Time    float64 `json:"time"`
+	Arrival float64 `json:"arrival"`
+}
+
+// qualitySeries returns the name of the series of the ingestion events of a data stream
+func qualitySeries(name string) string {
+	return "_quality/" + name
+}
+
+// QualitySet describes the data quality reports returned by the Data API
+type QualitySet struct {
+	// SelfLink is the SelfLink of the returned reports in the Data API
+	SelfLink string `json:"selfLink"`
+	// From and To are the time range of the reports (SenML times)
+	From float64 `json:"from"`
+	To   float64 `json:"to"`
+	// Reports are the reports of the data streams in the order of the query
+	Reports []QualityReport `json:"reports"`
+	// Time is the time of query in seconds
+	TimeTook float64 `json:"took"`
+}
+
+// QualityReport is the data quality of a data stream within a time range
+// Each list of issues is limited to MaxQualityIssues, the counts include all issues.
+type QualityReport struct {
+	Name string `json:"name"`
+	// Interval is the expected interval between records, if declared, and GapThreshold the minimum duration of a gap
+	Interval     string `json:"interval,omitempty"`
+	GapThreshold string `json:"gapThreshold"`
+	// Records is the number of records within the time range
+	Records int `json:"records"`
+	// Gaps are the periods without records longer than the threshold, including those at the start and the end of the time range
+	Gaps     []Gap `json:"gaps"`
+	GapCount int   `json:"gapCount"`
+	// Duplicates are the records submitted at the time of another record
+	Duplicates     []IngestionEvent `json:"duplicates"`
+	DuplicateCount int              `json:"duplicateCount"`
+	// OutOfOrder are the records submitted after a later record
+	OutOfOrder      []IngestionEvent `json:"outOfOrder"`
+	OutOfOrderCount int              `json:"outOfOrderCount"`
+	// RangeViolations are the records with values outside of the expected range
+	RangeViolations     []RangeViolation `json:"rangeViolations"`
+	RangeViolationCount int              `json:"rangeViolationCount"`
+	// Completeness is the percentage of the expected records per day (UTC), given an expected interval
+	Completeness []DailyCompleteness `json:"completeness,omitempty"`
+}
+
+// Gap is a period without records (SenML times)
+type Gap struct {
+	From     float64 `json:"from"`
+	To       float64 `json:"to"`
+	Duration string  `json:"duration"`
+}
+
+// RangeViolation is a record with a value outside of the expected range
+type RangeViolation struct {
+	Time  float64 `json:"time"`
+	Value float64 `json:"value"`
+}
+
+// DailyCompleteness is the number of distinct record times of a day, compared to the number of expected records
+type DailyCompleteness struct {
+	Day      string  `json:"day"`
+	Records  int     `json:"records"`
+	Expected int     `json:"expected"`
+	Percent  float64 `json:"percent"`
+}
+
+// qualityAnalyzer computes the data quality report of a data stream from its records, which are added in ascending order
+type qualityAnalyzer struct {
+	report    QualityReport
+	ds        *registry.DataStream
+	from, end float64 // SenML times
+	threshold float64 // seconds
+	interval  float64 // seconds, zero if not declared
+	last      float64 // time of the last record
+	days      []int   // distinct record times per day since the day of from
+}
+
+// newQualityAnalyzer returns the analyzer of a data stream within the given time range
+// The gap threshold defaults to the expected interval of the data stream.
+func newQualityAnalyzer(ds *registry.DataStream, from, end time.Time, threshold time.Duration) (*qualityAnalyzer, error) {
+	a := &qualityAnalyzer{
+		report: QualityReport{
+			Name:            ds.Name,
+			Gaps:            []Gap{},
+			Duplicates:      []IngestionEvent{},
+			OutOfOrder:      []IngestionEvent{},
+			RangeViolations: []RangeViolation{},
+		},
+		ds:   ds,
+		from: datastore.ToSenmlTime(from),
+		end:  datastore.ToSenmlTime(end),
+	}
+	if ds.Expected != nil && ds.Expected.Interval != "" {
+		interval, err := common.ParseDuration(ds.Expected.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid expected interval of data stream %s: %s", ds.Name, err)
+		}
+		a.interval = interval.Seconds()
+		a.report.Interval = interval.String()
+		if threshold == 0 {
+			threshold = interval
+		}
+		days := int(math.Floor(a.end/secondsPerDay) - math.Floor(a.from/secondsPerDay) + 1)
+		a.days = make([]int, days)
+	}
+	if threshold == 0 {
+		return nil, fmt.Errorf("The %s argument is required for data stream %s, which does not declare an expected interval",
+			common.ParamGap, ds.Name)
+	}
+	a.threshold = threshold.Seconds()
+	a.report.GapThreshold = threshold.String()
+	return a, nil
+}
+
+func (a *qualityAnalyzer) add(r senml.Record) {
+	a.report.Records++
+	if a.report.Records == 1 {
+		a.gap(a.from, r.Time)
+		a.count(r.Time)
+	} else if r.Time != a.last {
+		a.gap(a.last, r.Time)
+		a.count(r.Time)
+	}
+	a.last = r.Time
+
+	if a.ds.Expected != nil && r.Value != nil &&
+		(a.ds.Expected.Min != nil && *r.Value < *a.ds.Expected.Min || a.ds.Expected.Max != nil && *r.Value > *a.ds.Expected.Max) {
+		a.report.RangeViolationCount++
+		if len(a.report.RangeViolations) < MaxQualityIssues {
+			a.report.RangeViolations = append(a.report.RangeViolations, RangeViolation{Time: r.Time, Value: *r.Value})
+		}
+	}
+}
+
+// gap reports the period between the given times if it is longer than the threshold
+func (a *qualityAnalyzer) gap(from, to float64) {
+	// tolerated to the microsecond, in spite of the float times
+	if to-from <= a.threshold+1e-6 {
+		return
+	}
+	a.report.GapCount++
+	if len(a.report.Gaps) < MaxQualityIssues {
+		duration := time.Duration(math.Round((to - from) * 1e9))
+		a.report.Gaps = append(a.report.Gaps, Gap{From: from, To: to, Duration: duration.String()})
+	}
+}
+
+// count counts a distinct record time for the completeness of its day
+func (a *qualityAnalyzer) count(t float64) {
+	if a.days == nil {
+		return
+	}
+	day := int(math.Floor(t/secondsPerDay) - math.Floor(a.from/secondsPerDay))
+	if day >= 0 && day < len(a.days) {
+		a.days[day]++
+	}
+}
+
+func (a *qualityAnalyzer) addEvent(e IngestionEvent) {
+	switch e.Kind {
+	case EventDuplicate:
+		a.report.DuplicateCount++
+		if len(a.report.Duplicates) < MaxQualityIssues {
+			a.report.Duplicates = append(a.report.Duplicates, e)
+		}
+	case EventOutOfOrder:
+		a.report.OutOfOrderCount++
+		if len(a.report.OutOfOrder) < MaxQualityIssues {
+			a.report.OutOfOrder = append(a.report.OutOfOrder, e)
+		}
+	}
+}
+
+// finish reports the gap at the end of the time range and the completeness per day
+func (a *qualityAnalyzer) finish() QualityReport {
+	if a.report.Records == 0 {
+		a.gap(a.from, a.end)
+	} else {
+		a.gap(a.last, a.end)
+	}
+
+	firstDay := math.Floor(a.from/secondsPerDay) * secondsPerDay
+	for i, records := range a.days {
+		start := math.Max(a.from, firstDay+float64(i)*secondsPerDay)
+		end := math.Min(a.end, firstDay+float64(i+1)*secondsPerDay)
+		if end <= start {
+			continue
+		}
+		expected := int(math.Ceil((end-start)/a.interval - 1e-6))
+		percent := math.Min(100, math.Round(float64(records)/float64(expected)*10000)/100)
+		a.report.Completeness = append(a.report.Completeness, DailyCompleteness{
+			Day:      datastore.FromSenmlTime(start).UTC().Format("2006-01-02"),
+			Records:  records,
+			Expected: expected,
+			Percent:  percent,
+		})
+	}
+	return a.report
+}
+
+// Quality is a handler for the data quality report of data streams within a time range
+// The report lists the gaps, the duplicate and out-of-order records, the values outside of the expected range and
+// the completeness per day, as far as the expectations are declared by the data streams.
+// Expected parameters: id(s), from, optional: to, gap (the minimum duration of a gap, e.g. 15m, which defaults to the
+// expected interval of a data stream). The time range is limited to MaxQualityDays.
+func (api *API) Quality(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	timeStart := time.Now()
+	params := mux.Vars(r)
+
+	ids := splitIDs(params["id"])
+	sources, code, err := api.querySources(ids, "")
+	if err != nil {
+		common.ErrorResponse(code, err.Error(), w)
+		return
+	}
+
+	if r.Form.Get(common.ParamFrom) == "" {
+		common.ErrorResponse(http.StatusBadRequest,
+			fmt.Sprintf("The %s argument is required for the data quality report", common.ParamFrom), w)
+		return
+	}
+	q, err := ParseQueryParameters(r.Form)
+	if err != nil {
+		common.ErrorResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+	// records are not expected in the future
+	now := time.Now().UTC()
+	if !q.From.Before(now) {
+		common.ErrorResponse(http.StatusBadRequest,
+			fmt.Sprintf("The %s argument of the data quality report must be in the past", common.ParamFrom), w)
+		return
+	}
+	if q.To.After(now) {
+		q.To = now
+	}
+	if q.To.Sub(q.From) > MaxQualityDays*24*time.Hour {
+		common.ErrorResponse(http.StatusBadRequest,
+			fmt.Sprintf("The time range of the data quality report must not be longer than %d days", MaxQualityDays), w)
+		return
+	}
+	selfLink := fmt.Sprintf("%s/%s%s?%s=%s&%s=%s", common.DataAPILoc, strings.Join(ids, common.IDSeparator), common.QualityAPILoc,
+		common.ParamFrom, q.From.UTC().Format(time.RFC3339Nano), common.ParamTo, q.To.UTC().Format(time.RFC3339Nano))
+
+	var threshold time.Duration
+	if gap := r.Form.Get(common.ParamGap); gap != "" {
+		threshold, err = common.ParseDuration(gap)
+		if err != nil {
+			common.ErrorResponse(http.StatusBadRequest, fmt.Sprintf("Error parsing %s argument: %s", common.ParamGap, err), w)
+			return
+		}
+		selfLink += fmt.Sprintf("&%s=%s", common.ParamGap, threshold)
+	}
+	analyzers := make(map[string]*qualityAnalyzer, len(sources))
+	for _, ds := range sources {
+		analyzers[ds.Name], err = newQualityAnalyzer(ds, q.From, q.To, threshold)
+		if err != nil {
+			common.ErrorResponse(http.StatusBadRequest, err.Error(), w)
+			return
+		}
+	}
+
+	records, errs := api.storage.QueryStream(r.Context(),
+		Query{From: q.From, To: q.To, Sort: common.ASC, Limit: -1, PerPage: MaxPerPage}, sources...)
+	for record := range records {
+		if a, found := analyzers[record.Name]; found {
+			a.add(record)
+		}
+	}
+	if err := <-errs; err != nil {
+		common.ErrorResponse(http.StatusInternalServerError, "Error retrieving data from the database: "+err.Error(), w)
+		return
+	}
+
+	qualitySet := QualitySet{
+		SelfLink: selfLink,
+		From:     datastore.ToSenmlTime(q.From),
+		To:       datastore.ToSenmlTime(q.To),
+		Reports:  make([]QualityReport, 0, len(sources)),
+	}
+	for _, ds := range sources {
+		events, err := api.storage.IngestionEvents(ds, q.From, q.To)
+		if err != nil {
+			common.ErrorResponse(http.StatusInternalServerError, "Error retrieving ingestion events from the database: "+err.Error(), w)
+			return
+		}
+		a := analyzers[ds.Name]
+		for _, e := range events {
+			a.addEvent(e)
+		}
+		qualitySet.Reports = append(qualitySet.Reports, a.finish())
+	}
+	qualitySet.TimeTook = time.Since(timeStart).Seconds()
+
+	b, err := json.Marshal(qualitySet)
+	if err != nil {
+		common.ErrorResponse(http.StatusInternalServerError, "Error marshalling quality report: "+err.Error(), w)
+		return
+	}
+	w.Header().Add("Content-Type", common.DefaultMIMEType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
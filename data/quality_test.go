@@ -0,0 +1,191 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	datastore "github.com/dschowta/senml.datastore"
+	"github.com/farshidtz/senml"
+	"github.com/gorilla/mux"
+)
+
+// submitRecord stores a float record for the given stream
+func submitRecord(t *testing.T, storage Storage, ds *registry.DataStream, time, value float64) {
+	_, err := storage.Submit(map[string]senml.Pack{ds.Name: {{Name: ds.Name, Time: time, Value: &value}}},
+		map[string]*registry.DataStream{ds.Name: ds})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLightdbIngestionEvents(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+
+	ds := &registry.DataStream{Name: "test/quality/events", Type: common.FLOAT}
+	const start = 1543059346.0
+	submitSeries(t, storage, ds, start, 10, 5)
+	// a later submission of an earlier record, a duplicate and records out of order within a submission
+	submitRecord(t, storage, ds, start+15, 1)
+	submitRecord(t, storage, ds, start+20, 2)
+	submitSeries(t, storage, ds, start+100, -50, 2)
+
+	from, to := datastore.FromSenmlTime(start), datastore.FromSenmlTime(start+100)
+	events, err := storage.IngestionEvents(ds, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	var times []float64
+	for _, e := range events {
+		kinds, times = append(kinds, e.Kind), append(times, e.Time)
+		if e.Arrival < start {
+			t.Errorf("Unexpected arrival time of %v", e)
+		}
+	}
+	if expected := []string{EventOutOfOrder, EventDuplicate, EventOutOfOrder}; !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("Expected the events %v, got %v", expected, kinds)
+	}
+	if expected := []float64{start + 15, start + 20, start + 50}; !reflect.DeepEqual(times, expected) {
+		t.Errorf("Expected the events at %v, got %v", expected, times)
+	}
+
+	// the events of deleted records are deleted
+	_, err = storage.Delete(ds, from, datastore.FromSenmlTime(start+30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err = storage.IngestionEvents(ds, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Errorf("Expected the event of the remaining record, got %v", events)
+	}
+
+	// data streams without events
+	events, err = storage.IngestionEvents(&registry.DataStream{Name: "test/quality/none"}, from, to)
+	if err != nil || len(events) != 0 {
+		t.Errorf("Expected no events, got %v: %v", events, err)
+	}
+}
+
+func TestHttpQuality(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+	regStorage := registry.NewMemoryStorage(common.RegConf{}, storage)
+	min, max := 0.0, 100.0
+	series := &registry.DataStream{Name: "test/quality/float", Type: common.FLOAT,
+		Expected: &registry.Expectation{Interval: "10s", Min: &min, Max: &max}}
+	undeclared := &registry.DataStream{Name: "test/quality/string", Type: common.STRING}
+	for _, ds := range []*registry.DataStream{series, undeclared} {
+		_, err := regStorage.Add(*ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// records every 10 seconds, except at 40 and 50, with a value above the range at 70
+	const start = 1543059346.0
+	for i := 0; i < 10; i++ {
+		if i == 4 || i == 5 {
+			continue
+		}
+		v := float64(i)
+		if i == 7 {
+			v = 150
+		}
+		submitRecord(t, storage, series, start+float64(i)*10, v)
+	}
+	submitRecord(t, storage, series, start+40, 4)
+	submitRecord(t, storage, series, start, 0)
+
+	api := NewAPI(regStorage, storage, false, nil)
+	r := mux.NewRouter().StrictSlash(true).SkipClean(true)
+	r.Methods("GET").Path("/data/{id:.+}/quality").HandlerFunc(api.Quality)
+	r.Methods("GET").Path("/data/{id:.+}").HandlerFunc(api.Query)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	from := time.Unix(int64(start), 0).UTC().Format(time.RFC3339)
+	to := time.Unix(int64(start)+90, 0).UTC().Format(time.RFC3339)
+	res, err := http.Get(ts.URL + "/data/" + series.Name + "/quality?from=" + from + "&to=" + to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var qualitySet QualitySet
+	err = json.NewDecoder(res.Body).Decode(&qualitySet)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qualitySet.Reports) != 1 {
+		t.Fatalf("Expected a single report, got %v", qualitySet)
+	}
+	report := qualitySet.Reports[0]
+	if report.Records != 9 || report.GapThreshold != "10s" {
+		t.Errorf("Expected 9 records and a gap threshold of 10s, got %v", report)
+	}
+	if expected := []Gap{{From: start + 40, To: start + 60, Duration: "20s"}}; report.GapCount != 1 || !reflect.DeepEqual(report.Gaps, expected) {
+		t.Errorf("Expected the gaps %v, got %v", expected, report.Gaps)
+	}
+	if report.DuplicateCount != 1 || report.Duplicates[0].Time != start {
+		t.Errorf("Expected a duplicate at the start, got %v", report.Duplicates)
+	}
+	if report.OutOfOrderCount != 1 || report.OutOfOrder[0].Time != start+40 {
+		t.Errorf("Expected the record at 40 out of order, got %v", report.OutOfOrder)
+	}
+	if expected := []RangeViolation{{Time: start + 70, Value: 150}}; report.RangeViolationCount != 1 || !reflect.DeepEqual(report.RangeViolations, expected) {
+		t.Errorf("Expected the range violations %v, got %v", expected, report.RangeViolations)
+	}
+	if expected := []DailyCompleteness{{Day: "2018-11-24", Records: 9, Expected: 9, Percent: 100}}; !reflect.DeepEqual(report.Completeness, expected) {
+		t.Errorf("Expected the completeness %v, got %v", expected, report.Completeness)
+	}
+
+	// a gap threshold for all data streams, including the gaps at the start and the end of the time range
+	res, err = http.Get(ts.URL + "/data/" + series.Name + "," + undeclared.Name + "/quality?gap=15s&from=" + from + "&to=" + to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qualitySet = QualitySet{}
+	err = json.NewDecoder(res.Body).Decode(&qualitySet)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qualitySet.Reports) != 2 {
+		t.Fatalf("Expected two reports, got %v", qualitySet)
+	}
+	if report := qualitySet.Reports[0]; report.GapCount != 1 || len(report.Completeness) != 1 {
+		t.Errorf("Expected a gap and the completeness of the float data stream, got %v", report)
+	}
+	if report := qualitySet.Reports[1]; report.Records != 0 || report.GapCount != 1 || report.Gaps[0].Duration != "1m30s" ||
+		report.Completeness != nil {
+		t.Errorf("Expected a single gap of the empty data stream, got %v", report)
+	}
+
+	// a future time range is not capped to an empty one
+	future := time.Now().Add(48 * time.Hour).UTC()
+	futureRange := "from=" + future.Format(time.RFC3339) + "&to=" + future.Add(24*time.Hour).Format(time.RFC3339)
+	// a time range longer than the maximum number of days is rejected
+	longRange := "from=" + time.Now().AddDate(0, 0, -MaxQualityDays-1).UTC().Format(time.RFC3339)
+	for _, query := range []string{series.Name + "/quality", series.Name + "/quality?gap=soon&from=" + from,
+		undeclared.Name + "/quality?from=" + from, series.Name + "/quality?" + futureRange, series.Name + "/quality?" + longRange} {
+		res, err := http.Get(ts.URL + "/data/" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: server response is not %v but %v", query, http.StatusBadRequest, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"github.com/farshidtz/senml"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// DefaultQuarantineSize is the default maximum number of quarantined payloads
+	DefaultQuarantineSize = 1000
+	// MaxQuarantinePerPage is the maximum number of quarantined payloads per page
+	MaxQuarantinePerPage = 100
+
+	// Sources of quarantined payloads
+	QuarantineHTTP = "http"
+	QuarantineMQTT = "mqtt"
+)
+
+// QuarantineEntry is a rejected payload, which is kept for inspection and replay
+type QuarantineEntry struct {
+	ID uint64 `json:"id"`
+	// Time is the time of the rejection
+	Time time.Time `json:"time"`
+	// Source is the ingestion path of the payload (http or mqtt)
+	Source string `json:"source"`
+	// Broker and Topic are the MQTT broker and topic of the payload. For HTTP, Topic is the request path.
+	Broker string `json:"broker,omitempty"`
+	Topic  string `json:"topic,omitempty"`
+	// Reason is the reason of the rejection
+	Reason string `json:"reason"`
+	// Records are the rejected records
+	Records senml.Pack `json:"records,omitempty"`
+	// Payload is the raw payload, if it could not be parsed, and ContentType its media type
+	Payload     []byte `json:"payload,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// Pack returns the rejected records, parsing the raw payload if necessary
+func (e *QuarantineEntry) Pack() (senml.Pack, error) {
+	if e.Payload == nil {
+		return e.Records, nil
+	}
+	format, ok := common.SenMLFormat(e.ContentType)
+	if !ok {
+		return nil, fmt.Errorf("unsupported content type: %s", e.ContentType)
+	}
+	pack, err := senml.Decode(e.Payload, format)
+	if err != nil {
+		return nil, err
+	}
+	return pack.Normalize(), nil
+}
+
+// QuarantineList is a page of quarantined payloads, the most recent first
+type QuarantineList struct {
+	Entries []QuarantineEntry `json:"entries"`
+	Page    int               `json:"page"`
+	PerPage int               `json:"per_page"`
+	Total   int               `json:"total"`
+}
+
+// Quarantine keeps a bounded number of rejected payloads in memory
+// When full, the oldest payloads are dropped. A nil Quarantine keeps nothing.
+type Quarantine struct {
+	mutex   sync.RWMutex
+	size    int
+	lastID  uint64
+	entries []QuarantineEntry // the oldest first
+}
+
+// NewQuarantine returns a quarantine of the given size (DefaultQuarantineSize if zero)
+// It returns nil for a negative size, which disables the quarantine.
+func NewQuarantine(size int) *Quarantine {
+	if size < 0 {
+		return nil
+	}
+	if size == 0 {
+		size = DefaultQuarantineSize
+	}
+	return &Quarantine{size: size}
+}
+
+// Add quarantines a payload and returns its ID
+func (q *Quarantine) Add(e QuarantineEntry) uint64 {
+	if q == nil {
+		return 0
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.lastID++
+	e.ID = q.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if len(q.entries) == q.size {
+		q.entries = append(q.entries[:0], q.entries[1:]...)
+	}
+	q.entries = append(q.entries, e)
+	return e.ID
+}
+
+// List returns a page of the quarantined payloads, the most recent first, and the total number of payloads
+func (q *Quarantine) List(page, perPage int) ([]QuarantineEntry, int) {
+	entries := []QuarantineEntry{}
+	if q == nil {
+		return entries, 0
+	}
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	total := len(q.entries)
+	for i := total - 1 - (page-1)*perPage; i >= 0 && len(entries) < perPage; i-- {
+		entries = append(entries, q.entries[i])
+	}
+	return entries, total
+}
+
+// Get returns a quarantined payload
+func (q *Quarantine) Get(id uint64) (QuarantineEntry, bool) {
+	if q == nil {
+		return QuarantineEntry{}, false
+	}
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	i, found := q.index(id)
+	if !found {
+		return QuarantineEntry{}, false
+	}
+	return q.entries[i], true
+}
+
+// Remove removes a quarantined payload and returns false if it does not exist
+func (q *Quarantine) Remove(id uint64) bool {
+	if q == nil {
+		return false
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	i, found := q.index(id)
+	if !found {
+		return false
+	}
+	q.entries = append(q.entries[:i], q.entries[i+1:]...)
+	return true
+}
+
+// index returns the position of a payload. The entries are sorted by ID.
+func (q *Quarantine) index(id uint64) (int, bool) {
+	low, high := 0, len(q.entries)
+	for low < high {
+		mid := (low + high) / 2
+		if q.entries[mid].ID < id {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low, low < len(q.entries) && q.entries[low].ID == id
+}
+
+// QuarantineIndex is a handler for listing the quarantined payloads
+// Expected parameters: optional: page, perPage
+func (api *API) QuarantineIndex(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	page, perPage, err := common.ParsePagingParams(r.Form.Get(common.ParamPage), r.Form.Get(common.ParamPerPage), MaxQuarantinePerPage)
+	if err != nil {
+		common.ErrorResponse(http.StatusBadRequest, err.Error(), w)
+		return
+	}
+
+	entries, total := api.quarantine.List(page, perPage)
+	b, err := json.Marshal(QuarantineList{
+		Entries: entries,
+		Page:    page,
+		PerPage: perPage,
+		Total:   total,
+	})
+	if err != nil {
+		common.ErrorResponse(http.StatusInternalServerError, "Error marshalling quarantine: "+err.Error(), w)
+		return
+	}
+	w.Header().Add("Content-Type", common.DefaultMIMEType)
+	w.Write(b)
+}
+
+// QuarantineRetrieve is a handler for inspecting a quarantined payload
+// Expected parameters: qid
+func (api *API) QuarantineRetrieve(w http.ResponseWriter, r *http.Request) {
+	entry, ok := api.quarantined(w, r)
+	if !ok {
+		return
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		common.ErrorResponse(http.StatusInternalServerError, "Error marshalling quarantined payload: "+err.Error(), w)
+		return
+	}
+	w.Header().Add("Content-Type", common.DefaultMIMEType)
+	w.Write(b)
+}
+
+// QuarantineReplay is a handler for submitting a quarantined payload again, e.g. once its data streams are registered
+// The payload is removed from the quarantine when it is stored, and kept otherwise.
+// Expected parameters: qid
+func (api *API) QuarantineReplay(w http.ResponseWriter, r *http.Request) {
+	entry, ok := api.quarantined(w, r)
+	if !ok {
+		return
+	}
+	records, err := entry.Pack()
+	if err != nil {
+		common.ErrorResponse(http.StatusConflict, "Error parsing quarantined payload: "+err.Error(), w)
+		return
+	}
+
+	// the rejections of a replay are not quarantined again
+	sub := newSubmission(records, false, nil, QuarantineEntry{})
+	for i, r := range records {
+		ds, err := api.registry.Get(r.Name)
+		if err != nil {
+			sub.reject(w, i, http.StatusConflict, fmt.Sprintf("Data point for unknown data source %v.", r.Name))
+			return
+		}
+		if err := checkValueType(r, ds); err != nil {
+			sub.reject(w, i, http.StatusConflict, err.Error())
+			return
+		}
+		sub.add(i, ds)
+	}
+	if sub.store(w, api.storage) {
+		api.quarantine.Remove(entry.ID)
+	}
+}
+
+// QuarantineDelete is a handler for discarding a quarantined payload
+// Expected parameters: qid
+func (api *API) QuarantineDelete(w http.ResponseWriter, r *http.Request) {
+	entry, ok := api.quarantined(w, r)
+	if !ok {
+		return
+	}
+	api.quarantine.Remove(entry.ID)
+	w.Header().Set("Content-Type", common.DefaultMIMEType)
+	w.WriteHeader(http.StatusOK)
+}
+
+// quarantined returns the quarantined payload of the request, or writes an error response
+func (api *API) quarantined(w http.ResponseWriter, r *http.Request) (QuarantineEntry, bool) {
+	qid := mux.Vars(r)["qid"]
+	id, err := strconv.ParseUint(qid, 10, 64)
+	if err != nil {
+		common.ErrorResponse(http.StatusBadRequest, fmt.Sprintf("Invalid quarantine id: %s", qid), w)
+		return QuarantineEntry{}, false
+	}
+	entry, found := api.quarantine.Get(id)
+	if !found {
+		common.ErrorResponse(http.StatusNotFound, fmt.Sprintf("Quarantined payload %d not found", id), w)
+		return QuarantineEntry{}, false
+	}
+	return entry, true
+}
@@ -0,0 +1,140 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"github.com/farshidtz/senml"
+	"github.com/gorilla/mux"
+)
+
+func TestQuarantineBounded(t *testing.T) {
+	q := NewQuarantine(3)
+	for i := 1; i <= 5; i++ {
+		q.Add(QuarantineEntry{Source: QuarantineHTTP, Reason: fmt.Sprint(i)})
+	}
+
+	// the oldest entries are dropped, the most recent is listed first
+	entries, total := q.List(1, 2)
+	if total != 3 || len(entries) != 2 || entries[0].ID != 5 || entries[1].ID != 4 {
+		t.Fatalf("Unexpected first page of %d entries: %+v", total, entries)
+	}
+	entries, _ = q.List(2, 2)
+	if len(entries) != 1 || entries[0].ID != 3 {
+		t.Fatalf("Unexpected second page: %+v", entries)
+	}
+	if _, found := q.Get(2); found {
+		t.Errorf("Expected entry 2 to be dropped")
+	}
+
+	if !q.Remove(4) || q.Remove(4) {
+		t.Errorf("Expected entry 4 to be removed once")
+	}
+	if entry, found := q.Get(5); !found || entry.Reason != "5" {
+		t.Errorf("Unexpected entry 5 after removal: %+v", entry)
+	}
+
+	// a disabled quarantine keeps nothing
+	var disabled *Quarantine = NewQuarantine(-1)
+	disabled.Add(QuarantineEntry{})
+	if _, total := disabled.List(1, 10); total != 0 {
+		t.Errorf("Expected an empty disabled quarantine")
+	}
+}
+
+func TestQuarantineReplay(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+	regStorage := registry.NewMemoryStorage(common.RegConf{}, storage)
+	quarantine := NewQuarantine(0)
+	api := NewAPI(regStorage, storage, false, quarantine)
+	r := mux.NewRouter().StrictSlash(true).SkipClean(true)
+	r.Methods("GET").Path("/quarantine").HandlerFunc(api.QuarantineIndex)
+	r.Methods("POST").Path("/quarantine/{qid:[0-9]+}/replay").HandlerFunc(api.QuarantineReplay)
+	r.Methods("POST").Path("/data/{id:.+}").HandlerFunc(api.Submit)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// data of a device which publishes before its registration
+	v := 1.0
+	b, _ := json.Marshal(senml.Pack{{Name: "test/early", Time: 1543059346, Value: &v}})
+	res, err := http.Post(ts.URL+"/data/test/early", senml.MediaTypeSenmlJSON, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("Server response is not %v but %v", http.StatusNotFound, res.StatusCode)
+	}
+	res, err = http.Post(ts.URL+"/data/test/early", senml.MediaTypeSenmlJSON, strings.NewReader("not senml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	res, err = http.Get(ts.URL + "/quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list QuarantineList
+	err = json.NewDecoder(res.Body).Decode(&list)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 2 {
+		t.Fatalf("Expected 2 quarantined payloads, got %+v", list)
+	}
+	unknown, unparsable := list.Entries[1], list.Entries[0]
+	if unknown.Source != QuarantineHTTP || unknown.Topic != "/data/test/early" || len(unknown.Records) != 1 ||
+		!strings.Contains(unknown.Reason, "unknown data source") {
+		t.Errorf("Unexpected quarantined records: %+v", unknown)
+	}
+	if string(unparsable.Payload) != "not senml" || unparsable.ContentType != senml.MediaTypeSenmlJSON {
+		t.Errorf("Unexpected quarantined payload: %+v", unparsable)
+	}
+
+	replay := func(id uint64) int {
+		res, err := http.Post(fmt.Sprintf("%s/quarantine/%d/replay", ts.URL, id), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := replay(unknown.ID); code != http.StatusConflict {
+		t.Fatalf("Server response to the replay before registration is not %v but %v", http.StatusConflict, code)
+	}
+	if code := replay(unparsable.ID); code != http.StatusConflict {
+		t.Fatalf("Server response to the replay of an unparsable payload is not %v but %v", http.StatusConflict, code)
+	}
+
+	ds := registry.DataStream{Name: "test/early", Type: common.FLOAT}
+	_, err = regStorage.Add(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := replay(unknown.ID); code != http.StatusAccepted {
+		t.Fatalf("Server response to the replay is not %v but %v", http.StatusAccepted, code)
+	}
+	if _, found := quarantine.Get(unknown.ID); found {
+		t.Errorf("Expected the replayed payload to leave the quarantine")
+	}
+	pack, _, _, err := storage.Query(Query{From: time.Unix(0, 0), To: time.Now(), Sort: common.ASC, Limit: -1, PerPage: MaxPerPage}, &ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pack) != 1 {
+		t.Errorf("Expected the replayed record to be stored, got %v", pack)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"github.com/farshidtz/senml"
+)
+
+const (
+	// DefaultFlushSize is the default maximum number of data points per group commit
+	DefaultFlushSize = 1000
+	// DefaultFlushInterval is the default maximum time which queued data points wait for a group commit
+	DefaultFlushInterval = 50 * time.Millisecond
+	// DefaultQueueCapacity is the default maximum number of queued data points
+	DefaultQueueCapacity = 100000
+	// DefaultEnqueueWait is the maximum time which Enqueue waits for room in a full queue
+	DefaultEnqueueWait = time.Second
+)
+
+var (
+	// ErrQueueFull is returned when the write queue has no room for a submission
+	ErrQueueFull = errors.New("the write queue is full")
+	// ErrQueueClosed is returned when submitting to a closed write queue
+	ErrQueueClosed = errors.New("the write queue is closed")
+)
+
+// Enqueuer is implemented by storages which store data points asynchronously
+type Enqueuer interface {
+	// Enqueue queues the data points for storage and returns without waiting for them to be stored
+	// If the queue is full, it waits a bounded time for room and then fails with ErrQueueFull. done is called with the
+	// result of Submit once the data points are stored.
+	Enqueue(data map[string]senml.Pack, sources map[string]*registry.DataStream, done func(Duplicates, error)) error
+}
+
+// WriteQueue is a data storage which queues the submissions from all ingestion paths and stores them in group commits
+// A group commit stores several submissions at once. Each submission remains atomic: if a group commit fails, its
+// submissions are stored one by one.
+type WriteQueue struct {
+	Storage
+	flushSize int
+	interval  time.Duration
+	capacity  int
+	// maximum time which Enqueue waits for room
+	enqueueWait time.Duration
+
+	mutex   sync.Mutex
+	room    *sync.Cond // signalled when the queued submissions are taken for storage
+	pending []*queuedSubmission
+	queued  int // number of queued data points
+	closed  bool
+	flush   chan struct{}
+	stopped chan struct{}
+}
+
+type queuedSubmission struct {
+	data    map[string]senml.Pack
+	sources map[string]*registry.DataStream
+	partial bool
+	size    int
+	done    func(Duplicates, error)
+}
+
+// NewWriteQueue returns a write queue which stores the submissions in the given storage
+func NewWriteQueue(storage Storage, conf common.QueueConf) (*WriteQueue, error) {
+	q := &WriteQueue{
+		Storage:     storage,
+		flushSize:   conf.FlushSize,
+		interval:    DefaultFlushInterval,
+		capacity:    conf.Capacity,
+		enqueueWait: DefaultEnqueueWait,
+		flush:       make(chan struct{}, 1),
+		stopped:     make(chan struct{}),
+	}
+	if q.flushSize <= 0 {
+		q.flushSize = DefaultFlushSize
+	}
+	if q.capacity <= 0 {
+		q.capacity = DefaultQueueCapacity
+	}
+	if conf.FlushInterval != "" {
+		var err error
+		q.interval, err = time.ParseDuration(conf.FlushInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flush interval: %s", err)
+		}
+		if q.interval <= 0 {
+			return nil, fmt.Errorf("invalid flush interval: %s. Interval must be greater than zero", conf.FlushInterval)
+		}
+	}
+	q.room = sync.NewCond(&q.mutex)
+	go q.run()
+	return q, nil
+}
+
+// Submit queues the data and waits until it is stored
+// It returns ErrQueueFull without waiting if the queue has no room for the data.
+func (q *WriteQueue) Submit(data map[string]senml.Pack, sources map[string]*registry.DataStream) (Duplicates, error) {
+	return q.wait(data, sources, false)
+}
+
+// SubmitPartial queues the data and waits until it is stored
+// It returns ErrQueueFull without waiting if the queue has no room for the data.
+func (q *WriteQueue) SubmitPartial(data map[string]senml.Pack, sources map[string]*registry.DataStream) (Duplicates, error) {
+	return q.wait(data, sources, true)
+}
+
+// Enqueue queues the data and returns before the data is stored
+// If the queue is full, it waits for room for up to DefaultEnqueueWait and then returns ErrQueueFull.
+func (q *WriteQueue) Enqueue(data map[string]senml.Pack, sources map[string]*registry.DataStream, done func(Duplicates, error)) error {
+	return q.enqueue(newQueuedSubmission(data, sources, false, done), q.enqueueWait)
+}
+
+// Close stores the queued data and stops the queue
+func (q *WriteQueue) Close() {
+	q.mutex.Lock()
+	if !q.closed {
+		q.closed = true
+		q.room.Broadcast()
+		close(q.flush)
+	}
+	q.mutex.Unlock()
+	<-q.stopped
+}
+
+func newQueuedSubmission(data map[string]senml.Pack, sources map[string]*registry.DataStream, partial bool, done func(Duplicates, error)) *queuedSubmission {
+	s := &queuedSubmission{data: data, sources: sources, partial: partial, done: done}
+	for _, records := range data {
+		s.size += len(records)
+	}
+	return s
+}
+
+func (q *WriteQueue) wait(data map[string]senml.Pack, sources map[string]*registry.DataStream, partial bool) (Duplicates, error) {
+	type result struct {
+		duplicates Duplicates
+		err        error
+	}
+	results := make(chan result, 1)
+	err := q.enqueue(newQueuedSubmission(data, sources, partial, func(duplicates Duplicates, err error) {
+		results <- result{duplicates, err}
+	}), 0)
+	if err != nil {
+		return nil, err
+	}
+	r := <-results
+	return r.duplicates, r.err
+}
+
+// enqueue adds a submission to the queue. If the queue is full, it waits for room for up to the given time and then
+// fails with ErrQueueFull. A submission larger than the capacity is accepted when the queue is empty.
+func (q *WriteQueue) enqueue(s *queuedSubmission, wait time.Duration) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	deadline := time.Now().Add(wait)
+	for !q.closed && q.queued > 0 && q.queued+s.size > q.capacity {
+		if !time.Now().Before(deadline) {
+			return ErrQueueFull
+		}
+		// wake up at the deadline, unless there is room earlier
+		timer := time.AfterFunc(time.Until(deadline), func() {
+			q.mutex.Lock()
+			q.room.Broadcast()
+			q.mutex.Unlock()
+		})
+		q.room.Wait()
+		timer.Stop()
+	}
+	if q.closed {
+		return ErrQueueClosed
+	}
+	q.pending = append(q.pending, s)
+	q.queued += s.size
+	if q.queued >= q.flushSize {
+		select {
+		case q.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// run stores the queued submissions at every interval, or as soon as there are enough of them
+func (q *WriteQueue) run() {
+	defer close(q.stopped)
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case _, open := <-q.flush:
+			if !open {
+				q.store()
+				return
+			}
+		}
+		q.store()
+	}
+}
+
+// store takes the queued submissions and stores them in group commits of up to flushSize data points
+// Only consecutive submissions of the same mode (partial or not) are committed together, to keep their order.
+func (q *WriteQueue) store() {
+	q.mutex.Lock()
+	pending := q.pending
+	q.pending, q.queued = nil, 0
+	q.room.Broadcast()
+	q.mutex.Unlock()
+
+	for start := 0; start < len(pending); {
+		end, size := start+1, pending[start].size
+		for end < len(pending) && pending[end].partial == pending[start].partial && size+pending[end].size <= q.flushSize {
+			size += pending[end].size
+			end++
+		}
+		q.commit(pending[start:end])
+		start = end
+	}
+}
+
+// commit stores the submissions at once and reports the result of each one
+func (q *WriteQueue) commit(batch []*queuedSubmission) {
+	submit := q.Storage.Submit
+	if batch[0].partial {
+		submit = q.Storage.SubmitPartial
+	}
+	if len(batch) == 1 {
+		batch[0].done(submit(batch[0].data, batch[0].sources))
+		return
+	}
+
+	// the records of each data stream in the order of submission, and the position of each submission among these
+	data := make(map[string]senml.Pack)
+	sources := make(map[string]*registry.DataStream)
+	offsets := make([]map[string]int, len(batch))
+	for i, s := range batch {
+		offsets[i] = make(map[string]int)
+		for name, records := range s.data {
+			offsets[i][name] = len(data[name])
+			data[name] = append(data[name], records...)
+			sources[name] = s.sources[name]
+		}
+	}
+
+	duplicates, err := submit(data, sources)
+	if err != nil {
+		// Store the submissions one by one, so that only the failing ones fail, e.g. those rejected as duplicates.
+		// Each submission is retried once and right away: if the storage itself fails, the retries fail alike and
+		// their errors are reported, without retrying any further.
+		for _, s := range batch {
+			s.done(submit(s.data, s.sources))
+		}
+		return
+	}
+	for i, s := range batch {
+		var own Duplicates
+		for name, indices := range duplicates {
+			offset, found := offsets[i][name]
+			if !found {
+				continue
+			}
+			for _, index := range indices {
+				if index >= offset && index < offset+len(s.data[name]) {
+					if own == nil {
+						own = make(Duplicates)
+					}
+					own[name] = append(own[name], index-offset)
+				}
+			}
+		}
+		s.done(own, nil)
+	}
+}
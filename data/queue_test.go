@@ -0,0 +1,135 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"github.com/farshidtz/senml"
+)
+
+// committingStorage counts the submissions which reach the storage
+type committingStorage struct {
+	Storage
+	commits int32
+}
+
+func (s *committingStorage) Submit(data map[string]senml.Pack, sources map[string]*registry.DataStream) (Duplicates, error) {
+	atomic.AddInt32(&s.commits, 1)
+	return s.Storage.Submit(data, sources)
+}
+
+func queuedRecord(ds *registry.DataStream, t float64) (map[string]senml.Pack, map[string]*registry.DataStream) {
+	v := t
+	return map[string]senml.Pack{ds.Name: {{Name: ds.Name, Time: t, Value: &v}}}, map[string]*registry.DataStream{ds.Name: ds}
+}
+
+func TestWriteQueueGroupCommit(t *testing.T) {
+	lightdb, teardown := setupLightdbStorage(t)
+	defer teardown()
+	storage := &committingStorage{Storage: lightdb}
+	queue, err := NewWriteQueue(storage, common.QueueConf{FlushSize: 10, FlushInterval: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+
+	const start = 1543059346.0
+	overwriting := &registry.DataStream{Name: "test/queue/overwrite", Type: common.FLOAT}
+	rejecting := &registry.DataStream{Name: "test/queue/reject", Type: common.FLOAT, Duplicates: common.DuplicatesReject}
+
+	// ten submissions fill a group commit, two of them rejecting the time of each other
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := 0; i < 10; i++ {
+		ds, t := overwriting, start+float64(i)
+		if i >= 8 {
+			ds, t = rejecting, start
+		}
+		wg.Add(1)
+		go func(i int, ds *registry.DataStream, t float64) {
+			defer wg.Done()
+			_, errs[i] = queue.Submit(queuedRecord(ds, t))
+		}(i, ds, t)
+	}
+	wg.Wait()
+
+	rejected := 0
+	for _, err := range errs {
+		if _, ok := err.(*DuplicateError); ok {
+			rejected++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rejected != 1 {
+		t.Errorf("Expected one rejected submission, got %d: %v", rejected, errs)
+	}
+	// a failing group commit is followed by a commit of each submission
+	if commits := atomic.LoadInt32(&storage.commits); commits != 11 {
+		t.Errorf("Expected a failing group commit and 10 single commits, got %d commits", commits)
+	}
+
+	q := Query{From: time.Unix(0, 0), To: time.Now(), Sort: common.ASC, Limit: -1, PerPage: MaxPerPage}
+	for ds, expected := range map[*registry.DataStream]int{overwriting: 8, rejecting: 1} {
+		pack, _, _, err := lightdb.Query(q, ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pack) != expected {
+			t.Errorf("%s: expected %d stored records, got %d", ds.Name, expected, len(pack))
+		}
+	}
+}
+
+func TestWriteQueueBackpressure(t *testing.T) {
+	lightdb, teardown := setupLightdbStorage(t)
+	defer teardown()
+	storage := &committingStorage{Storage: lightdb}
+	queue, err := NewWriteQueue(storage, common.QueueConf{Capacity: 1, FlushInterval: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := &registry.DataStream{Name: "test/queue/full", Type: common.FLOAT}
+	done := make(chan error, 1)
+	data, sources := queuedRecord(ds, 1543059346)
+	err = queue.Enqueue(data, sources, func(_ Duplicates, err error) { done <- err })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the queue is full until the next flush
+	if _, err := queue.Submit(queuedRecord(ds, 1543059347)); err != ErrQueueFull {
+		t.Fatalf("Expected %v, got %v", ErrQueueFull, err)
+	}
+	queue.enqueueWait = 10 * time.Millisecond
+	data, sources = queuedRecord(ds, 1543059347)
+	if err := queue.Enqueue(data, sources, func(_ Duplicates, err error) { done <- err }); err != ErrQueueFull {
+		t.Fatalf("Expected %v after waiting for room, got %v", ErrQueueFull, err)
+	}
+	select {
+	case <-done:
+		t.Fatalf("Expected the queued data to wait for the flush")
+	default:
+	}
+
+	// closing the queue stores the queued data
+	queue.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("Expected the queued data to be stored on close")
+	}
+	if _, err := queue.Submit(queuedRecord(ds, 1543059347)); err != ErrQueueClosed {
+		t.Fatalf("Expected %v, got %v", ErrQueueClosed, err)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	datastore "github.com/dschowta/senml.datastore"
+	"github.com/farshidtz/senml"
+)
+
+// MaxResampledRecords is the maximum number of records of a resampled query, i.e. of steps times data streams,
+// and of a downsampled query
+const MaxResampledRecords = 100000
+
+// endOfTime bounds the search for the record which follows the time range of a resampled query
+var endOfTime = time.Unix(1<<33, 0)
+
+// Resampling is the resampling of a query to one record per step from the start of the query
+type Resampling struct {
+	Step time.Duration
+	// Fill is the value of the steps without records: null, previous, linear or value
+	Fill string
+	// FillValue is the value of the empty steps with the value fill
+	FillValue string
+}
+
+// ParseResampling parses the resampling arguments of a query, e.g. step=1m&fill=linear
+// The step is a duration (e.g. 30s or 1h30m), or a number of days or weeks (e.g. 1d). It returns nil without step.
+func ParseResampling(form url.Values) (*Resampling, error) {
+	if form.Get(common.ParamStep) == "" {
+		if form.Get(common.ParamFill) != "" {
+			return nil, fmt.Errorf("The %s argument requires a %s argument", common.ParamFill, common.ParamStep)
+		}
+		return nil, nil
+	}
+	step, err := common.ParseDuration(form.Get(common.ParamStep))
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing %s argument: %s", common.ParamStep, err)
+	}
+
+	res := &Resampling{Step: step, Fill: form.Get(common.ParamFill), FillValue: form.Get(common.ParamFillValue)}
+	switch res.Fill {
+	case "":
+		res.Fill = common.FillNull
+	case common.FillNull, common.FillPrevious, common.FillLinear:
+	case common.FillValue:
+		if _, found := form[common.ParamFillValue]; !found {
+			return nil, fmt.Errorf("The %s fill requires a %s argument", common.FillValue, common.ParamFillValue)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid fill argument: %s. Supported fills are: %s, %s, %s, %s", res.Fill,
+			common.FillNull, common.FillPrevious, common.FillLinear, common.FillValue)
+	}
+	return res, nil
+}
+
+// query returns the resampling as query parameters, e.g. &step=1m0s&fill=linear
+func (res *Resampling) query() string {
+	q := fmt.Sprintf("&%s=%s&%s=%s", common.ParamStep, res.Step, common.ParamFill, res.Fill)
+	if res.Fill == common.FillValue {
+		q += fmt.Sprintf("&%s=%s", common.ParamFillValue, url.QueryEscape(res.FillValue))
+	}
+	return q
+}
+
+// filling returns the record of the empty steps of a data stream with the value fill, with the value in the field
+// of the data stream type
+func (res *Resampling) filling(ds *registry.DataStream) (senml.Record, error) {
+	r := senml.Record{Name: ds.Name}
+	switch ds.Type {
+	case common.FLOAT:
+		v, err := strconv.ParseFloat(res.FillValue, 64)
+		if err != nil {
+			return r, fmt.Errorf("Invalid %s argument for float data stream %s: %s", common.ParamFillValue, ds.Name, res.FillValue)
+		}
+		r.Value = &v
+	case common.BOOL:
+		vb, err := strconv.ParseBool(res.FillValue)
+		if err != nil {
+			return r, fmt.Errorf("Invalid %s argument for bool data stream %s: %s", common.ParamFillValue, ds.Name, res.FillValue)
+		}
+		r.BoolValue = &vb
+	case common.DATA:
+		r.DataValue = res.FillValue
+	default:
+		r.StringValue = res.FillValue
+	}
+	return r, nil
+}
+
+// resampledStep collects the records of a step
+type resampledStep struct {
+	count int
+	sum   float64
+	last  senml.Record
+}
+
+// resampler computes the steps of a data stream from its records
+// The value of a step is the mean of its records for float data streams, and the value of its last record otherwise.
+type resampler struct {
+	ds       *registry.DataStream
+	from     float64 // SenML time
+	step     float64 // seconds
+	steps    []resampledStep
+	previous *senml.Record // the last record before the time range, for the previous and linear fills
+	next     *senml.Record // the first record after the time range, for the linear fill
+}
+
+func newResampler(ds *registry.DataStream, from time.Time, step time.Duration, steps int) *resampler {
+	return &resampler{
+		ds:    ds,
+		from:  datastore.ToSenmlTime(from),
+		step:  step.Seconds(),
+		steps: make([]resampledStep, steps),
+	}
+}
+
+// add adds a record to its step. The records must be added in ascending order.
+func (rs *resampler) add(r senml.Record) {
+	// the records at the start of a step are tolerated to the microsecond, in spite of the float times
+	k := int(math.Floor((r.Time - rs.from + 1e-6) / rs.step))
+	if k < 0 || k >= len(rs.steps) {
+		return
+	}
+	if rs.ds.Type == common.FLOAT {
+		if r.Value == nil {
+			return
+		}
+		rs.steps[k].sum += *r.Value
+	}
+	rs.steps[k].count++
+	rs.steps[k].last = r
+}
+
+// time returns the time of the k-th step
+func (rs *resampler) time(k int) float64 {
+	return rs.from + float64(k)*rs.step
+}
+
+// records returns one record per step, with the given record for the empty steps of the value fill
+func (rs *resampler) records(fill string, filling senml.Record) senml.Pack {
+	pack := make(senml.Pack, len(rs.steps))
+	known := make([]bool, len(rs.steps))
+	for k, s := range rs.steps {
+		if s.count == 0 {
+			continue
+		}
+		pack[k], known[k] = valueOf(s.last), true
+		if rs.ds.Type == common.FLOAT {
+			mean := s.sum / float64(s.count)
+			pack[k].Value = &mean
+		}
+		pack[k].Time = rs.time(k)
+	}
+
+	if fill == common.FillLinear && rs.ds.Type != common.FLOAT {
+		fill = common.FillPrevious
+	}
+	// next is the first known record after each step, for the linear fill
+	next := make([]*senml.Record, len(pack))
+	if fill == common.FillLinear {
+		following := rs.next
+		for k := len(pack) - 1; k >= 0; k-- {
+			next[k] = following
+			if known[k] {
+				following = &pack[k]
+			}
+		}
+	}
+
+	previous := rs.previous
+	for k := range pack {
+		if known[k] {
+			previous = &pack[k]
+			continue
+		}
+		switch {
+		case fill == common.FillValue:
+			pack[k] = filling
+		case fill == common.FillPrevious && previous != nil:
+			pack[k] = valueOf(*previous)
+		case fill == common.FillLinear && previous != nil && previous.Value != nil && next[k] != nil && next[k].Value != nil:
+			v := *previous.Value + (*next[k].Value-*previous.Value)*(rs.time(k)-previous.Time)/(next[k].Time-previous.Time)
+			pack[k] = senml.Record{Unit: previous.Unit, Value: &v}
+		}
+		pack[k].Name, pack[k].Time = rs.ds.Name, rs.time(k)
+	}
+	return pack
+}
+
+// valueOf returns a record with the unit and value of the given record
+func valueOf(r senml.Record) senml.Record {
+	return senml.Record{Name: r.Name, Unit: r.Unit, Time: r.Time,
+		Value: r.Value, StringValue: r.StringValue, DataValue: r.DataValue, BoolValue: r.BoolValue}
+}
+
+// resample queries one record per step and data stream, from the start of the query until its end
+// The records of a step are ordered as the data streams. It also returns the status code of the error, if any.
+func (api *API) resample(ctx context.Context, q Query, res *Resampling, sources []*registry.DataStream) (senml.Pack, int, error) {
+	if q.From.IsZero() {
+		return nil, http.StatusBadRequest, fmt.Errorf("The %s argument requires a %s argument", common.ParamStep, common.ParamFrom)
+	}
+	steps := int(q.To.Sub(q.From)/res.Step) + 1
+	if steps > MaxResampledRecords/len(sources) {
+		return nil, http.StatusBadRequest,
+			fmt.Errorf("The query yields more than the maximum of %d records. Use a larger step or a shorter time range.", MaxResampledRecords)
+	}
+
+	resamplers := make(map[string]*resampler, len(sources))
+	fillings := make(map[string]senml.Record, len(sources))
+	for _, ds := range sources {
+		rs := newResampler(ds, q.From, res.Step, steps)
+		resamplers[ds.Name] = rs
+
+		switch res.Fill {
+		case common.FillValue:
+			filling, err := res.filling(ds)
+			if err != nil {
+				return nil, http.StatusBadRequest, err
+			}
+			fillings[ds.Name] = filling
+		case common.FillPrevious, common.FillLinear:
+			previous, _, _, err := api.storage.Query(Query{To: q.From, Sort: common.DESC, Limit: 1, PerPage: 1, Filter: q.Filter}, ds)
+			if err != nil {
+				return nil, http.StatusInternalServerError, fmt.Errorf("Error retrieving data from the database: %s", err)
+			}
+			if len(previous) > 0 {
+				rs.previous = &previous[0]
+			}
+			if res.Fill == common.FillLinear && ds.Type == common.FLOAT && q.To.Before(endOfTime) {
+				next, _, _, err := api.storage.Query(Query{From: q.To, To: endOfTime, Sort: common.ASC, Limit: 1, PerPage: 1, Filter: q.Filter}, ds)
+				if err != nil {
+					return nil, http.StatusInternalServerError, fmt.Errorf("Error retrieving data from the database: %s", err)
+				}
+				if len(next) > 0 {
+					rs.next = &next[0]
+				}
+			}
+		}
+	}
+
+	records, errs := api.storage.QueryStream(ctx,
+		Query{From: q.From, To: q.To, Sort: common.ASC, Limit: -1, PerPage: MaxPerPage, Filter: q.Filter}, sources...)
+	for record := range records {
+		if rs, found := resamplers[record.Name]; found {
+			rs.add(record)
+		}
+	}
+	if err := <-errs; err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("Error retrieving data from the database: %s", err)
+	}
+
+	data := make(senml.Pack, 0, steps*len(sources))
+	resampled := make([]senml.Pack, len(sources))
+	for i, ds := range sources {
+		resampled[i] = resamplers[ds.Name].records(res.Fill, fillings[ds.Name])
+	}
+	for n := 0; n < steps; n++ {
+		k := n
+		if q.Sort == common.DESC {
+			k = steps - 1 - n
+		}
+		for i := range sources {
+			data = append(data, resampled[i][k])
+		}
+	}
+	if q.Limit > 0 && len(data) > q.Limit {
+		data = data[:q.Limit]
+	}
+	return data, 0, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"github.com/farshidtz/senml"
+	"github.com/gorilla/mux"
+)
+
+func TestParseResampling(t *testing.T) {
+	for query, expected := range map[string]*Resampling{
+		"":                               nil,
+		"step=1m":                        {Step: time.Minute, Fill: common.FillNull},
+		"step=1h30m&fill=linear":         {Step: 90 * time.Minute, Fill: common.FillLinear},
+		"step=1d&fill=previous":          {Step: 24 * time.Hour, Fill: common.FillPrevious},
+		"step=2w&fill=value&fillValue=0": {Step: 14 * 24 * time.Hour, Fill: common.FillValue, FillValue: "0"},
+	} {
+		res, err := ParseResampling(mustParseQuery(t, query))
+		if err != nil {
+			t.Errorf("%s: %s", query, err)
+			continue
+		}
+		if (res == nil) != (expected == nil) || res != nil && *res != *expected {
+			t.Errorf("%s: expected %+v, got %+v", query, expected, res)
+		}
+	}
+
+	for _, query := range []string{"fill=linear", "step=0s", "step=-1m", "step=1M", "step=1m&fill=spline", "step=1m&fill=value"} {
+		if _, err := ParseResampling(mustParseQuery(t, query)); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+}
+
+func TestHttpQueryResampled(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+	regStorage := registry.NewMemoryStorage(common.RegConf{}, storage)
+	const start = 1543059300.0 // at a whole minute
+
+	temperature := &registry.DataStream{Name: "test/resample/temperature", Type: common.FLOAT}
+	state := &registry.DataStream{Name: "test/resample/state", Type: common.STRING}
+	for _, ds := range []*registry.DataStream{temperature, state} {
+		_, err := regStorage.Add(*ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	float := func(v float64) *float64 { return &v }
+	_, err := storage.Submit(map[string]senml.Pack{
+		temperature.Name: {
+			{Name: temperature.Name, Time: start - 60, Value: float(10)},
+			// two records in the first step
+			{Name: temperature.Name, Time: start, Value: float(20)},
+			{Name: temperature.Name, Time: start + 30, Value: float(22)},
+			// the third and fourth steps are empty
+			{Name: temperature.Name, Time: start + 240, Value: float(30)},
+		},
+		state.Name: {
+			{Name: state.Name, Time: start - 60, StringValue: "idle"},
+			{Name: state.Name, Time: start + 120, StringValue: "running"},
+		},
+	}, map[string]*registry.DataStream{temperature.Name: temperature, state.Name: state})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := NewAPI(regStorage, storage, false, nil)
+	r := mux.NewRouter().StrictSlash(true).SkipClean(true)
+	r.Methods("GET").Path("/data/{id:.+}").HandlerFunc(api.Query)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// a step per minute from start to start+240, the steps at start+60 to start+180 without temperature
+	query := func(from, to float64, fill string) senml.Pack {
+		res, err := http.Get(ts.URL + "/data/" + temperature.Name + "," + state.Name + "?sort=asc&step=1m" +
+			"&from=" + time.Unix(int64(from), 0).UTC().Format(time.RFC3339) +
+			"&to=" + time.Unix(int64(to), 0).UTC().Format(time.RFC3339) + "&fill=" + fill)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: server response is not %v but %v", fill, http.StatusOK, res.StatusCode)
+		}
+		var recordSet RecordSet
+		err = json.NewDecoder(res.Body).Decode(&recordSet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return recordSet.Data
+	}
+	for fill, expected := range map[string][]interface{}{
+		"null":               {21.0, nil, nil, nil, nil, "running", nil, nil, 30.0, nil},
+		"value&fillValue=-1": {21.0, "-1", -1.0, "-1", -1.0, "running", -1.0, "-1", 30.0, "-1"},
+		"previous":           {21.0, "idle", 21.0, "idle", 21.0, "running", 21.0, "running", 30.0, "running"},
+		"linear":             {21.0, "idle", 23.25, "idle", 25.5, "running", 27.75, "running", 30.0, "running"},
+	} {
+		data := query(start, start+240, fill)
+		if len(data) != len(expected) {
+			t.Fatalf("%s: expected %d records, got %v", fill, len(expected), data)
+		}
+		for i, r := range data {
+			var value interface{}
+			switch {
+			case r.Value != nil:
+				value = *r.Value
+			case r.StringValue != "":
+				value = r.StringValue
+			}
+			if value != expected[i] || r.Time != start+float64(i/2*60) {
+				t.Errorf("%s: expected %v at %v, got %v at %v", fill, expected[i], start+float64(i/2*60), value, r.Time)
+			}
+		}
+	}
+
+	// the empty steps at the ends of the time range are interpolated from the records outside of it
+	data := query(start+60, start+180, "linear")
+	if len(data) != 6 || data[0].Value == nil || *data[0].Value != 22+8*30.0/210 {
+		t.Errorf("Expected the interpolation between the records before and after the time range, got %v", data)
+	}
+
+	for _, query := range []string{"?step=1m", "?step=1ms&from=2018-11-24T11:55:00Z", "?step=1m&from=2018-11-24T11:55:00Z&fill=value&fillValue=x",
+		"?step=1m&from=2018-11-24T11:55:00Z&page=1"} {
+		res, err := http.Get(ts.URL + "/data/" + temperature.Name + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: server response is not %v but %v", query, http.StatusBadRequest, res.StatusCode)
+		}
+	}
+}
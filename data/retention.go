@@ -0,0 +1,177 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+)
+
+// DefaultPurgeInterval is the default period of enforcing the maximum retention of data streams
+const DefaultPurgeInterval = time.Hour
+
+// beginningOfTime bounds the purges, as the counterpart of endOfTime. The storage keeps the times as nanoseconds since
+// the Unix epoch, from 1677 on, so that this leaves a margin for the alignment of rollup buckets.
+var beginningOfTime = time.Unix(-1<<33, 0)
+
+// Purge reports the records which were deleted from a data stream because of its maximum retention
+type Purge struct {
+	// Name of the data stream
+	Name string
+	// Before is the time before which the records were deleted
+	Before time.Time
+	// Count is the number of deleted records
+	Count int
+}
+
+// RetentionStatus reports the records which were deleted because of the maximum retention of their data streams
+type RetentionStatus struct {
+	// LastPurge is the time of the latest purge, if any
+	LastPurge *time.Time `json:"lastPurge,omitempty"`
+	// Purged is the number of records deleted from each data stream since the start of the service
+	Purged map[string]int `json:"purged"`
+}
+
+// RetentionWorker regularly deletes the records which are older than the maximum retention of their data streams
+type RetentionWorker struct {
+	registry registry.Storage
+	storage  Storage
+	interval time.Duration
+	// the purged records since the start
+	mutex     sync.Mutex
+	lastPurge *time.Time
+	purged    map[string]int
+}
+
+// NewRetentionWorker returns a retention worker which purges the data streams at the given interval
+func NewRetentionWorker(registryStorage registry.Storage, storage Storage, interval time.Duration) *RetentionWorker {
+	if interval <= 0 {
+		interval = DefaultPurgeInterval
+	}
+	return &RetentionWorker{
+		registry: registryStorage,
+		storage:  storage,
+		interval: interval,
+		purged:   make(map[string]int),
+	}
+}
+
+// Start purges the data streams immediately and then at every interval, until the returned function is called
+func (w *RetentionWorker) Start() (stop func()) {
+	ticker := time.NewTicker(w.interval)
+	done := make(chan struct{})
+	go func() {
+		w.run()
+		for {
+			select {
+			case <-ticker.C:
+				w.run()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+func (w *RetentionWorker) run() {
+	purges, err := w.Purge(time.Now())
+	for _, p := range purges {
+		log.Printf("Retention: purged %d records of %s before %s", p.Count, p.Name, p.Before.UTC().Format(time.RFC3339))
+	}
+	if err != nil {
+		log.Printf("Retention: %s", err)
+	}
+}
+
+// Purge deletes the records which are older than the maximum retention of each data stream, relative to the given time
+// It returns the data streams which had records deleted. Failures of a data stream do not stop purging the others.
+func (w *RetentionWorker) Purge(now time.Time) ([]Purge, error) {
+	var (
+		purges []Purge
+		errs   []string
+	)
+	for page := 1; ; page++ {
+		streams, total, err := w.registry.GetMany(page, registry.MaxPerPage)
+		if err != nil {
+			return purges, fmt.Errorf("error getting data streams: %s", err)
+		}
+		for i := range streams {
+			ds := &streams[i]
+			if ds.Retention.Max == "" {
+				continue
+			}
+			max, err := common.ParsePeriod(ds.Retention.Max)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", ds.Name, err))
+				continue
+			}
+			before := now.Add(-max)
+			count, err := w.storage.Delete(ds, beginningOfTime, before)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", ds.Name, err))
+			}
+			if count > 0 {
+				purges = append(purges, Purge{Name: ds.Name, Before: before, Count: count})
+			}
+		}
+		if page*registry.MaxPerPage >= total {
+			break
+		}
+	}
+	w.record(now, purges)
+	if len(errs) > 0 {
+		return purges, fmt.Errorf("error purging data streams: %s", strings.Join(errs, ", "))
+	}
+	return purges, nil
+}
+
+// record adds the purges to the status
+func (w *RetentionWorker) record(at time.Time, purges []Purge) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.lastPurge = &at
+	for _, p := range purges {
+		w.purged[p.Name] += p.Count
+	}
+}
+
+// Status returns the records which were purged since the start of the worker
+func (w *RetentionWorker) Status() RetentionStatus {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	status := RetentionStatus{Purged: make(map[string]int, len(w.purged))}
+	if w.lastPurge != nil {
+		lastPurge := *w.lastPurge
+		status.LastPurge = &lastPurge
+	}
+	for name, count := range w.purged {
+		status.Purged[name] = count
+	}
+	return status
+}
+
+// StatusHandler is a handler for the status of the retention, i.e. the records purged since the start of the service
+func (w *RetentionWorker) StatusHandler(res http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(w.Status())
+	if err != nil {
+		common.ErrorResponse(http.StatusInternalServerError, "Error marshalling retention status: "+err.Error(), res)
+		return
+	}
+	res.Header().Add("Content-Type", common.DefaultMIMEType)
+	res.WriteHeader(http.StatusOK)
+	res.Write(b)
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"testing"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"code.linksmart.eu/hds/historical-datastore/rollups"
+)
+
+func TestRetentionWorkerPurge(t *testing.T) {
+	storage, teardown := setupLightdbStorage(t)
+	defer teardown()
+	regStorage := registry.NewMemoryStorage(common.RegConf{RetentionPeriods: []string{"1h"}}, storage)
+
+	retained := registry.DataStream{
+		Name:        "test/retained",
+		Type:        common.FLOAT,
+		Aggregation: []registry.Aggregation{{Interval: "1h", Aggregates: []string{"sum"}}},
+	}
+	retained.Retention.Max = "1h"
+	unlimited := registry.DataStream{Name: "test/unlimited", Type: common.FLOAT}
+	for _, ds := range []registry.DataStream{retained, unlimited} {
+		_, err := regStorage.Add(ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// six records in the last three hours, 30 minutes apart: 0, 1, ... 5
+	now := time.Unix(1543068000, 0) // aligned to the hour
+	start := float64(now.Add(-165 * time.Minute).Unix())
+	submitSeries(t, storage, &retained, start, 1800, 6)
+	submitSeries(t, storage, &unlimited, start, 1800, 6)
+	// and one before 1970, which SenML only represents relative to now
+	submitRecord(t, storage, &retained, -float64(time.Now().Unix()+86400), 0)
+
+	worker := NewRetentionWorker(regStorage, storage, 0)
+	purges, err := worker.Purge(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purges) != 1 || purges[0].Name != retained.Name || purges[0].Count != 5 || !purges[0].Before.Equal(now.Add(-time.Hour)) {
+		t.Fatalf("Unexpected purges: %+v", purges)
+	}
+
+	q := Query{From: time.Unix(0, 0), To: now, Sort: common.ASC, Limit: -1, PerPage: MaxPerPage}
+	for ds, expected := range map[*registry.DataStream]int{&retained: 2, &unlimited: 6} {
+		_, total, _, err := storage.Query(q, ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != expected {
+			t.Errorf("Expected %d records of %s after purge, got %d", expected, ds.Name, total)
+		}
+	}
+
+	// only the rollup of the last hour remains, computed from the retained records
+	rollup := &registry.DataStream{Name: rollups.SeriesName(retained.Name, retained.Aggregation[0], "sum"), Type: common.FLOAT}
+	pack, _, _, err := storage.Query(q, rollup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pack) != 1 || pack[0].Time != float64(now.Add(-time.Hour).Unix()) || *pack[0].Value != 4+5 {
+		t.Fatalf("Unexpected rollup after purge: %v", pack)
+	}
+
+	// nothing left to purge
+	purges, err = worker.Purge(now.Add(time.Minute))
+	if err != nil || len(purges) != 0 {
+		t.Fatalf("Expected no purges, got %+v, %v", purges, err)
+	}
+	status := worker.Status()
+	if status.LastPurge == nil || !status.LastPurge.Equal(now.Add(time.Minute)) || len(status.Purged) != 1 || status.Purged[retained.Name] != 5 {
+		t.Errorf("Unexpected retention status: %+v", status)
+	}
+}
@@ -3,6 +3,7 @@
 package data
 
 import (
+	"context"
 	"strings"
 	"time"
 
@@ -31,11 +32,37 @@ type Storage interface {
 	// Adds data points for multiple data sources
 	// data is a map where keys are data source ids
 	// sources is a map where keys are data source ids
-	Submit(data map[string]senml.Pack, sources map[string]*registry.DataStream) error
+	// Data points at the time of an existing data point (or of another submitted data point) are handled according to
+	// the duplicates policy of the data source. Returns the indices of such duplicates, or a *DuplicateError on rejection.
+	// The submission is atomic: on error, none of the data points is added.
+	Submit(data map[string]senml.Pack, sources map[string]*registry.DataStream) (Duplicates, error)
+
+	// Adds data points like Submit, except that the data points rejected by the duplicates policy are left out instead
+	// of failing the submission. These are included in the returned duplicates.
+	SubmitPartial(data map[string]senml.Pack, sources map[string]*registry.DataStream) (Duplicates, error)
 
 	// Queries data for specified data sources
-	//Query(q Query, page, perPage int, sources ...*registry.DataSource) (senml.Pack, int, error)
-	Query(q Query, sources ...*registry.DataStream) (senml.Pack, int, *time.Time, error)
+	// Returns a page of at most q.PerPage data points, the size of the page and the cursor of the next page, if any
+	Query(q Query, sources ...*registry.DataStream) (senml.Pack, int, *Cursor, error)
+
+	// Streams the data points of the specified data sources in the requested order, without pagination (q.PerPage is ignored)
+	// The stream starts at the cursor of the query, if given
+	// The records channel is closed at the end of the query and the error channel then returns the error, if any
+	// Cancelling the context stops the query
+	QueryStream(ctx context.Context, q Query, sources ...*registry.DataStream) (<-chan senml.Record, <-chan error)
+
+	// Counts the data points of the specified data sources and plans the pages of the query
+	// Returns the cursors of the pages in the order in which they are reached with next links, and the total number
+	// of data points. The cursor of the query is ignored.
+	Pages(q Query, sources ...*registry.DataStream) ([]Cursor, int, error)
+
+	// Deletes the data points of a data source within the given time range (inclusive)
+	// Returns the number of deleted data points
+	Delete(ds *registry.DataStream, from, to time.Time) (int, error)
+
+	// Returns the ingestion events (duplicate and out-of-order records) of a data source, for the records within the
+	// given time range (inclusive), in time order
+	IngestionEvents(ds *registry.DataStream, from, to time.Time) ([]IngestionEvent, error)
 
 	// EventListener includes methods for event handling
 	registry.EventListener
@@ -61,7 +61,7 @@ func BenchmarkCreation_OneSeries(b *testing.B) {
 	recordmap := make(map[string]senml.Pack)
 	recordmap[datastream.Name] = records
 	b.StartTimer()
-	err = dataStorage.Submit(recordmap, registrymap)
+	_, err = dataStorage.Submit(recordmap, registrymap)
 	//err = dataClient.Submit(barr, , datastream.Name)
 	if err != nil {
 		b.Error("Insetion failed")
@@ -99,7 +99,7 @@ func BenchmarkCreation_OneSeriesTestGroup(b *testing.B) {
 	registrymap[datastream.Name] = &datastream
 	recordmap := make(map[string]senml.Pack)
 	recordmap[datastream.Name] = records
-	err = dataStorage.Submit(recordmap, registrymap)
+	_, err = dataStorage.Submit(recordmap, registrymap)
 	//err = dataClient.Submit(barr, , datastream.Name)
 	if err != nil {
 		b.Error("Insetion failed", err)
@@ -137,7 +137,7 @@ func benchmarkInsertEnd(b *testing.B, storage Storage, timeStart float64, timeEn
 		recordmap := make(map[string]senml.Pack)
 		recordmap[seriesName] = insrecords
 		b.StartTimer()
-		err := storage.Submit(recordmap, registrymap)
+		_, err := storage.Submit(recordmap, registrymap)
 		if err != nil {
 			b.Error("insetion failed", err)
 		}
@@ -157,7 +157,7 @@ func benchmarkInsertRandom(b *testing.B, storage Storage, timeStart float64, tim
 		recordmap := make(map[string]senml.Pack)
 		recordmap[seriesName] = insrecords
 		b.StartTimer()
-		err := storage.Submit(recordmap, registrymap)
+		_, err := storage.Submit(recordmap, registrymap)
 		if err != nil {
 			b.Error("insetion failed", err)
 		}
@@ -207,7 +207,7 @@ func BenchmarkCreation_MultiSeries(b *testing.B) {
 		recordmap[datastream.Name] = records
 	}
 	b.StartTimer()
-	err = dataStorage.Submit(recordmap, registrymap)
+	_, err = dataStorage.Submit(recordmap, registrymap)
 	//err = dataClient.Submit(barr, , datastream.Name)
 	if err != nil {
 		b.Error("Insetion failed")
@@ -243,7 +243,7 @@ func BenchmarkCreation_MultiSeriesTestGroup(b *testing.B) {
 		registrymap[datastream.Name] = &datastream
 		recordmap[datastream.Name] = newrecords
 	}
-	err = dataStorage.Submit(recordmap, registrymap)
+	_, err = dataStorage.Submit(recordmap, registrymap)
 	//err = dataClient.Submit(barr, , datastream.Name)
 	if err != nil {
 		b.Fatal("Insetion failed", err)
@@ -287,7 +287,7 @@ func benchmarkCreateNewSeries(b *testing.B, storage Storage) {
 
 	}
 	b.StartTimer()
-	err := storage.Submit(recordmap, registrymap)
+	_, err := storage.Submit(recordmap, registrymap)
 	if err != nil {
 		b.Fatal("Error creating:", err)
 	}
@@ -309,7 +309,7 @@ func benchmarkDeleteSeries(b *testing.B, storage Storage) {
 		registrymap[datastream.Name] = &datastream
 		recordmap[datastream.Name] = newrecords
 	}
-	err := storage.Submit(recordmap, registrymap)
+	_, err := storage.Submit(recordmap, registrymap)
 	if err != nil {
 		b.Fatal("Error creating:", err)
 	}
@@ -0,0 +1,142 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"github.com/farshidtz/senml"
+)
+
+// recordWriter writes the records of a streamed response one by one
+type recordWriter interface {
+	begin() error
+	write(r senml.Record) error
+	end(took time.Duration) error
+}
+
+// streamQuery writes the records of the query as they are read from the storage, without pagination.
+// Once the response has started, errors can no longer be reported with a status code. The response is then left
+// incomplete (e.g. invalid JSON) so that clients can recognize the failure.
+func (api *API) streamQuery(w http.ResponseWriter, r *http.Request, q Query, format, selfLink string, sources []*registry.DataStream) {
+	timeStart := time.Now()
+
+	var writer recordWriter
+	switch format {
+	case common.FormatNDJSON:
+		w.Header().Add("Content-Type", common.NDJSONMIMEType)
+		writer = &ndjsonWriter{w: w}
+	case common.FormatCSV:
+		w.Header().Add("Content-Type", common.CSVMIMEType)
+		writer = &csvStreamWriter{w: csv.NewWriter(w)}
+	case common.FormatJSON:
+		w.Header().Add("Content-Type", common.DefaultMIMEType)
+		writer = &recordSetWriter{w: w, selfLink: selfLink}
+	default:
+		common.ErrorResponse(http.StatusBadRequest, fmt.Sprintf("Streaming is not supported for %s", format), w)
+		return
+	}
+
+	// the query is cancelled when the client goes away
+	records, errs := api.storage.QueryStream(r.Context(), q, sources...)
+
+	w.WriteHeader(http.StatusOK)
+	err := writer.begin()
+	for record := range records {
+		if err != nil {
+			continue // drain the records
+		}
+		err = writer.write(record)
+	}
+	if queryErr := <-errs; queryErr != nil {
+		log.Printf("Error streaming data from the database: %s", queryErr)
+		return
+	}
+	if err == nil {
+		err = writer.end(time.Since(timeStart))
+	}
+	if err != nil {
+		log.Printf("Error writing streamed response: %s", err)
+	}
+}
+
+// ndjsonWriter writes one SenML record per line
+type ndjsonWriter struct {
+	w http.ResponseWriter
+}
+
+func (nw *ndjsonWriter) begin() error {
+	return nil
+}
+
+func (nw *ndjsonWriter) write(r senml.Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = nw.w.Write(append(b, '\n'))
+	return err
+}
+
+func (nw *ndjsonWriter) end(took time.Duration) error {
+	return nil
+}
+
+// csvStreamWriter writes the same columns as writeCSV
+type csvStreamWriter struct {
+	w *csv.Writer
+}
+
+func (cw *csvStreamWriter) begin() error {
+	return cw.w.Write(csvHeader)
+}
+
+func (cw *csvStreamWriter) write(r senml.Record) error {
+	return cw.w.Write(csvRow(r))
+}
+
+func (cw *csvStreamWriter) end(took time.Duration) error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// recordSetWriter writes a RecordSet in chunks. The data is written as it comes and the time is added at the end.
+type recordSetWriter struct {
+	w        http.ResponseWriter
+	selfLink string
+	count    int
+}
+
+func (rw *recordSetWriter) begin() error {
+	selfLink, err := json.Marshal(rw.selfLink)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(rw.w, `{"selfLink":%s,"data":[`, selfLink)
+	return err
+}
+
+func (rw *recordSetWriter) write(r senml.Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if rw.count > 0 {
+		b = append([]byte{','}, b...)
+	}
+	rw.count++
+	_, err = rw.w.Write(b)
+	return err
+}
+
+func (rw *recordSetWriter) end(took time.Duration) error {
+	_, err := fmt.Fprintf(rw.w, `],"took":%v,"nextLink":""}`, took.Seconds())
+	return err
+}
@@ -0,0 +1,127 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"github.com/farshidtz/senml"
+)
+
+// table lays out records in rows of a time with a cell per data stream
+type table struct {
+	names []string
+	times []float64
+	cells [][]*senml.Record
+}
+
+// newTable lays out the records, which are ordered by time, with a column per data stream
+// A new row is started whenever the time changes, or the cell of the data stream is taken by a record at the same time.
+func newTable(pack senml.Pack, sources []*registry.DataStream) *table {
+	t := &table{names: make([]string, len(sources))}
+	columns := make(map[string]int, len(sources))
+	for i, ds := range sources {
+		t.names[i] = ds.Name
+		columns[ds.Name] = i
+	}
+	for i := range pack {
+		column, found := columns[pack[i].Name]
+		if !found {
+			continue
+		}
+		last := len(t.times) - 1
+		if last < 0 || t.times[last] != pack[i].Time || t.cells[last][column] != nil {
+			t.times = append(t.times, pack[i].Time)
+			t.cells = append(t.cells, make([]*senml.Record, len(sources)))
+			last++
+		}
+		t.cells[last][column] = &pack[i]
+	}
+	return t
+}
+
+// columns returns the time followed by the names of the data streams
+func (t *table) columns() []string {
+	return append([]string{"time"}, t.names...)
+}
+
+// rows returns the rows of SenML time followed by the values, which are nil for the empty cells
+func (t *table) rows() [][]interface{} {
+	rows := make([][]interface{}, len(t.times))
+	for i, cells := range t.cells {
+		row := make([]interface{}, 1+len(cells))
+		row[0] = t.times[i]
+		for j, r := range cells {
+			if r != nil {
+				row[1+j] = recordValue(*r)
+			}
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+// recordValue returns any of the SenML values of a record (v, vs, vb, vd or s), or nil
+func recordValue(r senml.Record) interface{} {
+	switch {
+	case r.Value != nil:
+		return *r.Value
+	case r.StringValue != "":
+		return r.StringValue
+	case r.BoolValue != nil:
+		return *r.BoolValue
+	case r.DataValue != "":
+		return r.DataValue
+	case r.Sum != nil:
+		return *r.Sum
+	}
+	return nil
+}
+
+// writeTableCSV writes the table as CSV, with the time in RFC3339 format and the empty cells without value
+// Links to other pages are sent in a Link header.
+func writeTableCSV(w http.ResponseWriter, t *table, nextLink string) {
+	var buf bytes.Buffer
+	err := t.writeCSV(&buf)
+	if err != nil {
+		common.ErrorResponse(http.StatusInternalServerError, "Error encoding table: "+err.Error(), w)
+		return
+	}
+
+	if nextLink != "" {
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextLink))
+	}
+	w.Header().Add("Content-Type", common.CSVMIMEType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+func (t *table) writeCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write(t.columns())
+	if err != nil {
+		return err
+	}
+	for i, cells := range t.cells {
+		row := make([]string, 1+len(cells))
+		row[0] = time.Unix(0, int64(t.times[i]*1e9)).UTC().Format(time.RFC3339Nano)
+		for j, r := range cells {
+			if r != nil {
+				row[1+j] = csvValue(*r)
+			}
+		}
+		err = writer.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
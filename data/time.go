@@ -0,0 +1,117 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// senmlRelativeLimit is the SenML time (2^28 seconds) below which times are relative to now
+	senmlRelativeLimit = 1 << 28
+	// epochMillisLimit is the epoch value above which times are taken as milliseconds (year 5138 in seconds)
+	epochMillisLimit = 1e11
+)
+
+// relativeTime matches now, followed by any offsets and an optional rounding, e.g. now-7d/d or now-1h-30m
+var relativeTime = regexp.MustCompile(`^now((?:[+-]\d+(?:ms|s|m|h|d|w|M|y))*)(?:/(s|m|h|d|w|M|y))?$`)
+var relativeOffset = regexp.MustCompile(`([+-])(\d+)(ms|s|m|h|d|w|M|y)`)
+
+// parseTime parses a time argument, which is one of:
+//   - an RFC3339 time, e.g. 2018-11-24T11:56:51Z
+//   - a time relative to now, e.g. now-24h, or now-7d/d for the start of the day a week ago.
+//     The units are ms, s, m (minute), h, d, w, M (month) and y. The rounding is down to the start of the unit, in UTC.
+//   - a Unix time in seconds (e.g. 1543059346.5) or milliseconds (e.g. 1543059346500)
+//   - a SenML relative time, i.e. seconds relative to now below 2^28, e.g. -3600 for an hour ago
+func parseTime(value string) (time.Time, error) {
+	return parseTimeAt(value, time.Now().UTC())
+}
+
+// parseTimeAt parses a time argument relative to the given time
+func parseTimeAt(value string, now time.Time) (time.Time, error) {
+	if m := relativeTime.FindStringSubmatch(value); m != nil {
+		t := now.UTC()
+		for _, offset := range relativeOffset.FindAllStringSubmatch(m[1], -1) {
+			n, err := strconv.Atoi(offset[2])
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid offset %s: %s", offset[0], err)
+			}
+			if offset[1] == "-" {
+				n = -n
+			}
+			t = addTimeUnits(t, n, offset[3])
+		}
+		if m[2] != "" {
+			t = truncateTime(t, m[2])
+		}
+		return t, nil
+	}
+
+	if f, err := strconv.ParseFloat(value, 64); err == nil && !strings.ContainsAny(value, "eEnN") {
+		switch {
+		case f < senmlRelativeLimit:
+			return now.UTC().Add(time.Duration(f * float64(time.Second))), nil
+		case f >= epochMillisLimit:
+			return time.Unix(0, int64(f*float64(time.Millisecond))).UTC(), nil
+		default:
+			sec, frac := math.Modf(f)
+			return time.Unix(int64(sec), int64(math.Round(frac*1e9))).UTC(), nil
+		}
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s is neither an RFC3339 time, a relative time (e.g. now-24h), nor a Unix time", value)
+	}
+	return t, nil
+}
+
+// addTimeUnits adds n times the unit to t
+func addTimeUnits(t time.Time, n int, unit string) time.Time {
+	switch unit {
+	case "ms":
+		return t.Add(time.Duration(n) * time.Millisecond)
+	case "s":
+		return t.Add(time.Duration(n) * time.Second)
+	case "m":
+		return t.Add(time.Duration(n) * time.Minute)
+	case "h":
+		return t.Add(time.Duration(n) * time.Hour)
+	case "d":
+		return t.AddDate(0, 0, n)
+	case "w":
+		return t.AddDate(0, 0, 7*n)
+	case "M":
+		return t.AddDate(0, n, 0)
+	case "y":
+		return t.AddDate(n, 0, 0)
+	}
+	return t
+}
+
+// truncateTime rounds t down to the start of the unit. Weeks start on Monday.
+func truncateTime(t time.Time, unit string) time.Time {
+	switch unit {
+	case "s":
+		return t.Truncate(time.Second)
+	case "m":
+		return t.Truncate(time.Minute)
+	case "h":
+		return t.Truncate(time.Hour)
+	case "d":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	case "w":
+		days := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-days, 0, 0, 0, 0, t.Location())
+	case "M":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	case "y":
+		return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location())
+	}
+	return t
+}
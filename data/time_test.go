@@ -0,0 +1,46 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	// a Thursday
+	now := time.Date(2018, 11, 29, 13, 45, 30, 500e6, time.UTC)
+	for value, expected := range map[string]time.Time{
+		"2018-11-24T11:56:51Z":         time.Date(2018, 11, 24, 11, 56, 51, 0, time.UTC),
+		"2018-11-24T11:56:51.25+01:00": time.Date(2018, 11, 24, 10, 56, 51, 250e6, time.UTC),
+		"now":                          now,
+		"now-24h":                      now.Add(-24 * time.Hour),
+		"now-1h-30m":                   now.Add(-90 * time.Minute),
+		"now+500ms":                    now.Add(500 * time.Millisecond),
+		"now-7d/d":                     time.Date(2018, 11, 22, 0, 0, 0, 0, time.UTC),
+		"now/w":                        time.Date(2018, 11, 26, 0, 0, 0, 0, time.UTC),
+		"now-1M/M":                     time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC),
+		"now/y":                        time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		"now/h":                        time.Date(2018, 11, 29, 13, 0, 0, 0, time.UTC),
+		"1543059346":                   time.Date(2018, 11, 24, 11, 35, 46, 0, time.UTC),
+		"1543059346.5":                 time.Date(2018, 11, 24, 11, 35, 46, 500e6, time.UTC),
+		"1543059346500":                time.Date(2018, 11, 24, 11, 35, 46, 500e6, time.UTC),
+		"-3600":                        now.Add(-time.Hour),
+		"0":                            now,
+	} {
+		parsed, err := parseTimeAt(value, now)
+		if err != nil {
+			t.Errorf("%s: %s", value, err)
+			continue
+		}
+		if !parsed.Equal(expected) {
+			t.Errorf("%s: expected %s, got %s", value, expected, parsed)
+		}
+	}
+
+	for _, value := range []string{"", "yesterday", "now-", "now-1x", "now/ms", "NaN", "2018-11-24"} {
+		if _, err := parseTimeAt(value, now); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"fmt"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	datastore "github.com/dschowta/senml.datastore"
+	"github.com/farshidtz/senml"
+)
+
+// IngestionTracker is notified of the data streams which received data, e.g. a registry.Tracker
+type IngestionTracker interface {
+	Ingested(names []string, at time.Time)
+	// Seed sets the time of the last ingestion of a data stream which has not received data since the start
+	Seed(name string, at time.Time)
+}
+
+// TrackingStorage is a data storage which reports the data streams of the stored data to an ingestion tracker
+// A data stream is reported once its records are stored, and only if it has any, at the time of storage rather than
+// at the times of its records.
+type TrackingStorage struct {
+	Storage
+	tracker IngestionTracker
+}
+
+// NewTrackingStorage returns a storage which reports the data stored in the given storage to the tracker
+func NewTrackingStorage(storage Storage, tracker IngestionTracker) *TrackingStorage {
+	return &TrackingStorage{
+		Storage: storage,
+		tracker: tracker,
+	}
+}
+
+// Submit stores the data and then reports its data streams
+func (s *TrackingStorage) Submit(data map[string]senml.Pack, sources map[string]*registry.DataStream) (Duplicates, error) {
+	duplicates, err := s.Storage.Submit(data, sources)
+	if err == nil {
+		s.track(data)
+	}
+	return duplicates, err
+}
+
+// SubmitPartial stores the data and then reports its data streams, including those of which all records were left out
+func (s *TrackingStorage) SubmitPartial(data map[string]senml.Pack, sources map[string]*registry.DataStream) (Duplicates, error) {
+	duplicates, err := s.Storage.SubmitPartial(data, sources)
+	if err == nil {
+		s.track(data)
+	}
+	return duplicates, err
+}
+
+// Enqueue queues the data for storage, if the storage supports it, and reports its data streams once stored
+// Otherwise, it stores the data right away.
+func (s *TrackingStorage) Enqueue(data map[string]senml.Pack, sources map[string]*registry.DataStream, done func(Duplicates, error)) error {
+	enqueuer, ok := s.Storage.(Enqueuer)
+	if !ok {
+		done(s.Submit(data, sources))
+		return nil
+	}
+	return enqueuer.Enqueue(data, sources, func(duplicates Duplicates, err error) {
+		if err == nil {
+			s.track(data)
+		}
+		done(duplicates, err)
+	})
+}
+
+// Seed reports the time of the latest stored record of each data stream of the registry to the tracker, as the data
+// streams have been ingested at least until then. Records in the future are left out.
+func (s *TrackingStorage) Seed(reg registry.Storage) error {
+	for page := 1; ; page++ {
+		streams, total, err := reg.GetMany(page, registry.MaxPerPage)
+		if err != nil {
+			return fmt.Errorf("error getting data streams: %s", err)
+		}
+		for i := range streams {
+			now := time.Now().UTC()
+			latest, _, _, err := s.Storage.Query(Query{To: now, Sort: common.DESC, Limit: 1, PerPage: 1}, &streams[i])
+			if err != nil {
+				return fmt.Errorf("error querying the latest record of %s: %s", streams[i].Name, err)
+			}
+			if len(latest) > 0 {
+				s.tracker.Seed(streams[i].Name, datastore.FromSenmlTime(latest[0].Time))
+			}
+		}
+		if page*registry.MaxPerPage >= total {
+			return nil
+		}
+	}
+}
+
+func (s *TrackingStorage) track(data map[string]senml.Pack) {
+	names := make([]string, 0, len(data))
+	for name, records := range data {
+		if len(records) > 0 {
+			names = append(names, name)
+		}
+	}
+	s.tracker.Ingested(names, time.Now())
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package data
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	datastore "github.com/dschowta/senml.datastore"
+	"github.com/gorilla/mux"
+)
+
+func TestTrackingStorage(t *testing.T) {
+	tracker := registry.NewTracker()
+	queue, err := NewWriteQueue(&dummyDataStorage{}, common.QueueConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+	storage := NewTrackingStorage(queue, tracker)
+	regStorage := tracker.Wrap(registry.NewMemoryStorage(common.RegConf{}, tracker, storage))
+	submitted := &registry.DataStream{Name: "test/tracking/http", Type: common.FLOAT}
+	enqueued := &registry.DataStream{Name: "test/tracking/mqtt", Type: common.FLOAT}
+	for _, ds := range []*registry.DataStream{submitted, enqueued} {
+		_, err := regStorage.Add(*ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the HTTP API submits the data
+	api := NewAPI(regStorage, storage, false, nil)
+	r := mux.NewRouter().StrictSlash(true).SkipClean(true)
+	r.Methods("POST").Path("/data/{id:.+}").HandlerFunc(api.Submit)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	submitLive(t, ts.URL, submitted.Name, 1)
+
+	// the MQTT connector enqueues the data
+	stored := make(chan error, 1)
+	data, sources := queuedRecord(enqueued, 1543059346)
+	err = storage.Enqueue(data, sources, func(_ Duplicates, err error) {
+		stored <- err
+	})
+	if err == nil {
+		err = <-stored
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ds := range []*registry.DataStream{submitted, enqueued} {
+		tracked, err := regStorage.Get(ds.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tracked.LastIngestion == nil {
+			t.Errorf("%s: expected the time of the last ingestion", ds.Name)
+		}
+	}
+}
+
+func TestTrackingStorageSeed(t *testing.T) {
+	backend, teardown := setupLightdbStorage(t)
+	defer teardown()
+	tracker := registry.NewTracker()
+	regStorage := tracker.Wrap(registry.NewMemoryStorage(common.RegConf{}, tracker, backend))
+	stored := &registry.DataStream{Name: "test/tracking/stored", Type: common.FLOAT}
+	empty := &registry.DataStream{Name: "test/tracking/empty", Type: common.FLOAT}
+	for _, ds := range []*registry.DataStream{stored, empty} {
+		_, err := regStorage.Add(*ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	const start = 1543059346.0
+	submitSeries(t, backend, stored, start, 1, 3)
+
+	// the storage is seeded after a restart, without any ingestion since then
+	storage := NewTrackingStorage(backend, tracker)
+	err := storage.Seed(regStorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := regStorage.Get(stored.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := datastore.FromSenmlTime(start + 2); ds.LastIngestion == nil || !ds.LastIngestion.Equal(expected) {
+		t.Errorf("Expected the last ingestion at the latest record %s, got %v", expected, ds.LastIngestion)
+	}
+	ds, err = regStorage.Get(empty.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.LastIngestion != nil {
+		t.Errorf("Expected no ingestion of a data stream without records, got %v", ds.LastIngestion)
+	}
+}
@@ -20,5 +20,11 @@ require (
 	github.com/rs/cors v1.6.0
 	github.com/satori/go.uuid v1.1.0
 	github.com/syndtr/goleveldb v1.0.0
+	golang.org/x/net v0.0.0-20180906233101-161cd47e91fd
 	golang.org/x/sys v0.0.0-20190322080309-f49334f85ddc // indirect
 )
+
+replace (
+	github.com/dschowta/lite.tsdb => ./third_party/lite.tsdb
+	github.com/dschowta/senml.datastore => ./third_party/senml.datastore
+)
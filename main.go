@@ -3,6 +3,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -12,9 +13,11 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"time"
 
 	_ "code.linksmart.eu/com/go-sec/auth/keycloak/validator"
 	"code.linksmart.eu/com/go-sec/auth/validator"
+	"code.linksmart.eu/hds/historical-datastore/aggregation"
 	"code.linksmart.eu/hds/historical-datastore/common"
 	"code.linksmart.eu/hds/historical-datastore/data"
 	"code.linksmart.eu/hds/historical-datastore/registry"
@@ -27,6 +30,9 @@ const LINKSMART = `
 ╩═╝ ╩ ╝╚╝ ╩ ╩  ╚═╝ ╩ ╩ ╩ ╩ ╩╚═  ╩
 `
 
+// shutdownTimeout is the maximum time for the HTTP requests in progress to complete on shutdown
+const shutdownTimeout = 10 * time.Second
+
 var (
 	confPath    = flag.String("conf", "conf/historical-datastore.json", "Historical Datastore configuration file path")
 	profile     = flag.Bool("profile", false, "Enable the HTTP server for runtime profiling")
@@ -62,10 +68,27 @@ func main() {
 		log.Printf("Service ID not set. Generated new UUID: %s", conf.ServiceID)
 	}
 
+	// Stale-stream detection, notified by all ingestion paths
+	var notifiers []registry.Notifier
+	if conf.Reg.Stale.Webhook != "" {
+		notifiers = append(notifiers, registry.NewWebhookNotifier(conf.Reg.Stale.Webhook))
+	}
+	if conf.Reg.Stale.MQTT != nil {
+		notifier, err := registry.NewMQTTNotifier(*conf.Reg.Stale.MQTT, conf.ServiceID)
+		if err != nil {
+			log.Fatalf("Error creating stale-stream notifier: %s", err)
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	staleTracker := registry.NewTracker(notifiers...)
+
 	// Setup data and aggregation backends
 	var (
-		dataStorage data.Storage
-		//aggrStorage aggregation.Storage
+		dataStorage     data.Storage
+		aggrStorage     aggregation.Storage
+		writeQueue      *data.WriteQueue
+		trackingStorage *data.TrackingStorage
+		liveStorage     *data.LiveStorage
 	)
 	switch conf.Data.Backend.Type {
 	case data.SENMLSTORE:
@@ -75,12 +98,24 @@ func main() {
 			log.Fatalf("Error creating senml storage: %s", err)
 		}
 		defer disconnect_func()
+		// store the data from all ingestion paths in group commits
+		writeQueue, err = data.NewWriteQueue(dataStorage, conf.Data.Queue)
+		if err != nil {
+			log.Fatalf("Error creating write queue: %s", err)
+		}
+		// notify live subscribers and track the ingestion of the data from all ingestion paths
+		trackingStorage = data.NewTrackingStorage(writeQueue, staleTracker)
+		liveStorage = data.NewLiveStorage(trackingStorage)
+		dataStorage = liveStorage
+		aggrStorage = aggregation.NewDataStorage(dataStorage)
 	}
 	if conf.Data.AutoRegistration {
 		log.Println("Auto Registration is enabled: Data HTTP API will automatically create new data sources.")
 	}
+	// Rejected payloads of all ingestion paths
+	quarantine := data.NewQuarantine(conf.Data.QuarantineSize)
 	// MQTT connector
-	mqttConn, err := data.NewMQTTConnector(dataStorage, conf.ServiceID)
+	mqttConn, err := data.NewMQTTConnector(dataStorage, conf.ServiceID, quarantine)
 	if err != nil {
 		log.Fatalf("Error creating MQTT Connector: %s", err)
 	}
@@ -90,28 +125,45 @@ func main() {
 		regStorage registry.Storage
 		closeReg   func() error
 	)
+	// the cache of the data streams looked up by the ingestion paths is invalidated by the registry events
+	regCache := registry.NewCache()
 	switch conf.Reg.Backend.Type {
 	case registry.MEMORY:
-		regStorage = registry.NewMemoryStorage(conf.Reg, dataStorage, mqttConn)
+		regStorage = registry.NewMemoryStorage(conf.Reg, regCache, staleTracker, dataStorage, mqttConn)
 	case registry.LEVELDB:
-		regStorage, closeReg, err = registry.NewLevelDBStorage(conf.Reg, nil, dataStorage, mqttConn)
+		regStorage, closeReg, err = registry.NewLevelDBStorage(conf.Reg, nil, regCache, staleTracker, dataStorage, mqttConn)
 		if err != nil {
 			log.Fatalf("Failed to start LevelDB: %s\n", err)
 		}
 	}
+	regStorage = staleTracker.Wrap(regCache.Wrap(regStorage))
 
 	// Setup APIs
 	regAPI := registry.NewAPI(regStorage)
-	dataAPI := data.NewAPI(regStorage, dataStorage, conf.Data.AutoRegistration)
-	//aggrAPI := aggregation.NewAPI(regStorage, aggrStorage)
+	dataAPI := data.NewAPI(regStorage, dataStorage, conf.Data.AutoRegistration, quarantine)
+	aggrAPI := aggregation.NewAPI(regStorage, aggrStorage)
 
 	// Start MQTT connector
-	// TODO: disconnect on shutdown
 	err = mqttConn.Start(regStorage)
 	if err != nil {
 		log.Fatalf("Error starting MQTT Connector: %s", err)
 	}
 
+	// Enforce the maximum retention of data streams
+	purgeInterval, _ := common.ParsePeriod(conf.Data.PurgeInterval)
+	retentionWorker := data.NewRetentionWorker(regStorage, dataStorage, purgeInterval)
+	stopRetention := retentionWorker.Start()
+
+	// Detect the data streams which receive no data within their expected interval, since their latest stored records
+	if trackingStorage != nil {
+		err = trackingStorage.Seed(regStorage)
+		if err != nil {
+			log.Printf("Error seeding the stale-stream detection: %s", err)
+		}
+	}
+	staleInterval, _ := time.ParseDuration(conf.Reg.Stale.CheckInterval)
+	stopStale := staleTracker.Start(regStorage, staleInterval)
+
 	// Register in the LinkSmart Service Catalog
 	if conf.ServiceCatalog != nil {
 		unregisterService, err := registerInServiceCatalog(conf)
@@ -123,7 +175,11 @@ func main() {
 	}
 
 	// Start servers
-	go startHTTPServer(conf, regAPI, dataAPI)
+	httpServer := startHTTPServer(conf, regAPI, dataAPI, aggrAPI, retentionWorker)
+	if liveStorage != nil {
+		// the live subscriptions never end by themselves
+		httpServer.RegisterOnShutdown(liveStorage.Close)
+	}
 	go startWebServer(conf)
 
 	// Ctrl+C / Kill handling
@@ -132,6 +188,19 @@ func main() {
 
 	<-handler
 	log.Println("Shutting down...")
+	// stop the ingestion, then store the queued data
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	err = httpServer.Shutdown(ctx)
+	cancel()
+	if err != nil {
+		log.Printf("Error shutting down the HTTP server: %s", err)
+	}
+	mqttConn.Stop()
+	stopRetention()
+	stopStale()
+	if writeQueue != nil {
+		writeQueue.Close()
+	}
 
 	// Close the DataStreamList Storage
 	if closeReg != nil {
@@ -144,7 +213,8 @@ func main() {
 	log.Println("Stopped.")
 }
 
-func startHTTPServer(conf *common.Config, reg *registry.API, data *data.API) {
+// startHTTPServer starts serving the APIs and returns the server to be shut down
+func startHTTPServer(conf *common.Config, reg *registry.API, data *data.API, aggr *aggregation.API, retention *data.RetentionWorker) *http.Server {
 	router := newRouter()
 	// api root
 	router.handle(http.MethodGet, "/", indexHandler)
@@ -160,7 +230,34 @@ func startHTTPServer(conf *common.Config, reg *registry.API, data *data.API) {
 	// data api
 	router.handle(http.MethodPost, "/data", data.SubmitWithoutID)
 	router.handle(http.MethodPost, "/data/{id:.+}", data.Submit)
+	router.handle(http.MethodGet, "/data", data.Query)
+	router.handle(http.MethodHead, "/data", data.Count)
+	// the apis under the data of the data streams are matched before their data, the registry rejects data streams
+	// with names ending in these locations
+	// live api
+	router.handle(http.MethodGet, "/data"+common.LiveAPILoc, data.Live)
+	router.handle(http.MethodGet, "/data/{id:.+}"+common.LiveAPILoc, data.Live)
+	// latest api
+	router.handle(http.MethodGet, "/data"+common.LatestAPILoc, data.Latest)
+	router.handle(http.MethodGet, "/data/{id:.+}"+common.LatestAPILoc, data.Latest)
+	// quality api
+	router.handle(http.MethodGet, "/data/{id:.+}"+common.QualityAPILoc, data.Quality)
 	router.handle(http.MethodGet, "/data/{id:.+}", data.Query)
+	router.handle(http.MethodHead, "/data/{id:.+}", data.Count)
+	router.handle(http.MethodDelete, "/data/{id:.+}", data.Delete)
+
+	// the following apis are not located under /data, where they would hide the data streams of the same names
+	// quarantine api
+	router.handle(http.MethodGet, "/quarantine", data.QuarantineIndex)
+	router.handle(http.MethodGet, "/quarantine/{qid:[0-9]+}", data.QuarantineRetrieve)
+	router.handle(http.MethodPost, "/quarantine/{qid:[0-9]+}/replay", data.QuarantineReplay)
+	router.handle(http.MethodDelete, "/quarantine/{qid:[0-9]+}", data.QuarantineDelete)
+
+	// retention status
+	router.handle(http.MethodGet, "/retention", retention.StatusHandler)
+
+	// aggregation api
+	router.handle(http.MethodGet, "/aggr/{aggr_id}/{id:.+}", aggr.Query)
 	// Append auth handler if enabled
 	if conf.Auth.Enabled {
 		// Setup ticket validator
@@ -174,10 +271,17 @@ func startHTTPServer(conf *common.Config, reg *registry.API, data *data.API) {
 
 	// start http server
 	log.Printf("Listening on %s:%d", conf.HTTP.BindAddr, conf.HTTP.BindPort)
-	err := http.ListenAndServe(fmt.Sprintf("%s:%d", conf.HTTP.BindAddr, conf.HTTP.BindPort), router.chained())
-	if err != nil {
-		log.Fatalln(err)
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", conf.HTTP.BindAddr, conf.HTTP.BindPort),
+		Handler: router.chained(),
 	}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalln(err)
+		}
+	}()
+	return server
 }
 
 func startWebServer(conf *common.Config) {
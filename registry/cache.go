@@ -0,0 +1,123 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package registry
+
+import (
+	"sync"
+)
+
+// Cache keeps the data streams which are looked up by name, e.g. for every submitted record
+// It keeps deep copies and returns further copies of them, so that the callers may modify the returned data streams.
+// It is invalidated through the registry events and must therefore be one of the listeners of the cached storage.
+type Cache struct {
+	mutex   sync.RWMutex
+	streams map[string]DataStream
+	// generation is incremented on every invalidation, so that lookups which overlap an invalidation are not cached
+	generation uint64
+}
+
+// NewCache returns an empty cache
+func NewCache() *Cache {
+	return &Cache{
+		streams: make(map[string]DataStream),
+	}
+}
+
+// Wrap returns a storage which serves the lookups by name from the cache
+// Lookups which miss the cache are passed on to the given storage.
+func (c *Cache) Wrap(storage Storage) Storage {
+	return &cachedStorage{Storage: storage, cache: c}
+}
+
+func (c *Cache) get(name string) (*DataStream, uint64, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	ds, found := c.streams[name]
+	if !found {
+		return nil, c.generation, false
+	}
+	copied := ds.copy()
+	return &copied, c.generation, true
+}
+
+func (c *Cache) put(ds *DataStream, generation uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.generation == generation {
+		c.streams[ds.Name] = ds.copy()
+	}
+}
+
+func (c *Cache) invalidate(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.streams, name)
+	c.generation++
+}
+
+// CreateHandler invalidates the cached data stream of the same name, if any
+func (c *Cache) CreateHandler(new DataStream) error {
+	c.invalidate(new.Name)
+	return nil
+}
+
+// UpdateHandler invalidates the cached data stream
+func (c *Cache) UpdateHandler(old DataStream, new DataStream) error {
+	c.invalidate(old.Name)
+	return nil
+}
+
+// DeleteHandler invalidates the cached data stream
+func (c *Cache) DeleteHandler(old DataStream) error {
+	c.invalidate(old.Name)
+	return nil
+}
+
+// cachedStorage is a storage which serves the lookups by name from a cache
+type cachedStorage struct {
+	Storage
+	cache *Cache
+}
+
+func (s *cachedStorage) Get(name string) (*DataStream, error) {
+	ds, generation, found := s.cache.get(name)
+	if found {
+		return ds, nil
+	}
+	ds, err := s.Storage.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.put(ds, generation)
+	return ds, nil
+}
+
+func (s *cachedStorage) FilterOne(path, op, value string) (*DataStream, error) {
+	if path != "name" || op != "equals" {
+		return s.Storage.FilterOne(path, op, value)
+	}
+	ds, generation, found := s.cache.get(value)
+	if found {
+		return ds, nil
+	}
+	ds, err := s.Storage.FilterOne(path, op, value)
+	if err != nil || ds == nil {
+		return ds, err
+	}
+	s.cache.put(ds, generation)
+	return ds, nil
+}
+
+// Update and Delete invalidate the cache once more after the change, as the events precede the change in the storage
+func (s *cachedStorage) Update(name string, ds DataStream) (*DataStream, error) {
+	defer s.cache.invalidate(name)
+	return s.Storage.Update(name, ds)
+}
+
+func (s *cachedStorage) Delete(name string) error {
+	defer s.cache.invalidate(name)
+	return s.Storage.Delete(name)
+}
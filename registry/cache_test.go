@@ -0,0 +1,131 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package registry
+
+import (
+	"testing"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+)
+
+// lookupCounter counts the lookups which reach the storage
+type lookupCounter struct {
+	Storage
+	lookups int
+}
+
+func (s *lookupCounter) Get(name string) (*DataStream, error) {
+	s.lookups++
+	return s.Storage.Get(name)
+}
+
+func (s *lookupCounter) FilterOne(path, op, value string) (*DataStream, error) {
+	s.lookups++
+	return s.Storage.FilterOne(path, op, value)
+}
+
+func TestCache(t *testing.T) {
+	cache := NewCache()
+	counter := &lookupCounter{Storage: NewMemoryStorage(common.RegConf{}, cache)}
+	storage := cache.Wrap(counter)
+
+	ds := DataStream{Name: "any_url", Type: common.FLOAT, Meta: map[string]interface{}{"building": "B12"}}
+	_, err := storage.Add(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := storage.Get(ds.Name); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storage.FilterOne("name", "equals", ds.Name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if counter.lookups != 1 {
+		t.Errorf("Expected a single lookup in the storage, got %d", counter.lookups)
+	}
+
+	// other filters are not cached
+	if _, err := storage.FilterOne("meta.building", "equals", "B12"); err != nil {
+		t.Fatal(err)
+	}
+	if counter.lookups != 2 {
+		t.Errorf("Expected the filter to reach the storage, got %d lookups", counter.lookups)
+	}
+
+	ds.Meta = map[string]interface{}{"building": "B13"}
+	_, err = storage.Update(ds.Name, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := storage.Get(ds.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Meta["building"] != "B13" {
+		t.Errorf("Expected the updated data stream, got %+v", updated)
+	}
+
+	err = storage.Delete(ds.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Get(ds.Name); err == nil || !ErrType(err, ErrNotFound) {
+		t.Errorf("Expected the deleted data stream not to be found, got %v", err)
+	}
+
+	// changes which bypass the cached storage are seen through the events
+	_, err = counter.Add(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Get(ds.Name); err != nil {
+		t.Fatal(err)
+	}
+	ds.Meta = map[string]interface{}{"building": "B14"}
+	_, err = counter.Update(ds.Name, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err = storage.Get(ds.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Meta["building"] != "B14" {
+		t.Errorf("Expected the data stream updated in the storage, got %+v", updated)
+	}
+}
+
+func TestCacheCopies(t *testing.T) {
+	cache := NewCache()
+	storage := cache.Wrap(NewMemoryStorage(common.RegConf{}, cache))
+	ds := DataStream{
+		Name:        "any_url",
+		Type:        common.FLOAT,
+		Meta:        map[string]interface{}{"building": "B12", "rooms": []interface{}{"1.01"}},
+		Aggregation: []Aggregation{{Interval: "1h", Aggregates: []string{"mean"}}},
+	}
+	_, err := storage.Add(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Get(ds.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		cached, err := storage.Get(ds.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cached.Meta["building"] != "B12" || cached.Meta["rooms"].([]interface{})[0] != "1.01" || cached.Aggregation[0].Aggregates[0] != "mean" {
+			t.Fatalf("Lookup %d: expected the unmodified data stream, got %+v", i, cached)
+		}
+		// the callers may modify the returned data streams
+		cached.Meta["building"] = "B13"
+		cached.Meta["rooms"].([]interface{})[0] = "1.02"
+		cached.Aggregation[0].Aggregates[0] = "max"
+	}
+}
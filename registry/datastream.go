@@ -5,6 +5,10 @@ package registry
 
 import (
 	"encoding/json"
+	"strings"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
 )
 
 type SourceType string
@@ -28,13 +32,29 @@ type DataStream struct {
 	// Meta is a hash-map with optional meta-information
 	Meta map[string]interface{} `json:"meta,omitempty"`
 
+	// Aggregation is a list of continuous aggregations (rollups) of the data stream
+	Aggregation []Aggregation `json:"aggregation,omitempty"`
+
+	// Duplicates is the policy for records at the time of an existing record: overwrite (default), reject or keep
+	// Kept records are stored after the existing records at that time
+	Duplicates string `json:"duplicates,omitempty"`
+
 	// Retention
 	Retention struct {
 		//minimum requirement for the retention
 		Min string `json:"min,omitempty"`
 		//maximum requirement for the retention. This is useful for enforcing the data privacy
-		Max string `json:"max",omitempty`
+		Max string `json:"max,omitempty"`
 	} `json:"retain,omitempty"`
+
+	// Expected describes the expected records of the data stream, against which the data quality is reported
+	Expected *Expectation `json:"expected,omitempty"`
+
+	// LastIngestion is the time of the last submission of data, or of the latest stored record until the first submission
+	// since the start of the service (read-only)
+	LastIngestion *time.Time `json:"lastIngestion,omitempty"`
+	// Stale is true if no data was submitted within the expected interval (read-only)
+	Stale bool `json:"stale,omitempty"`
 	// DynamicChild TODO
 	keepSensitiveInfo bool
 }
@@ -54,7 +74,10 @@ type MQTTSource struct {
 	//Topic to subscribe for the datasource
 	Topic string `json:"topic"`
 	//QoS of subscription
-	QoS      byte   `json:"qos,omitempty"`
+	QoS byte `json:"qos,omitempty"`
+	//Media type of the SenML payloads (default application/senml+json)
+	//Sources which share a topic must use the same format
+	Format   string `json:"format,omitempty"`
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
 	CaFile   string `json:"caFile,omitempty"`
@@ -66,16 +89,93 @@ type MQTTSource struct {
 
 type SeriesSource struct {
 	//name of the series
-	URL string `json:name`
+	URL string `json:"name"`
+}
+
+// Aggregation describes the continuous aggregation of a data stream over fixed time intervals
+type Aggregation struct {
+	//Interval of the time buckets (e.g. 5m, 1h, 1d)
+	Interval string `json:"interval"`
+	//Aggregates computed for each time bucket (e.g. mean, min, max)
+	Aggregates []string `json:"aggregates"`
 }
 
+// Expectation describes the expected records of a data stream
+type Expectation struct {
+	//Interval between consecutive records (e.g. 10s, 5m, 1h). Longer gaps are reported as missing data
+	Interval string `json:"interval,omitempty"`
+	//Range of the valid values of float data streams (optional)
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// ID returns the identifier of the aggregation e.g. 1h-mean,max
+func (a Aggregation) ID() string {
+	return a.Interval + common.AggrIDSeparator + strings.Join(a.Aggregates, common.IDSeparator)
+}
+
+// copy returns a deep copy of the data stream, which shares no maps, slices or pointers with the original
 func (ds DataStream) copy() DataStream {
 	newDS := ds
-	newDS.Source = ds.Source
-	//copy(newDS.Sources, ds.Sources)
+	if ds.Source.MQTTSource != nil {
+		source := *ds.Source.MQTTSource
+		newDS.Source.MQTTSource = &source
+	}
+	if ds.Source.SeriesSource != nil {
+		source := *ds.Source.SeriesSource
+		newDS.Source.SeriesSource = &source
+	}
+	if ds.Meta != nil {
+		newDS.Meta = copyValue(ds.Meta).(map[string]interface{})
+	}
+	if ds.Aggregation != nil {
+		newDS.Aggregation = make([]Aggregation, len(ds.Aggregation))
+		for i, a := range ds.Aggregation {
+			newDS.Aggregation[i].Interval = a.Interval
+			if a.Aggregates != nil {
+				newDS.Aggregation[i].Aggregates = make([]string, len(a.Aggregates))
+				copy(newDS.Aggregation[i].Aggregates, a.Aggregates)
+			}
+		}
+	}
+	if ds.Expected != nil {
+		expected := *ds.Expected
+		if expected.Min != nil {
+			min := *expected.Min
+			expected.Min = &min
+		}
+		if expected.Max != nil {
+			max := *expected.Max
+			expected.Max = &max
+		}
+		newDS.Expected = &expected
+	}
+	if ds.LastIngestion != nil {
+		last := *ds.LastIngestion
+		newDS.LastIngestion = &last
+	}
 	return newDS
 }
 
+// copyValue returns a deep copy of a value decoded from JSON
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			copied[key] = copyValue(value)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, value := range v {
+			copied[i] = copyValue(value)
+		}
+		return copied
+	}
+	return v
+}
+
 // MarshalJSON masks sensitive information when using the default marshaller
 func (ds DataStream) MarshalJSON() ([]byte, error) {
 	if !ds.keepSensitiveInfo {
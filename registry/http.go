@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -46,6 +47,8 @@ func NewAPI(storage Storage) *API {
 // Handlers ///////////////////////////////////////////////////////////////////////
 
 // Index is a handler for the registry index
+// Optional parameters: page, perPage, stale (true for the data streams which receive no data within their expected
+// interval, false for the others)
 func (api *API) Index(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	//TODO: add nextLink
@@ -55,7 +58,18 @@ func (api *API) Index(w http.ResponseWriter, r *http.Request) {
 		lastModified = time.Now()
 	}
 
-	if r.Header.Get("If-Modified-Since") != "" {
+	var stale *bool
+	if r.Form.Get(common.ParamStale) != "" {
+		staleOnly, err := strconv.ParseBool(r.Form.Get(common.ParamStale))
+		if err != nil {
+			common.ErrorResponse(http.StatusBadRequest, "Error parsing stale argument: "+err.Error(), w)
+			return
+		}
+		stale = &staleOnly
+	}
+
+	// the staleness changes without modifications of the registry
+	if r.Header.Get("If-Modified-Since") != "" && stale == nil {
 		modifiedSince, err := time.Parse(time.RFC1123, r.Header.Get("If-Modified-Since"))
 		if err != nil {
 			common.ErrorResponse(http.StatusBadRequest, "Error parsing If-Modified-Since header: "+err.Error(), w)
@@ -74,7 +88,13 @@ func (api *API) Index(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	datasources, total, err := api.storage.GetMany(page, perPage)
+	var datasources []DataStream
+	var total int
+	if stale != nil {
+		datasources, total, err = api.staleStreams(*stale, page, perPage)
+	} else {
+		datasources, total, err = api.storage.GetMany(page, perPage)
+	}
 	if err != nil {
 		common.ErrorResponse(http.StatusInternalServerError, err.Error(), w)
 		return
@@ -96,6 +116,18 @@ func (api *API) Index(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// staleStreams returns a page of the data streams which are stale, or not stale
+// Staleness is only known if the storage is wrapped by a Tracker.
+func (api *API) staleStreams(stale bool, page, perPage int) ([]DataStream, int, error) {
+	if tracked, ok := api.storage.(*trackedStorage); ok {
+		return tracked.tracker.Streams(tracked, stale, page, perPage)
+	}
+	if stale {
+		return []DataStream{}, 0, nil
+	}
+	return api.storage.GetMany(page, perPage)
+}
+
 // Create is a handler for creating a new DataSource
 func (api *API) Create(w http.ResponseWriter, r *http.Request) {
 
@@ -126,6 +126,8 @@ func (s *LevelDBStorage) Update(name string, ds DataStream) (*DataStream, error)
 	tempDS.Retention = ds.Retention
 	tempDS.Source = ds.Source
 	tempDS.Meta = ds.Meta
+	tempDS.Aggregation = ds.Aggregation
+	tempDS.Expected = ds.Expected
 
 	// Send an update event
 	err = s.event.updated(oldDS, tempDS)
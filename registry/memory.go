@@ -85,6 +85,8 @@ func (ms *MemoryStorage) Update(id string, ds DataStream) (*DataStream, error) {
 	tempDS.Retention = ds.Retention
 	tempDS.Source = ds.Source
 	tempDS.Meta = ds.Meta
+	tempDS.Aggregation = ds.Aggregation
+	tempDS.Expected = ds.Expected
 
 	// Send an update event
 	err = ms.event.updated(oldDS, &tempDS)
@@ -41,7 +41,7 @@ func generateDummyData(quantity int, storage Storage) ([]string, error) {
 }
 
 func setupMemStorage() Storage {
-	return NewMemoryStorage(common.RegConf{})
+	return NewMemoryStorage(common.RegConf{RetentionPeriods: []string{"3h", "20w", "30w"}})
 }
 
 func TestMemstorageAdd(t *testing.T) {
@@ -65,6 +65,15 @@ func TestMemstorageAdd(t *testing.T) {
 	if !reflect.DeepEqual(addedDS, getDS) {
 		t.Fatalf("Mismatch added:\n%v\n and retrieved:\n%v\n", addedDS, getDS)
 	}
+
+	// the names must not end in the location of an API under the data of the data streams
+	for _, name := range []string{"live", "any_url/live", "any_url/latest", "any_url/quality"} {
+		ds.Name = name
+		_, err = storage.Add(ds)
+		if err == nil || !ErrType(err, ErrConflict) {
+			t.Errorf("Expected conflict for the name %s, got: %v", name, err)
+		}
+	}
 }
 
 func TestMemstorageGet(t *testing.T) {
@@ -96,6 +105,55 @@ func TestMemstorageUpdate(t *testing.T) {
 	if !reflect.DeepEqual(updatedDS, ds) {
 		t.Fatalf("Mismatch updated:\n%v\n and stored:\n%v\n", updatedDS, ds)
 	}
+
+	// the maximum retention must not be shorter than the minimum
+	ds.Retention.Min = "30w"
+	_, err = storage.Update(ID, *ds)
+	if err == nil || !ErrType(err, ErrConflict) {
+		t.Fatalf("Expected conflict for maximum retention shorter than minimum, got: %v", err)
+	}
+	ds.Retention.Min = ""
+
+	// the retention periods must be configured
+	ds.Retention.Max = "10w"
+	_, err = storage.Update(ID, *ds)
+	if err == nil || !ErrType(err, ErrConflict) {
+		t.Fatalf("Expected conflict for a maximum retention which is not configured, got: %v", err)
+	}
+	ds.Retention.Max = "20w"
+
+	// the duplicates policy must be known
+	ds.Duplicates = "ignore"
+	_, err = storage.Update(ID, *ds)
+	if err == nil || !ErrType(err, ErrConflict) {
+		t.Fatalf("Expected conflict for unknown duplicates policy, got: %v", err)
+	}
+	ds.Duplicates = common.DuplicatesKeep
+	_, err = storage.Update(ID, *ds)
+	if err != nil {
+		t.Fatalf("Unexpected error on update: %v", err.Error())
+	}
+
+	// the expected interval must be a duration and the expected range must not be empty
+	ds.Expected = &Expectation{Interval: "often"}
+	_, err = storage.Update(ID, *ds)
+	if err == nil || !ErrType(err, ErrConflict) {
+		t.Fatalf("Expected conflict for invalid expected interval, got: %v", err)
+	}
+	min, max := 10.0, 0.0
+	ds.Expected = &Expectation{Interval: "10s", Min: &min, Max: &max}
+	_, err = storage.Update(ID, *ds)
+	if err == nil || !ErrType(err, ErrConflict) {
+		t.Fatalf("Expected conflict for expected maximum less than minimum, got: %v", err)
+	}
+	ds.Expected = &Expectation{Interval: "10s"}
+	updatedDS, err = storage.Update(ID, *ds)
+	if err != nil {
+		t.Fatalf("Unexpected error on update: %v", err.Error())
+	}
+	if updatedDS.Expected == nil || updatedDS.Expected.Interval != "10s" {
+		t.Fatalf("Expected interval not updated: %v", updatedDS.Expected)
+	}
 }
 
 func TestMemstorageDelete(t *testing.T) {
@@ -0,0 +1,82 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// notifyTimeout bounds the delivery of an event
+const notifyTimeout = 10 * time.Second
+
+// WebhookNotifier posts the events as JSON to a URL
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier returns a notifier which posts the events to the given URL
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: notifyTimeout},
+	}
+}
+
+func (n *WebhookNotifier) Notify(e StaleEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	res, err := n.client.Post(n.url, common.DefaultMIMEType, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded with %s", n.url, res.Status)
+	}
+	return nil
+}
+
+// MQTTNotifier publishes the events as JSON to an MQTT topic
+type MQTTNotifier struct {
+	client paho.Client
+	topic  string
+	qos    byte
+}
+
+// NewMQTTNotifier returns a notifier which publishes the events to the configured broker
+func NewMQTTNotifier(conf common.StaleMQTTConf, clientID string) (*MQTTNotifier, error) {
+	opts := paho.NewClientOptions() // uses defaults: https://godoc.org/github.com/eclipse/paho.mqtt.golang#NewClientOptions
+	opts.AddBroker(conf.BrokerURL)
+	opts.SetClientID(fmt.Sprintf("HDS-stale-%s", clientID))
+	if conf.Username != "" {
+		opts.SetUsername(conf.Username)
+		opts.SetPassword(conf.Password)
+	}
+	client := paho.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return nil, fmt.Errorf("MQTT: Error connecting to broker %v: %v", conf.BrokerURL, token.Error())
+	}
+	return &MQTTNotifier{client: client, topic: conf.Topic, qos: conf.QoS}, nil
+}
+
+func (n *MQTTNotifier) Notify(e StaleEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	token := n.client.Publish(n.topic, n.qos, false, b)
+	if !token.WaitTimeout(notifyTimeout) {
+		return fmt.Errorf("MQTT: timeout publishing to %s", n.topic)
+	}
+	return token.Error()
+}
@@ -0,0 +1,389 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package registry
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+)
+
+// DefaultStaleCheckInterval is the default period of checking the data streams for staleness
+const DefaultStaleCheckInterval = time.Minute
+
+// staleEventsBuffer is the number of events which wait for the notifiers before further events are dropped
+const staleEventsBuffer = 1000
+
+const (
+	// EventStale is emitted when a data stream receives no data within its expected interval
+	EventStale = "stale"
+	// EventResumed is emitted when a stale data stream receives data again
+	EventResumed = "resumed"
+)
+
+// StaleEvent is the notification of a data stream which became stale or resumed
+type StaleEvent struct {
+	Event string `json:"event"`
+	Name  string `json:"name"`
+	// Interval is the expected interval of the data stream
+	Interval string `json:"interval"`
+	// LastIngestion is the time of the last submission of data, if any
+	LastIngestion *time.Time `json:"lastIngestion,omitempty"`
+	// Time is the time of the detection
+	Time time.Time `json:"time"`
+}
+
+// Notifier sends the events of stale data streams, e.g. to a webhook or an MQTT broker
+type Notifier interface {
+	Notify(e StaleEvent) error
+}
+
+// Tracker keeps the time of the last ingestion of each data stream and detects the data streams which receive no data
+// within their expected interval. The times are kept in memory and seeded at the start of the service, e.g. from the
+// latest stored records: a data stream without any ingestion, or without ingestion since its creation, becomes stale
+// once its expected interval has passed since the start or its creation.
+// It must be one of the listeners of the tracked storage.
+type Tracker struct {
+	mutex sync.Mutex
+	start time.Time
+	// creation time of the data streams created after the start
+	created map[string]time.Time
+	last    map[string]time.Time
+	// expected interval of the stale data streams
+	stale     map[string]string
+	notifiers []Notifier
+	events    chan StaleEvent
+}
+
+// NewTracker returns a tracker which sends the events of stale data streams to the given notifiers, once started
+func NewTracker(notifiers ...Notifier) *Tracker {
+	return &Tracker{
+		start:     time.Now(),
+		created:   make(map[string]time.Time),
+		last:      make(map[string]time.Time),
+		stale:     make(map[string]string),
+		notifiers: notifiers,
+		events:    make(chan StaleEvent, staleEventsBuffer),
+	}
+}
+
+// Ingested records the submission of data for the given data streams
+func (t *Tracker) Ingested(names []string, at time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, name := range names {
+		if at.After(t.last[name]) {
+			t.last[name] = at
+		}
+		if interval, stale := t.stale[name]; stale {
+			delete(t.stale, name)
+			last := t.last[name]
+			t.emit(StaleEvent{Event: EventResumed, Name: name, Interval: interval, LastIngestion: &last, Time: at})
+		}
+	}
+}
+
+// Seed sets the time of the last ingestion of a data stream which has not received data since the start of the service
+func (t *Tracker) Seed(name string, at time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, found := t.last[name]; !found {
+		t.last[name] = at
+	}
+}
+
+// emit queues an event for the notifiers without blocking. It requires the mutex.
+func (t *Tracker) emit(e StaleEvent) {
+	if len(t.notifiers) == 0 {
+		return
+	}
+	select {
+	case t.events <- e:
+	default:
+		log.Printf("Stale: dropping the %s event of %s as the notifiers do not keep up", e.Event, e.Name)
+	}
+}
+
+// Check detects the data streams of the storage which received no data within their expected interval until the given
+// time. It returns the events of the data streams which became stale since the previous check.
+func (t *Tracker) Check(storage Storage, now time.Time) ([]StaleEvent, error) {
+	var (
+		events []StaleEvent
+		errs   []string
+	)
+	for page := 1; ; page++ {
+		streams, total, err := storage.GetMany(page, MaxPerPage)
+		if err != nil {
+			return events, fmt.Errorf("error getting data streams: %s", err)
+		}
+		for i := range streams {
+			ds := &streams[i]
+			if ds.Expected == nil || ds.Expected.Interval == "" {
+				t.mutex.Lock()
+				delete(t.stale, ds.Name)
+				t.mutex.Unlock()
+				continue
+			}
+			interval, err := common.ParseDuration(ds.Expected.Interval)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", ds.Name, err))
+				continue
+			}
+			if e := t.check(ds.Name, interval, now); e != nil {
+				events = append(events, *e)
+			}
+		}
+		if page*MaxPerPage >= total {
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return events, fmt.Errorf("error checking data streams: %s", strings.Join(errs, ", "))
+	}
+	return events, nil
+}
+
+// check marks the data stream as stale and returns the event if it has just become stale
+func (t *Tracker) check(name string, interval time.Duration, now time.Time) *StaleEvent {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, stale := t.stale[name]; stale {
+		return nil
+	}
+	since := t.start
+	if created, found := t.created[name]; found {
+		since = created
+	}
+	var lastIngestion *time.Time
+	if last, found := t.last[name]; found {
+		since, lastIngestion = last, &last
+	}
+	if now.Sub(since) <= interval {
+		return nil
+	}
+	t.stale[name] = interval.String()
+	e := StaleEvent{Event: EventStale, Name: name, Interval: interval.String(), LastIngestion: lastIngestion, Time: now}
+	t.emit(e)
+	return &e
+}
+
+// Streams returns a page of the data streams of the storage which are stale, or not stale, in the order of their names
+// Only the stale data streams and the data streams of the page are read from the storage.
+func (t *Tracker) Streams(storage Storage, stale bool, page, perPage int) ([]DataStream, int, error) {
+	t.mutex.Lock()
+	staleNames := make([]string, 0, len(t.stale))
+	for name := range t.stale {
+		staleNames = append(staleNames, name)
+	}
+	t.mutex.Unlock()
+	sort.Strings(staleNames)
+
+	offset := (page - 1) * perPage
+	streams := []DataStream{}
+	if stale {
+		for i := offset; i < len(staleNames) && i < offset+perPage; i++ {
+			ds, err := storage.Get(staleNames[i])
+			if err != nil {
+				return nil, 0, err
+			}
+			streams = append(streams, *ds)
+		}
+		return streams, len(staleNames), nil
+	}
+
+	total, err := storage.getTotal()
+	if err != nil {
+		return nil, 0, err
+	}
+	isStale := make(map[string]bool, len(staleNames))
+	for _, name := range staleNames {
+		isStale[name] = true
+	}
+	// The data streams before the offset include the stale ones named before the data stream at the offset. As many
+	// data streams which are not stale are skipped from the offset, to start the page at the data stream of its rank.
+	skip := -1
+	for index := offset; index < total && len(streams) < perPage; {
+		p := index/MaxPerPage + 1
+		batch, _, err := storage.GetMany(p, MaxPerPage)
+		if err != nil {
+			return nil, 0, err
+		}
+		start := index - (p-1)*MaxPerPage
+		if start >= len(batch) {
+			break
+		}
+		for _, ds := range batch[start:] {
+			index++
+			if skip < 0 {
+				skip = sort.SearchStrings(staleNames, ds.Name)
+			}
+			if isStale[ds.Name] {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			streams = append(streams, ds)
+			if len(streams) == perPage {
+				break
+			}
+		}
+	}
+	return streams, total - len(staleNames), nil
+}
+
+// Start checks the data streams of the storage at every interval and sends the events to the notifiers, until the
+// returned function is called
+func (t *Tracker) Start(storage Storage, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = DefaultStaleCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				events, err := t.Check(storage, time.Now())
+				for _, e := range events {
+					log.Printf("Stale: %s received no data within %s", e.Name, e.Interval)
+				}
+				if err != nil {
+					log.Printf("Stale: %s", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case e := <-t.events:
+				t.notify(e)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+func (t *Tracker) notify(e StaleEvent) {
+	for _, n := range t.notifiers {
+		if err := n.Notify(e); err != nil {
+			log.Printf("Stale: error sending the %s event of %s: %s", e.Event, e.Name, err)
+		}
+	}
+}
+
+// decorate sets the tracked fields of a data stream
+func (t *Tracker) decorate(ds *DataStream) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	ds.LastIngestion, ds.Stale = nil, false
+	if last, found := t.last[ds.Name]; found {
+		ds.LastIngestion = &last
+	}
+	_, ds.Stale = t.stale[ds.Name]
+}
+
+func (t *Tracker) forget(name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.last, name)
+	delete(t.stale, name)
+}
+
+// CreateHandler starts the expected interval of the new data stream
+func (t *Tracker) CreateHandler(new DataStream) error {
+	t.forget(new.Name)
+	t.mutex.Lock()
+	t.created[new.Name] = time.Now()
+	t.mutex.Unlock()
+	return nil
+}
+
+// UpdateHandler does nothing: a changed expected interval applies from the next check
+func (t *Tracker) UpdateHandler(old DataStream, new DataStream) error {
+	return nil
+}
+
+// DeleteHandler discards the tracking of the data stream
+func (t *Tracker) DeleteHandler(old DataStream) error {
+	t.forget(old.Name)
+	t.mutex.Lock()
+	delete(t.created, old.Name)
+	t.mutex.Unlock()
+	return nil
+}
+
+// Wrap returns a storage which returns the data streams with their last ingestion time and staleness
+// The tracked fields are read-only and ignored on submission.
+func (t *Tracker) Wrap(storage Storage) Storage {
+	return &trackedStorage{Storage: storage, tracker: t}
+}
+
+// trackedStorage is a storage which sets the tracked fields of the returned data streams
+type trackedStorage struct {
+	Storage
+	tracker *Tracker
+}
+
+func (s *trackedStorage) Add(ds DataStream) (*DataStream, error) {
+	ds.LastIngestion, ds.Stale = nil, false
+	return s.decorated(s.Storage.Add(ds))
+}
+
+func (s *trackedStorage) Update(name string, ds DataStream) (*DataStream, error) {
+	ds.LastIngestion, ds.Stale = nil, false
+	return s.decorated(s.Storage.Update(name, ds))
+}
+
+func (s *trackedStorage) Get(name string) (*DataStream, error) {
+	return s.decorated(s.Storage.Get(name))
+}
+
+func (s *trackedStorage) FilterOne(path, op, value string) (*DataStream, error) {
+	return s.decorated(s.Storage.FilterOne(path, op, value))
+}
+
+func (s *trackedStorage) GetMany(page, perPage int) ([]DataStream, int, error) {
+	streams, total, err := s.Storage.GetMany(page, perPage)
+	for i := range streams {
+		s.tracker.decorate(&streams[i])
+	}
+	return streams, total, err
+}
+
+func (s *trackedStorage) Filter(path, op, value string, page, perPage int) ([]DataStream, int, error) {
+	streams, total, err := s.Storage.Filter(path, op, value, page, perPage)
+	for i := range streams {
+		s.tracker.decorate(&streams[i])
+	}
+	return streams, total, err
+}
+
+// decorated returns a copy of the data stream with the tracked fields, as the storage may return its own instance
+func (s *trackedStorage) decorated(ds *DataStream, err error) (*DataStream, error) {
+	if err != nil || ds == nil {
+		return ds, err
+	}
+	copied := *ds
+	s.tracker.decorate(&copied)
+	return &copied, nil
+}
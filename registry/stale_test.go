@@ -0,0 +1,183 @@
+// Copyright 2016 Fraunhofer Institute for Applied Information Technology FIT
+
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+)
+
+// discardingNotifier accepts the events, which are then read from the queue of the tracker
+type discardingNotifier struct{}
+
+func (n discardingNotifier) Notify(e StaleEvent) error {
+	return nil
+}
+
+func TestTrackerCheck(t *testing.T) {
+	tracker := NewTracker(discardingNotifier{})
+	storage := tracker.Wrap(NewMemoryStorage(common.RegConf{}, tracker))
+	expected := DataStream{Name: "test/stale/expected", Type: common.FLOAT, Expected: &Expectation{Interval: "10s"}}
+	other := DataStream{Name: "test/stale/other", Type: common.FLOAT}
+	for _, ds := range []DataStream{expected, other} {
+		_, err := storage.Add(ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+
+	for _, c := range []struct {
+		after time.Duration
+		stale []string
+	}{{5 * time.Second, nil}, {11 * time.Second, []string{expected.Name}}, {12 * time.Second, nil}} {
+		events, err := tracker.Check(storage, now.Add(c.after))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != len(c.stale) || len(events) > 0 && (events[0].Name != c.stale[0] || events[0].Event != EventStale) {
+			t.Errorf("After %s: expected stale data streams %v, got %v", c.after, c.stale, events)
+		}
+	}
+	ds, err := storage.Get(expected.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ds.Stale || ds.LastIngestion != nil {
+		t.Errorf("Expected a stale data stream without ingestion, got %v", ds)
+	}
+
+	ingested := now.Add(15 * time.Second)
+	tracker.Ingested([]string{expected.Name}, ingested)
+	ds, err = storage.Get(expected.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.Stale || ds.LastIngestion == nil || !ds.LastIngestion.Equal(ingested) {
+		t.Errorf("Expected the data stream to resume at %s, got %v", ingested, ds)
+	}
+	if e := <-tracker.events; e.Event != EventStale {
+		t.Errorf("Expected the stale event first, got %v", e)
+	}
+	if e := <-tracker.events; e.Event != EventResumed || e.Name != expected.Name || e.Interval != "10s" {
+		t.Errorf("Expected the resumed event, got %v", e)
+	}
+
+	// the interval starts at the last ingestion
+	events, err := tracker.Check(storage, now.Add(24*time.Second))
+	if err != nil || len(events) != 0 {
+		t.Errorf("Expected no stale data stream, got %v: %v", events, err)
+	}
+	events, err = tracker.Check(storage, now.Add(26*time.Second))
+	if err != nil || len(events) != 1 || events[0].LastIngestion == nil || !events[0].LastIngestion.Equal(ingested) {
+		t.Errorf("Expected the data stream to be stale after its last ingestion, got %v: %v", events, err)
+	}
+
+	ts := httptest.NewServer(setupRouter(NewAPI(storage)))
+	defer ts.Close()
+	for query, name := range map[string]string{"true": expected.Name, "false": other.Name} {
+		res, err := http.Get(ts.URL + common.RegistryAPILoc + "?stale=" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reg DataStreamList
+		err = json.NewDecoder(res.Body).Decode(&reg)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reg.Total != 1 || len(reg.Streams) != 1 || reg.Streams[0].Name != name {
+			t.Errorf("stale=%s: expected %s, got %v", query, name, reg)
+		}
+	}
+	res, err := http.Get(ts.URL + common.RegistryAPILoc + "?stale=maybe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Server response is not %v but %v", http.StatusBadRequest, res.StatusCode)
+	}
+}
+
+func TestTrackerStreams(t *testing.T) {
+	tracker := NewTracker()
+	storage := tracker.Wrap(NewMemoryStorage(common.RegConf{}, tracker))
+	var names []string
+	for i := 0; i < 5; i++ {
+		ds := DataStream{Name: fmt.Sprintf("test/stale/%d", i), Type: common.FLOAT, Expected: &Expectation{Interval: "10s"}}
+		_, err := storage.Add(ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, ds.Name)
+	}
+	now := time.Now()
+	// the data streams with an odd number receive data, the others become stale
+	tracker.Ingested([]string{names[1], names[3]}, now.Add(5*time.Second))
+	_, err := tracker.Check(storage, now.Add(12*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		stale         bool
+		page, perPage int
+		expected      []string
+		total         int
+	}{
+		{true, 1, 2, []string{names[0], names[2]}, 3},
+		{true, 2, 2, []string{names[4]}, 3},
+		{true, 3, 2, nil, 3},
+		{false, 1, 1, []string{names[1]}, 2},
+		{false, 2, 1, []string{names[3]}, 2},
+		{false, 3, 1, nil, 2},
+		{false, 1, 10, []string{names[1], names[3]}, 2},
+	} {
+		streams, total, err := tracker.Streams(storage, c.stale, c.page, c.perPage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, ds := range streams {
+			if ds.Stale != c.stale {
+				t.Errorf("stale=%v: got %s with stale=%v", c.stale, ds.Name, ds.Stale)
+			}
+			got = append(got, ds.Name)
+		}
+		if total != c.total || !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("stale=%v, page %d of %d: expected %v of %d, got %v of %d", c.stale, c.page, c.perPage, c.expected, c.total, got, total)
+		}
+	}
+}
+
+func TestTrackerSeed(t *testing.T) {
+	tracker := NewTracker()
+	storage := tracker.Wrap(NewMemoryStorage(common.RegConf{}, tracker))
+	ds := DataStream{Name: "test/stale/seeded", Type: common.FLOAT, Expected: &Expectation{Interval: "10s"}}
+	_, err := storage.Add(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	seeded := now.Add(-5 * time.Second)
+	tracker.Seed(ds.Name, seeded)
+	// an ingestion since the start is not overridden
+	tracker.Seed(ds.Name, now.Add(-time.Hour))
+
+	events, err := tracker.Check(storage, now.Add(4*time.Second))
+	if err != nil || len(events) != 0 {
+		t.Errorf("Expected no stale data stream, got %v: %v", events, err)
+	}
+	events, err = tracker.Check(storage, now.Add(6*time.Second))
+	if err != nil || len(events) != 1 || events[0].LastIngestion == nil || !events[0].LastIngestion.Equal(seeded) {
+		t.Errorf("Expected the data stream to be stale after its seeded ingestion, got %v: %v", events, err)
+	}
+}
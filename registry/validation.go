@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"code.linksmart.eu/hds/historical-datastore/common"
 )
@@ -15,9 +16,12 @@ import (
 // url: readonly
 // data: readonly
 // resource: mandatory, fixed
+// name: mandatory, fixed, must not end in the location of an API under /data/{id}
 // meta: n/a
-// retention: n/a
+// retain: min and max are configured periods, max must not be shorter than min
 // aggregation: id/data readonly
+// duplicates: one of the duplicate policies
+// expected: interval is a positive duration, min and max only for float type, max must not be less than min
 // type: mandatory, fixed
 // format: mandatory
 
@@ -33,6 +37,7 @@ func validateCreation(ds DataStream, conf common.RegConf) error {
 	if !validSenmlName.MatchString(ds.Name) {
 		e.invalid = append(e.invalid, "name")
 	}
+	validateName(ds, &e)
 
 	if ds.Type == "" {
 		e.mandatory = append(e.mandatory, "dataType")
@@ -40,6 +45,12 @@ func validateCreation(ds DataStream, conf common.RegConf) error {
 	if !common.SupportedType(ds.Type) {
 		e.invalid = append(e.invalid, "type")
 	}
+
+	validateSource(ds, &e)
+	validateRetention(ds, conf, &e)
+	validateDuplicates(ds, &e)
+	validateAggregation(ds, &e)
+	validateExpectation(ds, &e)
 	/*
 		var e validationError
 		//TODO: add validation logics
@@ -140,6 +151,12 @@ func validateUpdate(ds DataStream, oldDS DataStream, conf common.RegConf) error
 	if ds.Type != oldDS.Type {
 		e.readOnly = append(e.readOnly, "type")
 	}
+
+	validateSource(ds, &e)
+	validateRetention(ds, conf, &e)
+	validateDuplicates(ds, &e)
+	validateAggregation(ds, &e)
+	validateExpectation(ds, &e)
 	//TODO: add validation logics
 	/*
 
@@ -207,6 +224,109 @@ func validateUpdate(ds DataStream, oldDS DataStream, conf common.RegConf) error
 	return nil
 }
 
+// the APIs located under the data of the data streams, which hide the data streams with names ending in them
+var dataSubAPIs = []string{common.LiveAPILoc, common.LatestAPILoc, common.QualityAPILoc}
+
+func validateName(ds DataStream, e *validationError) {
+	for _, loc := range dataSubAPIs {
+		if ds.Name == strings.TrimPrefix(loc, "/") || strings.HasSuffix(ds.Name, loc) {
+			e.other = append(e.other, fmt.Sprintf("name must not end in %s, which is the location of an API under %s/{id}", loc, common.DataAPILoc))
+		}
+	}
+}
+
+func validateSource(ds DataStream, e *validationError) {
+	if ds.Source.MQTTSource == nil {
+		return
+	}
+	if _, ok := common.SenMLFormat(ds.Source.MQTTSource.Format); !ok {
+		e.other = append(e.other, fmt.Sprintf("source.format must be one of: %s", strings.Join(common.SupportedSenMLMediaTypes(), ", ")))
+	}
+}
+
+func validateRetention(ds DataStream, conf common.RegConf, e *validationError) {
+	var min, max time.Duration
+	var err error
+	if ds.Retention.Min != "" {
+		min, err = common.ParsePeriod(ds.Retention.Min)
+		if err != nil {
+			e.invalid = append(e.invalid, "retain.min")
+		} else if !conf.ConfiguredRetention(ds.Retention.Min) {
+			e.other = append(e.other, fmt.Sprintf("retain.min must be empty or one of the configured periods: %s", strings.Join(conf.RetentionPeriods, ", ")))
+		}
+	}
+	if ds.Retention.Max != "" {
+		max, err = common.ParsePeriod(ds.Retention.Max)
+		if err != nil {
+			e.invalid = append(e.invalid, "retain.max")
+		} else if !conf.ConfiguredRetention(ds.Retention.Max) {
+			e.other = append(e.other, fmt.Sprintf("retain.max must be empty or one of the configured periods: %s", strings.Join(conf.RetentionPeriods, ", ")))
+		}
+	}
+	if min > 0 && max > 0 && max < min {
+		e.other = append(e.other, fmt.Sprintf("Maximum retention (%s) must not be shorter than the minimum retention (%s)", ds.Retention.Max, ds.Retention.Min))
+	}
+}
+
+func validateDuplicates(ds DataStream, e *validationError) {
+	if ds.Duplicates != "" && !common.SupportedDuplicatePolicy(ds.Duplicates) {
+		e.invalid = append(e.invalid, "duplicates")
+	}
+}
+
+func validateAggregation(ds DataStream, e *validationError) {
+	if len(ds.Aggregation) == 0 {
+		return
+	}
+	if ds.Type != common.FLOAT {
+		e.other = append(e.other, "Aggregations are only possible with float type.")
+		return
+	}
+	ids := make(map[string]bool)
+	for _, aggr := range ds.Aggregation {
+		// interval
+		if aggr.Interval == "" {
+			e.mandatory = append(e.mandatory, "aggregation.interval")
+		} else if _, err := common.ParsePeriod(aggr.Interval); err != nil {
+			e.invalid = append(e.invalid, "aggregation.interval")
+		}
+		// aggregates
+		if len(aggr.Aggregates) == 0 {
+			e.mandatory = append(e.mandatory, "aggregation.aggregates")
+		}
+		for _, aggregate := range aggr.Aggregates {
+			if !common.SupportedAggregate(aggregate) {
+				e.invalid = append(e.invalid, "aggregation.aggregate")
+			}
+		}
+		if ids[aggr.ID()] {
+			e.other = append(e.other, fmt.Sprintf("Duplicate aggregation: %s", aggr.ID()))
+		}
+		ids[aggr.ID()] = true
+	}
+}
+
+func validateExpectation(ds DataStream, e *validationError) {
+	if ds.Expected == nil {
+		return
+	}
+	if ds.Expected.Interval != "" {
+		if _, err := common.ParseDuration(ds.Expected.Interval); err != nil {
+			e.invalid = append(e.invalid, "expected.interval")
+		}
+	}
+	if ds.Expected.Min == nil && ds.Expected.Max == nil {
+		return
+	}
+	if ds.Type != common.FLOAT {
+		e.other = append(e.other, "Expected value ranges are only possible with float type.")
+		return
+	}
+	if ds.Expected.Min != nil && ds.Expected.Max != nil && *ds.Expected.Max < *ds.Expected.Min {
+		e.other = append(e.other, fmt.Sprintf("Expected maximum (%v) must not be less than the expected minimum (%v)", *ds.Expected.Max, *ds.Expected.Min))
+	}
+}
+
 // Custom error formatting
 type validationError struct {
 	readOnly  []string
@@ -1,5 +1,63 @@
 package rollups
 
-//func MeanFloat(session, inputStream []stream, outputStream stream) {
-//get the input data from the session
-//}
+import (
+	"math"
+	"sort"
+)
+
+// Aggregate applies an inbuilt aggregate function (see common.SupportedAggregates) to the values of a time bucket
+// The values must not be empty
+func Aggregate(aggregate string, values []float64) float64 {
+	switch aggregate {
+	case "mean":
+		return sum(values) / float64(len(values))
+	case "stddev":
+		return stddev(values)
+	case "sum":
+		return sum(values)
+	case "min":
+		min := values[0]
+		for _, v := range values[1:] {
+			min = math.Min(min, v)
+		}
+		return min
+	case "max":
+		max := values[0]
+		for _, v := range values[1:] {
+			max = math.Max(max, v)
+		}
+		return max
+	case "median":
+		return median(values)
+	}
+	return math.NaN()
+}
+
+func sum(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+// stddev returns the population standard deviation
+func stddev(values []float64) float64 {
+	mean := sum(values) / float64(len(values))
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(squares / float64(len(values)))
+}
+
+func median(values []float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
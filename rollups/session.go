@@ -1,3 +1,4 @@
+// Package rollups implements continuous aggregation of data streams
 package rollups
 
 /*
@@ -6,3 +7,225 @@ Session contains the following:
 	1. The variables of the function
 	2. The time series over time
 */
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/common"
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"github.com/farshidtz/senml"
+)
+
+// SeriesName returns the name of the series which stores an aggregate of a data stream
+// The name cannot collide with the name of a data stream, which must start with an alphanumeric character
+func SeriesName(name string, aggr registry.Aggregation, aggregate string) string {
+	return fmt.Sprintf("_rollup/%s/%s/%s", aggr.Interval, aggregate, name)
+}
+
+// BucketStart returns the start of the time bucket which includes the given SenML time
+// Buckets are aligned to multiples of the interval since the Unix epoch
+func BucketStart(t float64, interval time.Duration) time.Time {
+	ns := int64(t * 1e9)
+	offset := ns % int64(interval)
+	if offset < 0 {
+		offset += int64(interval)
+	}
+	return time.Unix(0, ns-offset)
+}
+
+// BucketEnd returns the last time of the bucket which is representable as SenML time (float seconds)
+func BucketEnd(start time.Time, interval time.Duration) time.Time {
+	end := math.Nextafter(float64(start.Add(interval).UnixNano())/1e9, math.Inf(-1))
+	return time.Unix(0, int64(end*1e9))
+}
+
+// Loader returns the stored records of the data stream within the given time range (inclusive)
+type Loader func(from, to time.Time) (senml.Pack, error)
+
+// Session keeps the variables of the aggregations declared on a DataStream
+type Session struct {
+	sync.Mutex
+	name    string
+	rollups []*rollup
+}
+
+type rollup struct {
+	registry.Aggregation
+	interval time.Duration
+	// the latest time bucket
+	open *bucket
+}
+
+// bucket keeps the running statistics of the records of a time bucket, including those which share a time
+type bucket struct {
+	start time.Time
+	unit  string
+	count int
+	sum   float64
+	// running mean and sum of squared deviations from the mean (Welford's method)
+	mean, squares float64
+	min, max      float64
+	// sorted values, only kept for the median
+	values []float64
+	median bool
+}
+
+// NewSession creates a rollup session for the aggregations of the given data stream
+func NewSession(ds registry.DataStream) (*Session, error) {
+	s := &Session{name: ds.Name}
+	for _, aggr := range ds.Aggregation {
+		interval, err := common.ParsePeriod(aggr.Interval)
+		if err != nil {
+			return nil, err
+		}
+		s.rollups = append(s.rollups, &rollup{Aggregation: aggr, interval: interval})
+	}
+	return s, nil
+}
+
+// Add rolls up the given records of the data stream and returns the aggregated records which need to be stored.
+// The records must be normalized, like the stored records, and already be in the storage: the time buckets which are
+// not kept in the session are loaded from the storage. In-order records only update the latest bucket, out-of-order
+// records cause their bucket to be reloaded.
+// Each record must be added once, after it was stored: overwritten records require the buckets to be rolled up again
+// with Reload.
+func (s *Session) Add(records senml.Pack, load Loader) (senml.Pack, error) {
+	return s.add(records, load, false)
+}
+
+// Reload rolls up the time buckets of the given stored records from the storage, e.g. to roll up a time range page by
+// page, and returns the aggregated records which need to be stored. The buckets loaded by a previous call are not
+// loaded again, so that the records must be given in ascending time order.
+func (s *Session) Reload(records senml.Pack, load Loader) (senml.Pack, error) {
+	return s.add(records, load, true)
+}
+
+func (s *Session) add(records senml.Pack, load Loader, reload bool) (senml.Pack, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var rolled senml.Pack
+	for _, r := range s.rollups {
+		// group the records by time buckets
+		buckets := make(map[time.Time]senml.Pack)
+		for _, record := range records {
+			if record.Value == nil {
+				continue
+			}
+			start := BucketStart(record.Time, r.interval)
+			buckets[start] = append(buckets[start], record)
+		}
+		starts := make([]time.Time, 0, len(buckets))
+		for start := range buckets {
+			starts = append(starts, start)
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+		for _, start := range starts {
+			var b *bucket
+			switch {
+			case r.open != nil && start.Equal(r.open.start) && reload:
+				// loaded with all its records
+				continue
+			case r.open != nil && start.Equal(r.open.start):
+				b = r.open
+				b.add(buckets[start])
+			default:
+				stored, err := load(start, BucketEnd(start, r.interval))
+				if err != nil {
+					return nil, fmt.Errorf("error loading the rollup bucket of %s at %v: %s", s.name, start, err)
+				}
+				b = newBucket(start, stored, r.Aggregation)
+				if r.open == nil || start.After(r.open.start) {
+					r.open = b
+				}
+			}
+			if b.count > 0 {
+				rolled = append(rolled, b.records(s.name, r.Aggregation)...)
+			}
+		}
+	}
+	return rolled, nil
+}
+
+func newBucket(start time.Time, records senml.Pack, aggr registry.Aggregation) *bucket {
+	b := &bucket{start: start}
+	for _, aggregate := range aggr.Aggregates {
+		if aggregate == "median" {
+			b.median = true
+		}
+	}
+	b.add(records)
+	return b
+}
+
+func (b *bucket) add(records senml.Pack) {
+	for _, record := range records {
+		if record.Value == nil {
+			continue
+		}
+		if b.unit == "" {
+			b.unit = record.Unit
+		}
+		v := *record.Value
+		b.count++
+		b.sum += v
+		delta := v - b.mean
+		b.mean += delta / float64(b.count)
+		b.squares += delta * (v - b.mean)
+		if b.count == 1 || v < b.min {
+			b.min = v
+		}
+		if b.count == 1 || v > b.max {
+			b.max = v
+		}
+		if b.median {
+			i := sort.SearchFloat64s(b.values, v)
+			b.values = append(b.values, 0)
+			copy(b.values[i+1:], b.values[i:])
+			b.values[i] = v
+		}
+	}
+}
+
+// aggregate returns the value of an inbuilt aggregate function (see Aggregate) of the bucket
+func (b *bucket) aggregate(aggregate string) float64 {
+	switch aggregate {
+	case "mean":
+		return b.sum / float64(b.count)
+	case "stddev":
+		return math.Sqrt(b.squares / float64(b.count))
+	case "sum":
+		return b.sum
+	case "min":
+		return b.min
+	case "max":
+		return b.max
+	case "median":
+		middle := len(b.values) / 2
+		if len(b.values)%2 == 0 {
+			return (b.values[middle-1] + b.values[middle]) / 2
+		}
+		return b.values[middle]
+	}
+	return math.NaN()
+}
+
+// records returns one record per aggregate, named after the series of the aggregate
+func (b *bucket) records(name string, aggr registry.Aggregation) senml.Pack {
+	pack := make(senml.Pack, 0, len(aggr.Aggregates))
+	for _, aggregate := range aggr.Aggregates {
+		v := b.aggregate(aggregate)
+		pack = append(pack, senml.Record{
+			Name:  SeriesName(name, aggr, aggregate),
+			Unit:  b.unit,
+			Time:  float64(b.start.UnixNano()) / 1e9,
+			Value: &v,
+		})
+	}
+	return pack
+}
@@ -0,0 +1,36 @@
+package rollups
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"code.linksmart.eu/hds/historical-datastore/registry"
+	"github.com/farshidtz/senml"
+)
+
+func TestBucketAggregates(t *testing.T) {
+	values := []float64{4, -1, 7, 7, 2.5, 0}
+	var records senml.Pack
+	for i := range values {
+		records = append(records, senml.Record{Name: "test", Time: float64(i), Value: &values[i]})
+	}
+
+	for _, aggregates := range [][]string{{"mean", "stddev", "sum", "min", "max"}, {"mean", "stddev", "sum", "min", "max", "median"}} {
+		aggr := registry.Aggregation{Interval: "1h", Aggregates: aggregates}
+		// the records are added one by one, like submissions to the latest bucket
+		b := newBucket(time.Unix(0, 0), nil, aggr)
+		for _, r := range records {
+			b.add(senml.Pack{r})
+		}
+		for _, aggregate := range aggregates {
+			expected, got := Aggregate(aggregate, values), b.aggregate(aggregate)
+			if math.Abs(expected-got) > 1e-9 {
+				t.Errorf("%s: expected %v, got %v", aggregate, expected, got)
+			}
+		}
+		if median := aggregates[len(aggregates)-1] == "median"; !median && b.values != nil {
+			t.Errorf("Expected no values to be kept without the median, got %v", b.values)
+		}
+	}
+}
@@ -0,0 +1,582 @@
+package tsdb
+
+import "C"
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+type Boltdb struct {
+	db *bolt.DB
+}
+
+func (bdb *Boltdb) open(config BoltDBConfig) error {
+	var err error
+	mode := config.Mode
+	if mode == 0 {
+		mode = 0600
+	}
+	bdb.db, err = bolt.Open(config.Path, mode, nil)
+	return err
+}
+
+func (bdb Boltdb) Close() error {
+	return bdb.db.Close()
+}
+
+//This function converts a floating point number (which is supported by senml) to a bytearray
+func timeToByteArr(timeVal int64) []byte {
+	buff := make([]byte, 8)
+	binary.BigEndian.PutUint64(buff, uint64(timeVal))
+
+	return buff
+
+}
+
+//This function converts a bytearray floating point number (which is supported by senml)
+func byteArrToTime(byteArr []byte) int64 {
+	//This is set to bigendian so that the timestamp is sorted in binary format.
+	timeVal := int64(binary.BigEndian.Uint64(byteArr))
+	return timeVal
+}
+
+//Entries at the same time as an existing entry are stored with a sequence number, which is appended to the key.
+//The keys of such entries sort after the key of the first entry at that time.
+func entryKey(timeVal int64, seq uint32) []byte {
+	key := timeToByteArr(timeVal)
+	if seq == 0 {
+		return key
+	}
+	buff := make([]byte, 4)
+	binary.BigEndian.PutUint32(buff, seq)
+	return append(key, buff...)
+}
+
+func entrySeq(key []byte) uint32 {
+	if len(key) <= 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(key[8:])
+}
+
+//seekLast moves the cursor to the last entry at or before the given time
+func seekLast(c *bolt.Cursor, timeVal int64) ([]byte, []byte) {
+	if timeVal == math.MaxInt64 {
+		return c.Last()
+	}
+	k, _ := c.Seek(timeToByteArr(timeVal + 1))
+	if k == nil {
+		return c.Last()
+	}
+	return c.Prev()
+}
+
+func (bdb Boltdb) Create(name string) error {
+	if name == "" {
+		return fmt.Errorf("time Series record with Empty name")
+	}
+	return bdb.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte(name))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		return nil
+	})
+}
+func (bdb Boltdb) Add(name string, timeseries TimeSeries) error {
+	if name == "" {
+		return fmt.Errorf("Time Series record with Empty name")
+	}
+	if err := bdb.db.Batch(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		for _, entry := range timeseries {
+
+			err = b.Put(timeToByteArr(entry.Time), entry.Value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (bdb Boltdb) AddWithPolicy(name string, timeseries TimeSeries, policy DuplicatePolicy) ([]int, error) {
+	if name == "" {
+		return nil, fmt.Errorf("Time Series record with Empty name")
+	}
+	var duplicates []int
+	err := bdb.db.Batch(func(tx *bolt.Tx) error {
+		var err error
+		//the function may be called more than once
+		duplicates, err = addEntries(tx, name, timeseries, policy)
+		return err
+	})
+	return duplicates, err
+}
+
+func (bdb Boltdb) AddSeries(series map[string]TimeSeries, policies map[string]DuplicatePolicy) (map[string][]int, error) {
+	var duplicates map[string][]int
+	err := bdb.db.Batch(func(tx *bolt.Tx) error {
+		//the function may be called more than once
+		duplicates = make(map[string][]int)
+		for name, timeseries := range series {
+			if name == "" {
+				return fmt.Errorf("Time Series record with Empty name")
+			}
+			seriesDuplicates, err := addEntries(tx, name, timeseries, policies[name])
+			if len(seriesDuplicates) > 0 {
+				duplicates[name] = seriesDuplicates
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return duplicates, err
+}
+
+//addEntries adds the entries to a series within the transaction and returns the indices of the duplicate entries
+func addEntries(tx *bolt.Tx, name string, timeseries TimeSeries, policy DuplicatePolicy) ([]int, error) {
+	b, err := tx.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return nil, err
+	}
+	//keys of the existing entries at the time of the entry
+	existing := func(timeVal int64) [][]byte {
+		var keys [][]byte
+		prefix := timeToByteArr(timeVal)
+		c := b.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, k)
+		}
+		return keys
+	}
+
+	var duplicates []int
+	if policy == Reject {
+		added := make(map[int64]bool)
+		for i, entry := range timeseries {
+			if added[entry.Time] || len(existing(entry.Time)) > 0 {
+				duplicates = append(duplicates, i)
+			}
+			added[entry.Time] = true
+		}
+		if len(duplicates) > 0 {
+			return duplicates, ErrDuplicate
+		}
+	}
+
+	for i, entry := range timeseries {
+		keys := existing(entry.Time)
+		key := entryKey(entry.Time, 0)
+		if len(keys) > 0 {
+			duplicates = append(duplicates, i)
+			switch policy {
+			case Discard:
+				continue
+			case Keep:
+				key = entryKey(entry.Time, entrySeq(keys[len(keys)-1])+1)
+			default:
+				for _, k := range keys[1:] {
+					if err := b.Delete(k); err != nil {
+						return duplicates, err
+					}
+				}
+			}
+		}
+		err = b.Put(key, entry.Value)
+		if err != nil {
+			return duplicates, err
+		}
+	}
+	return duplicates, nil
+}
+
+func (bdb Boltdb) Query(q Query) (timeSeries TimeSeries, nextEntry *int64, err error) {
+	timeSeries = make([]TimeEntry, 0, q.MaxEntries)
+
+	nextEntry = nil
+	err = bdb.db.View(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(q.Series))
+		if b == nil {
+			return ErrSeriesNotFound
+		}
+
+		c := b.Cursor()
+
+		//Default case : If the sorting is descending
+		first := q.To
+		last := q.From
+		next := c.Prev
+		loopCondition := func(val int64, last int64) bool {
+			return val >= last
+		}
+		//else
+		if strings.Compare(q.Sort, ASC) == 0 {
+			first = q.From
+			last = q.To
+			next = c.Next
+			loopCondition = func(val int64, last int64) bool {
+				return val <= last
+			}
+
+		}
+
+		count := 0
+		// Iterate over the time values
+		k, v := c.Seek(timeToByteArr(first))
+		if strings.Compare(q.Sort, ASC) != 0 {
+			k, v = seekLast(c, first)
+		}
+		for ; k != nil && loopCondition(byteArrToTime(k), last) && count < q.MaxEntries; k, v = next() {
+			if !q.matches(k, v) {
+				continue
+			}
+			record := TimeEntry{byteArrToTime(k), v}
+			timeSeries = append(timeSeries, record)
+			count = count + 1
+		}
+		for ; k != nil && loopCondition(byteArrToTime(k), last) && !q.matches(k, v); k, v = next() {
+		}
+		if count == q.MaxEntries && k != nil && loopCondition(byteArrToTime(k), last) {
+			ne := byteArrToTime(k)
+			nextEntry = &ne
+		}
+		return nil
+	})
+
+	if err != nil {
+		return TimeSeries{}, nil, err
+	}
+
+	return timeSeries, nextEntry, nil
+}
+
+func (bdb Boltdb) QueryOnChannel(q Query) (<-chan TimeEntry, chan *int64, chan error) {
+	resultCh := make(chan TimeEntry, 10)
+	errorCh := make(chan error)
+	nextEntryChan := make(chan *int64)
+
+	go func() {
+		var nextEntry *int64
+		err := bdb.db.View(func(tx *bolt.Tx) error {
+
+			b := tx.Bucket([]byte(q.Series))
+			if b == nil {
+				return ErrSeriesNotFound
+			}
+
+			c := b.Cursor()
+			count := 0
+			if q.Sort == DESC {
+				k, v := seekLast(c, q.To)
+
+				// Iterate over the time values
+				for ; k != nil && byteArrToTime(k) >= q.From && count != q.MaxEntries; k, v = c.Prev() {
+					if !q.matches(k, v) {
+						continue
+					}
+					record := TimeEntry{byteArrToTime(k), v}
+					resultCh <- record
+					count++
+				}
+				for ; k != nil && byteArrToTime(k) >= q.From && !q.matches(k, v); k, v = c.Prev() {
+				}
+				if count == q.MaxEntries && k != nil && byteArrToTime(k) >= q.From {
+					ne := byteArrToTime(k)
+					nextEntry = &ne
+				}
+			} else {
+				k, v := c.Seek(timeToByteArr(q.From))
+				// Iterate over the time values
+				for ; k != nil && byteArrToTime(k) <= q.To && count != q.MaxEntries; k, v = c.Next() {
+					if !q.matches(k, v) {
+						continue
+					}
+					record := TimeEntry{byteArrToTime(k), v}
+					resultCh <- record
+					count = count + 1
+				}
+				for ; k != nil && byteArrToTime(k) <= q.To && !q.matches(k, v); k, v = c.Next() {
+				}
+				if count == q.MaxEntries && k != nil && byteArrToTime(k) <= q.To {
+					ne := byteArrToTime(k)
+					nextEntry = &ne
+				}
+			}
+
+			return nil
+		})
+
+		//make sure you close the resultchannel before error channel
+		close(resultCh)
+		nextEntryChan <- nextEntry
+
+		if err != nil {
+			errorCh <- err
+		}
+		close(errorCh)
+	}()
+
+	return resultCh, nextEntryChan, errorCh
+}
+
+func (bdb Boltdb) GetPages(q Query) ([]int64, int, error) {
+	keyList := make([]int64, 0, 100)
+	count := 0
+
+	err := bdb.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(q.Series))
+		if b == nil {
+			return ErrSeriesNotFound
+		}
+
+		c := b.Cursor()
+
+		first := q.To
+		last := q.From
+		next := c.Prev
+		loopCondition := func(val int64, last int64) bool {
+			return val >= last
+		}
+		if strings.Compare(q.Sort, ASC) == 0 {
+			first = q.From
+			last = q.To
+			next = c.Next
+			loopCondition = func(val int64, last int64) bool {
+				return val <= last
+			}
+
+		}
+
+		// Iterate over the time values
+		k, v := c.Seek(timeToByteArr(first))
+		if strings.Compare(q.Sort, ASC) != 0 {
+			k, v = seekLast(c, first)
+		}
+
+		for ; k != nil && loopCondition(byteArrToTime(k), last); k, v = next() {
+			if !q.matches(k, v) {
+				continue
+			}
+			if count%q.MaxEntries == 0 {
+				keyList = append(keyList, byteArrToTime(k))
+			}
+			count = count + 1
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, 0, err
+	}
+	return keyList, count, nil
+}
+
+func (bdb Boltdb) GetMergedPages(queries []Query) ([]Page, int, error) {
+	var pages []Page
+	count := 0
+	if len(queries) == 0 {
+		return pages, count, nil
+	}
+	q := queries[0]
+	if q.MaxEntries <= 0 {
+		return nil, 0, fmt.Errorf("invalid number of entries per page: %d", q.MaxEntries)
+	}
+
+	err := bdb.db.View(func(tx *bolt.Tx) error {
+		//the next matching key of each series within the time range, nil once exhausted
+		type seriesCursor struct {
+			q    Query
+			c    *bolt.Cursor
+			key  []byte
+			next func() ([]byte, []byte)
+		}
+		inRange := func(k []byte) bool {
+			return byteArrToTime(k) >= q.From && byteArrToTime(k) <= q.To
+		}
+		advance := func(sc *seriesCursor, k []byte, v []byte) {
+			for ; k != nil && inRange(k) && !sc.q.matches(k, v); k, v = sc.next() {
+			}
+			sc.key = nil
+			if k != nil && inRange(k) {
+				sc.key = k
+			}
+		}
+		cursors := make([]*seriesCursor, 0, len(queries))
+		for _, sq := range queries {
+			b := tx.Bucket([]byte(sq.Series))
+			if b == nil {
+				return ErrSeriesNotFound
+			}
+			sc := &seriesCursor{q: sq, c: b.Cursor()}
+			var k, v []byte
+			if q.Sort == DESC {
+				sc.next = sc.c.Prev
+				k, v = seekLast(sc.c, q.To)
+			} else {
+				sc.next = sc.c.Next
+				k, v = sc.c.Seek(timeToByteArr(q.From))
+			}
+			advance(sc, k, v)
+			cursors = append(cursors, sc)
+		}
+
+		var last int64
+		run := 0
+		for {
+			//the earliest key (in the sorting order), ties are resolved in the order of the queries
+			var head *seriesCursor
+			for _, sc := range cursors {
+				if sc.key == nil {
+					continue
+				}
+				if head == nil || q.Sort == DESC && byteArrToTime(sc.key) > byteArrToTime(head.key) ||
+					q.Sort != DESC && byteArrToTime(sc.key) < byteArrToTime(head.key) {
+					head = sc
+				}
+			}
+			if head == nil {
+				return nil
+			}
+			t := byteArrToTime(head.key)
+			if count == 0 || t != last {
+				last, run = t, 0
+			}
+			if count%q.MaxEntries == 0 {
+				pages = append(pages, Page{Time: t, Skip: run})
+			}
+			count++
+			run++
+			k, v := head.next()
+			advance(head, k, v)
+		}
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return pages, count, nil
+}
+
+func (bdb Boltdb) Get(series string) (TimeSeries, error) {
+	timeSeries := make([]TimeEntry, 0, 100)
+	err := bdb.db.View(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(series))
+		if b == nil {
+			return fmt.Errorf("Bucket:%v does not exist", series)
+		}
+
+		err := b.ForEach(func(k, v []byte) error {
+
+			record := TimeEntry{byteArrToTime(k), v}
+			//TODO: 1. This is an inefficient way of keeping the slices. This has to be addressed during the pagination implementation
+			timeSeries = append(timeSeries, record)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return timeSeries, err
+}
+
+func (bdb Boltdb) GetOnChannel(series string) (<-chan TimeEntry, chan error) {
+
+	resultCh := make(chan TimeEntry, 10)
+	errorCh := make(chan error)
+	go func() {
+		//defer close(resultCh)
+		defer close(errorCh)
+		err := bdb.db.View(func(tx *bolt.Tx) error {
+
+			b := tx.Bucket([]byte(series))
+			if b == nil {
+				return fmt.Errorf("Bucket:%v does not exist", series)
+			}
+
+			err := b.ForEach(func(k, v []byte) error {
+				record := TimeEntry{byteArrToTime(k), v}
+				resultCh <- record
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			return err
+		})
+		//make sure you close the resultchannel before error channel
+		close(resultCh)
+		if err != nil {
+			errorCh <- err
+			return
+		}
+	}()
+
+	return resultCh, errorCh
+}
+
+func (bdb Boltdb) Delete(series string) error {
+	return bdb.db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(series))
+		if err == bolt.ErrBucketNotFound {
+			return ErrSeriesNotFound
+		}
+		return err
+	})
+}
+
+func (bdb Boltdb) DeleteRange(series string, from int64, to int64) (int, error) {
+	count := 0
+	err := bdb.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(series))
+		if b == nil {
+			return ErrSeriesNotFound
+		}
+		//Collect the keys first: deleting while iterating makes the cursor skip entries
+		var keys [][]byte
+		c := b.Cursor()
+		collect := func(from int64, to int64) {
+			for k, _ := c.Seek(timeToByteArr(from)); k != nil && byteArrToTime(k) >= from && byteArrToTime(k) <= to; k, _ = c.Next() {
+				keys = append(keys, k)
+			}
+		}
+		//The keys of negative times (before 1970) are sorted after the keys of positive times
+		if from < 0 && to >= 0 {
+			collect(from, -1)
+			collect(0, to)
+		} else {
+			collect(from, to)
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		count = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,8 @@
+package tsdb
+
+import "errors"
+
+var (
+	ErrSeriesNotFound = errors.New("timeseries not found")
+	ErrDuplicate      = errors.New("an entry exists at the same time")
+)
@@ -0,0 +1,8 @@
+module github.com/dschowta/lite.tsdb
+
+go 1.12
+
+require (
+	github.com/boltdb/bolt v1.3.1
+	golang.org/x/sys v0.0.0-20190204203706-41f3e6584952 // indirect
+)
@@ -0,0 +1,131 @@
+package tsdb
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	ASC  = "asc"
+	DESC = "desc"
+)
+
+type BoltDBConfig struct {
+	Path string
+	Mode os.FileMode
+}
+
+type TimeEntry struct {
+	Time  int64
+	Value []byte
+}
+
+type Query struct {
+	Series string
+
+	From int64
+	To   int64
+	//Sorting order:
+	//Possible values are ASC and DESC
+	//ASC : The time Series will have the oldest data first
+	//DESC: The time Series will have the latest  data first.
+	Sort string
+
+	//Number of entries to be returned per page. This is used for pagination.
+	// The next sequence is found out using NextEntry variable of a query response.
+	MaxEntries int
+
+	//Filter selects the entries (optional). The other entries are skipped and not counted.
+	Filter func(entry TimeEntry) bool
+}
+
+//matches returns true if the entry is selected by the filter of the query
+func (q Query) matches(k []byte, v []byte) bool {
+	return q.Filter == nil || q.Filter(TimeEntry{byteArrToTime(k), v})
+}
+
+type TimeSeries []TimeEntry
+
+//Page is the start of a page of entries: the time of its first entry, and the number of entries at that time on the
+//previous pages
+type Page struct {
+	Time int64
+	Skip int
+}
+
+//DuplicatePolicy defines how an entry at the time of an existing entry of the series is added
+type DuplicatePolicy int
+
+const (
+	//Overwrite replaces the existing entries at the same time
+	Overwrite DuplicatePolicy = iota
+	//Reject fails with ErrDuplicate and none of the entries is added
+	Reject
+	//Keep adds the entry after the existing entries at the same time. It is stored with the next sequence number of that time.
+	Keep
+	//Discard does not add the entry, but adds the other entries
+	Discard
+)
+
+type TSDB interface {
+
+	//Create a new bucket
+	Create(name string) error
+
+	//This function adds the senml records
+	Add(name string, timeseries TimeSeries) error
+
+	//Adds the entries and handles those at the time of an existing entry (or of a preceding entry of the time series)
+	//according to the policy. Returns the indices of these duplicate entries.
+	AddWithPolicy(name string, timeseries TimeSeries, policy DuplicatePolicy) (duplicates []int, err error)
+
+	//Adds the entries of multiple series in a single transaction: either all entries are added, or none.
+	//The duplicates of each series are handled according to its policy (default Overwrite). Returns the indices of
+	//the duplicate entries per series.
+	AddSeries(series map[string]TimeSeries, policies map[string]DuplicatePolicy) (duplicates map[string][]int, err error)
+
+	//Get the senml records
+	Query(q Query) (timeSeries TimeSeries, nextEntry *int64, err error)
+
+	QueryOnChannel(q Query) (timeseries <-chan TimeEntry, nextEntry chan *int64, err chan error)
+	//Get the total pages for a particular query.
+	// This helps for any client to call multiple queries
+	GetPages(q Query) (seriesList []int64, count int, err error)
+
+	//Get the pages of the entries of several series, merged in time order. The queries share the time range, the
+	//sorting order and the number of entries per page (MaxEntries), and may filter their series alike. Entries at
+	//the same time are ordered by the series, in the order of the queries. Only the keys of the entries are read,
+	//unless filtered.
+	GetMergedPages(queries []Query) (pages []Page, count int, err error)
+
+	//Get the senml records
+	Get(series string) (timeSeries TimeSeries, err error)
+	//Returns two channels, one for Time entries and one for error.
+	//This avoids the usage of an extra buffer by the database
+	//Caution: first read the channel and then read the error. Error channel shall be written only after the timeseries channel is closed
+	GetOnChannel(series string) (timeseries <-chan TimeEntry, err chan error)
+
+	//Delete a complete Series
+	Delete(series string) error
+
+	//Delete the entries of a series within a time range (inclusive). Returns the number of deleted entries
+	DeleteRange(series string, from int64, to int64) (count int, err error)
+
+	//Close the database
+	Close() error
+}
+
+var ds TSDB        //will be used as a singleton db object
+var once sync.Once //make thread safe singleton
+
+func Open(config interface{}) (TSDB, error) {
+	switch config.(type) {
+	case BoltDBConfig:
+		retDB := new(Boltdb)
+		err := retDB.open(config.(BoltDBConfig))
+		return retDB, err
+	default:
+		return nil, fmt.Errorf("Unsupported storage Configuration")
+	}
+}
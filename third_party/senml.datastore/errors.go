@@ -0,0 +1,13 @@
+package datastore
+
+import (
+	"errors"
+
+	tsdb "github.com/dschowta/lite.tsdb"
+)
+
+var (
+	//ErrSeriesNotFound is also returned by the queries of a series which does not exist
+	ErrSeriesNotFound = tsdb.ErrSeriesNotFound
+	ErrDuplicate      = errors.New("a record exists at the same time")
+)
@@ -0,0 +1,356 @@
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	tsdb "github.com/dschowta/lite.tsdb"
+	"github.com/farshidtz/senml"
+)
+
+const (
+	ASC  = "asc"
+	DESC = "desc"
+)
+
+type SenmlDataStore struct {
+	tsdb tsdb.TSDB
+}
+
+type Query struct {
+	//A comma separated senml names
+	Series string
+
+	From float64
+	To   float64
+	//Sorting order:
+	//Possible values are ASC and DESC
+	//ASC : The time Series will have the oldest data first
+	//DESC: The time Series will have the latest  data first.
+	Sort string
+
+	//Number of entries to be returned per request. This is used for pagination. The next sequence is found out using NextEntry function
+	MaxEntries int
+
+	//Filter selects the records (optional). The other records are skipped and not counted.
+	Filter func(record senml.Record) bool
+}
+
+//tsQuery returns the time series query of the query
+func (query Query) tsQuery() tsdb.Query {
+	tsQuery := tsdb.Query{
+		MaxEntries: query.MaxEntries,
+		Series:     query.Series,
+		Sort:       query.Sort,
+		To:         floatTimeToInt64(query.To),
+		From:       floatTimeToInt64(query.From),
+	}
+	if query.Filter != nil {
+		tsQuery.Filter = func(entry tsdb.TimeEntry) bool {
+			var timeRecord SenMLDBRecord
+			if err := json.Unmarshal(entry.Value, &timeRecord); err != nil {
+				return false
+			}
+			return query.Filter(newSenMLRecord(int64ToFloatTime(entry.Time), query.Series, timeRecord))
+		}
+	}
+	return tsQuery
+}
+
+//DuplicatePolicy defines how a record at the time of an existing record of the series is added
+type DuplicatePolicy = tsdb.DuplicatePolicy
+
+const (
+	//Overwrite replaces the existing records at the same time
+	Overwrite = tsdb.Overwrite
+	//Reject fails with ErrDuplicate and none of the records is added
+	Reject = tsdb.Reject
+	//Keep adds the record after the existing records at the same time
+	Keep = tsdb.Keep
+	//Discard does not add the record, but adds the other records
+	Discard = tsdb.Discard
+)
+
+type SenMLDBRecord struct {
+	Unit        string   `json:"u,omitempty" `
+	UpdateTime  float64  `json:"ut,omitempty"`
+	Value       *float64 `json:"v,omitempty" `
+	StringValue string   `json:"vs,omitempty" `
+	DataValue   string   `json:"vd,omitempty"  `
+	BoolValue   *bool    `json:"vb,omitempty" `
+
+	Sum *float64 `json:"s,omitempty" `
+}
+
+func (bdb *SenmlDataStore) Connect(path string) error {
+	config := tsdb.BoltDBConfig{Path: path}
+	var err error
+	bdb.tsdb, err = tsdb.Open(config)
+	return err
+}
+
+func (bdb SenmlDataStore) Disconnect() error {
+	return bdb.tsdb.Close()
+}
+func NewBoltSenMLRecord(record senml.Record) SenMLDBRecord {
+	return SenMLDBRecord{
+		record.Unit,
+		record.UpdateTime,
+		record.Value,
+		record.StringValue,
+		record.DataValue,
+		record.BoolValue,
+		record.Sum,
+	}
+}
+
+func newSenMLRecord(time float64, name string, record SenMLDBRecord) senml.Record {
+	return senml.Record{
+		Name:        name,
+		Unit:        record.Unit,
+		Time:        time,
+		UpdateTime:  record.UpdateTime,
+		Value:       record.Value,
+		StringValue: record.StringValue,
+		DataValue:   record.DataValue,
+		BoolValue:   record.BoolValue,
+		Sum:         record.Sum,
+	}
+}
+
+func ToSenmlTime(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return int64ToFloatTime(t.UnixNano())
+}
+
+func FromSenmlTime(t float64) time.Time {
+	return time.Unix(0, floatTimeToInt64(t))
+}
+
+//This function converts a floating point number (which is supported by senml) to a bytearray
+func floatTimeToInt64(senmlTime float64) int64 {
+	//sec, frac := math.Modf(senmlTime)
+	return int64(senmlTime * (1e9)) //time.Unix(int64(sec), int64(frac*(1e9))).UnixNano()
+}
+
+//This function converts a bytearray floating point number (which is supported by senml)
+func int64ToFloatTime(timeVal int64) float64 {
+	return float64(timeVal) / 1e9
+}
+
+//Create a new bucket
+func (bdb SenmlDataStore) Create(name string) error {
+	return bdb.tsdb.Create(name)
+}
+
+func (bdb SenmlDataStore) Add(senmlPack senml.Pack) error {
+	return bdb.AddNormalized(senmlPack.Normalize())
+}
+
+//Add the records of a normalized pack without normalizing them again: Normalize takes the times before 1970 as
+//relative times, also those which were already resolved.
+func (bdb SenmlDataStore) AddNormalized(pack senml.Pack) error {
+
+	// Fill the data map with provided data points
+	seriesMap := make(map[string][]tsdb.TimeEntry)
+	for _, r := range pack {
+		if "" != r.Name {
+			b, err := json.Marshal(NewBoltSenMLRecord(r))
+			if err != nil {
+				return err
+			}
+			entry := tsdb.TimeEntry{floatTimeToInt64(r.Time), b}
+
+			seriesMap[r.Name] = append(seriesMap[r.Name], entry)
+		} else {
+			return fmt.Errorf("Senml record with Empty name")
+		}
+
+	}
+
+	for name, series := range seriesMap {
+		err := bdb.tsdb.Add(name, series)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Add the records of multiple series in a single transaction: either all records are added, or none.
+//The records at the time of an existing record (or of a preceding record of the series) are handled according to the
+//policy of the series (default Overwrite). Returns the indices of these duplicate records in the pack of each series.
+func (bdb SenmlDataStore) AddSeries(packs map[string]senml.Pack, policies map[string]DuplicatePolicy) (map[string][]int, error) {
+	seriesMap := make(map[string]tsdb.TimeSeries)
+	for name, pack := range packs {
+		if "" == name {
+			return nil, fmt.Errorf("Senml record with Empty name")
+		}
+		series := make(tsdb.TimeSeries, 0, len(pack))
+		for _, r := range pack.Normalize() {
+			b, err := json.Marshal(NewBoltSenMLRecord(r))
+			if err != nil {
+				return nil, err
+			}
+			series = append(series, tsdb.TimeEntry{floatTimeToInt64(r.Time), b})
+		}
+		seriesMap[name] = series
+	}
+
+	duplicates, err := bdb.tsdb.AddSeries(seriesMap, policies)
+	if err == tsdb.ErrDuplicate {
+		err = ErrDuplicate
+	}
+	return duplicates, err
+}
+
+func (bdb SenmlDataStore) Get(series string) (senml.Pack, error) {
+	var senmlPack senml.Pack
+	timeSeriesCh, errCh := bdb.tsdb.GetOnChannel(series)
+
+	//Check the data channel
+	for timeEntry := range timeSeriesCh {
+		var timeRecord SenMLDBRecord
+		err := json.Unmarshal(timeEntry.Value, &timeRecord)
+		if err != nil {
+			fmt.Printf("Error while unmarshalling %s", err)
+			continue
+		}
+		senmlPack = append(senmlPack, newSenMLRecord(int64ToFloatTime(timeEntry.Time), series, timeRecord))
+	}
+	//Check the error channel
+	err := <-errCh
+
+	return senmlPack, err
+}
+
+//Query the data store for a particular range. This gives the response in multiple pages
+func (bdb SenmlDataStore) Query(query Query) (senml.Pack, *float64, error) {
+	var senmlPack senml.Pack
+	tsQuery := query.tsQuery()
+	timeSeriesCh, nextEntryCh, errCh := bdb.tsdb.QueryOnChannel(tsQuery)
+
+	//Check the data channel
+	for timeEntry := range timeSeriesCh {
+		var timeRecord SenMLDBRecord
+		err := json.Unmarshal(timeEntry.Value, &timeRecord)
+		if err != nil {
+			fmt.Printf("Error while unmarshalling %s", err)
+			continue
+		}
+		senmlPack = append(senmlPack, newSenMLRecord(int64ToFloatTime(timeEntry.Time), query.Series, timeRecord))
+	}
+	//Check the error channel
+	nextEntry := <-nextEntryCh
+	err := <-errCh
+
+	if nextEntry != nil {
+		nextEntryf := int64ToFloatTime(*nextEntry)
+		return senmlPack, &nextEntryf, err
+	} else {
+		return senmlPack, nil, err
+	}
+}
+
+//Query the data store for a particular range without buffering the response. The records are sent on the returned channel.
+//MaxEntries limits the number of records, a negative value means no limit.
+//Caution: first read the records channel until it is closed, then the nextEntry channel and then the error channel
+func (bdb SenmlDataStore) QueryOnChannel(query Query) (<-chan senml.Record, chan *float64, chan error) {
+	tsQuery := query.tsQuery()
+	timeSeriesCh, tsNextEntryCh, tsErrCh := bdb.tsdb.QueryOnChannel(tsQuery)
+
+	recordCh := make(chan senml.Record, 10)
+	nextEntryCh := make(chan *float64)
+	errCh := make(chan error)
+	go func() {
+		//a record which cannot be unmarshalled is left out and reported on the error channel
+		var unmarshalErr error
+		for timeEntry := range timeSeriesCh {
+			var timeRecord SenMLDBRecord
+			err := json.Unmarshal(timeEntry.Value, &timeRecord)
+			if err != nil {
+				if unmarshalErr == nil {
+					unmarshalErr = fmt.Errorf("error while unmarshalling the record at %d of %s: %s", timeEntry.Time, query.Series, err)
+				}
+				continue
+			}
+			recordCh <- newSenMLRecord(int64ToFloatTime(timeEntry.Time), query.Series, timeRecord)
+		}
+		close(recordCh)
+
+		var nextEntryf *float64
+		if nextEntry := <-tsNextEntryCh; nextEntry != nil {
+			f := int64ToFloatTime(*nextEntry)
+			nextEntryf = &f
+		}
+		nextEntryCh <- nextEntryf
+
+		err := <-tsErrCh
+		if err == nil {
+			err = unmarshalErr
+		}
+		errCh <- err
+	}()
+	return recordCh, nextEntryCh, errCh
+}
+
+func (bdb SenmlDataStore) GetPages(query Query) ([]float64, int, error) {
+	tsQuery := query.tsQuery()
+	pages, count, err := bdb.tsdb.GetPages(tsQuery)
+
+	if err != nil {
+		return nil, 0, err
+	}
+	fpages := make([]float64, 0, len(pages))
+	for _, page := range pages {
+		fpages = append(fpages, int64ToFloatTime(page))
+	}
+
+	return fpages, count, nil
+}
+
+//Page is the start of a page of records: the time of its first record, and the number of records at that time on the
+//previous pages
+type Page struct {
+	Time float64
+	Skip int
+}
+
+//GetMergedPages returns the pages of the records of several series, merged in time order, and the total number of
+//records. The queries share the time range, the sorting order and the number of records per page (MaxEntries).
+//Records at the same time are ordered by the series, in the order of the queries.
+func (bdb SenmlDataStore) GetMergedPages(queries []Query) ([]Page, int, error) {
+	tsQueries := make([]tsdb.Query, 0, len(queries))
+	for _, query := range queries {
+		tsQueries = append(tsQueries, query.tsQuery())
+	}
+	pages, count, err := bdb.tsdb.GetMergedPages(tsQueries)
+	if err != nil {
+		return nil, 0, err
+	}
+	fpages := make([]Page, 0, len(pages))
+	for _, page := range pages {
+		fpages = append(fpages, Page{Time: int64ToFloatTime(page.Time), Skip: page.Skip})
+	}
+	return fpages, count, nil
+}
+
+func (bdb *SenmlDataStore) Delete(series string) error {
+	err := bdb.tsdb.Delete(series)
+	if err == tsdb.ErrSeriesNotFound {
+		err = ErrSeriesNotFound
+	}
+	return err
+}
+
+//Delete the records of a series within a time range (inclusive). Returns the number of deleted records
+func (bdb *SenmlDataStore) DeleteRange(series string, from float64, to float64) (int, error) {
+	count, err := bdb.tsdb.DeleteRange(series, floatTimeToInt64(from), floatTimeToInt64(to))
+	if err == tsdb.ErrSeriesNotFound {
+		err = ErrSeriesNotFound
+	}
+	return count, err
+}